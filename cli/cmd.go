@@ -3,7 +3,10 @@ package cli
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 
+	"github.com/customeros/mailsherpa/emailextractor"
 	"github.com/customeros/mailsherpa/mailvalidate"
 )
 
@@ -12,14 +15,27 @@ var version = "dev"
 func PrintUsage() {
 	fmt.Println("Usage: mailsherpa <command> [arguments]")
 	fmt.Println("Commands:")
-	fmt.Println("  <email>")
-	fmt.Println("  domain <domain>")
+	fmt.Println("  <email> [--transcript] [--timing] [--mta-sts]")
+	fmt.Println("  domain <domain> [--tls] [--transcript] [--mta-sts]")
 	fmt.Println("  syntax <email>")
+	fmt.Println("  list <addresses> [--transcript] [--timing] [--mta-sts]")
+	fmt.Println("  extract <file|-> [--transcript] [--timing] [--mta-sts]")
 	fmt.Println("  version")
 }
 
-func VerifyDomain(domain string, printResults bool) mailvalidate.DomainValidation {
+// Options are the flags that change what the CLI reports
+type Options struct {
+	Transcript bool
+	// Infer whether the mailbox exists on catch-all domains from RCPT timing
+	Timing bool
+	// Look up the domain's MTA-STS and TLS-RPT policies
+	MailSecurity bool
+}
+
+func VerifyDomain(domain string, options Options, printResults bool) mailvalidate.DomainValidation {
 	request := BuildRequest(fmt.Sprintf("user@%s", domain))
+	request.CheckMailSecurity = options.MailSecurity
+	request.Transcript.Enabled = options.Transcript
 	domainResults := mailvalidate.ValidateDomain(request)
 	if domainResults.Error != "" {
 		fmt.Println(domainResults.Error)
@@ -31,6 +47,17 @@ func VerifyDomain(domain string, printResults bool) mailvalidate.DomainValidatio
 	return domainResults
 }
 
+// InspectTLS prints the STARTTLS certificate report of the domain's MX hosts
+func InspectTLS(domain string) {
+	request := BuildRequest(fmt.Sprintf("user@%s", domain))
+	report, err := mailvalidate.InspectTLS(request)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printOutput(report)
+}
+
 func VerifySyntax(email string, printResults bool) mailvalidate.SyntaxValidation {
 	syntaxResults := mailvalidate.ValidateEmailSyntax(email)
 
@@ -40,10 +67,90 @@ func VerifySyntax(email string, printResults bool) mailvalidate.SyntaxValidation
 	return syntaxResults
 }
 
-func VerifyEmail(email string) {
+func VerifyEmail(email string, options Options) {
+	printOutput(verifyEmail(email, options))
+}
+
+// VerifyAddressList verifies every address of an address list such as
+// `"Smith, John" <john@acme.com>, jane@acme.com`. Addresses with invalid
+// syntax are reported without probing their domain.
+func VerifyAddressList(list string, options Options) {
+	addresses, err := mailvalidate.ParseAddressList(list)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	results := make([]VerifyListEntry, 0, len(addresses))
+	for _, address := range addresses {
+		entry := VerifyListEntry{
+			DisplayName: address.DisplayName,
+			Group:       address.Group,
+		}
+		if address.Syntax.IsValid {
+			entry.Result = verifyEmail(address.Syntax.CleanEmail, options)
+		} else {
+			entry.Result = VerifyEmailResponse{
+				Email:       address.Address,
+				Deliverable: "false",
+				Syntax:      address.Syntax,
+			}
+		}
+		results = append(results, entry)
+	}
+	printOutput(results)
+}
+
+// ExtractEmails verifies every address found in a text or HTML file, or
+// in stdin when path is "-"
+func ExtractEmails(path string, options Options) {
+	var content []byte
+	var err error
+	if path == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	extracted := emailextractor.Extract(string(content))
+	results := make([]VerifyExtractedEntry, 0, len(extracted))
+	for _, email := range extracted {
+		results = append(results, VerifyExtractedEntry{
+			Obfuscated: email.Obfuscated,
+			Result:     verifyEmail(email.Email, options),
+		})
+	}
+	printOutput(results)
+}
+
+func verifyEmail(email string, options Options) VerifyEmailResponse {
 	request := BuildRequest(email)
+	request.Transcript.Enabled = options.Transcript
 	syntaxResults := VerifySyntax(email, false)
-	domainResults := VerifyDomain(syntaxResults.Domain, false)
+
+	// Timing compares the address itself with the catch-all decoys, so
+	// only then does the domain probe use it
+	domainRequest := request
+	domainRequest.CheckMailSecurity = options.MailSecurity
+	if options.Timing {
+		config := mailvalidate.DefaultTimingInferenceConfig()
+		domainRequest.TimingInference = &config
+	}
+	domainResults := mailvalidate.ValidateDomain(domainRequest)
+	if domainResults.Error != "" {
+		fmt.Println(domainResults.Error)
+	}
+
+	// A domain that can't take mail is likely a typo, so look further. The
+	// DNS is queried anyway, so only suggest domains that take mail
+	if syntaxResults.IsValid && !domainResults.HasMXRecord {
+		syntaxResults.Suggestions = mailvalidate.SuggestDomains(syntaxResults)
+	}
+	syntaxResults.Suggestions = mailvalidate.VerifySuggestionsMX(syntaxResults.Suggestions)
 
 	var domainValdation mailvalidate.DomainValidationParams
 	domainValdation.PrimaryDomain = domainResults.PrimaryDomain
@@ -55,8 +162,7 @@ func VerifyEmail(email string) {
 		fmt.Println(emailResults.Error)
 	}
 
-	response := BuildResponse(email, syntaxResults, domainResults, emailResults)
-	printOutput(response)
+	return BuildResponse(email, syntaxResults, domainResults, emailResults)
 }
 
 func Version() {
@@ -11,19 +11,42 @@ import (
 )
 
 type VerifyEmailResponse struct {
-	Email                 string
-	Deliverable           string
-	IsValidSyntax         bool
-	IsCatchAll            bool
+	Email         string
+	Deliverable   string
+	IsValidSyntax bool
+	// CatchAllConfidence reached the catch-all threshold, from decoys
+	// compared with a codename, or with the address itself with --timing
+	IsCatchAll         bool
+	CatchAllConfidence float64
+	// On catch-all domains, whether RCPT timing suggests the mailbox exists
+	Timing                *mailvalidate.TimingSignal `json:",omitempty"`
 	Provider              string
 	SecureGatewayProvider string
 	IsRisky               bool
 	Risk                  VerifyEmailRisk
 	Syntax                mailvalidate.SyntaxValidation
 	AlternateEmail        mailvalidate.AlternateEmail
+	Canonical             *mailvalidate.CanonicalAddress `json:",omitempty"`
 	RetryValidation       bool
 	Smtp                  mailvalidate.SmtpResponse
 	MailServerHealth      mailvalidate.MailServerHealth
+	Transcript            *mailvalidate.Transcript `json:",omitempty"`
+	// SMTP conversation of the catch-all probe of the domain
+	CatchAllTranscript *mailvalidate.Transcript `json:",omitempty"`
+}
+
+// VerifyListEntry is the verification of one mailbox of an address list
+type VerifyListEntry struct {
+	DisplayName string
+	Group       string `json:",omitempty"`
+	Result      VerifyEmailResponse
+}
+
+// VerifyExtractedEntry is the verification of an address found in a document
+type VerifyExtractedEntry struct {
+	// The address was hidden from scrapers, e.g. "john [at] acme [dot] com"
+	Obfuscated bool
+	Result     VerifyEmailResponse
 }
 
 type VerifyEmailRisk struct {
@@ -32,11 +55,10 @@ type VerifyEmailRisk struct {
 	IsRoleAccount   bool
 	IsMailboxFull   bool
 	IsPrimaryDomain bool
+	IsDisposable    bool
 }
 
 func BuildRequest(email string) mailvalidate.EmailValidationRequest {
-	fromEmail, fromDomain := util.GenerateSenderEmail()
-
 	ok, cleanEmail, _, domain := syntax.NormalizeEmailAddress(email)
 	if !ok {
 		fmt.Println("Invalid email address")
@@ -46,14 +68,40 @@ func BuildRequest(email string) mailvalidate.EmailValidationRequest {
 	dnsFromEmail := domaincheck.CheckDNS(domain)
 	request := mailvalidate.EmailValidationRequest{
 		Email:            cleanEmail,
-		FromDomain:       fromDomain,
-		FromEmail:        fromEmail,
 		CatchAllTestUser: util.GenerateCatchAllUsername(),
 		Dns:              &dnsFromEmail,
 	}
+
+	if pool := loadSenderPool(); pool != nil {
+		request.SenderPool = pool
+	} else {
+		request.FromEmail, request.FromDomain = util.GenerateSenderEmail()
+	}
 	return request
 }
 
+var senderPool *mailvalidate.SenderPool
+
+// loadSenderPool loads the sender identities file named by MAIL_SENDER_POOL
+func loadSenderPool() *mailvalidate.SenderPool {
+	if senderPool != nil {
+		return senderPool
+	}
+
+	path, exists := os.LookupEnv("MAIL_SENDER_POOL")
+	if !exists || path == "" {
+		return nil
+	}
+
+	pool, err := mailvalidate.LoadSenderPool(path)
+	if err != nil {
+		fmt.Printf("Unable to load sender pool: %v\n", err)
+		os.Exit(1)
+	}
+	senderPool = pool
+	return senderPool
+}
+
 func BuildResponse(
 	emailAddress string,
 	syntax mailvalidate.SyntaxValidation,
@@ -65,13 +113,17 @@ func BuildResponse(
 		email.IsRoleAccount ||
 		email.IsMailboxFull ||
 		domain.IsFirewalled ||
+		domain.IsDisposable ||
+		syntax.IsDisposable ||
 		!domain.IsPrimaryDomain {
 
 		isRisky = true
 	}
 
+	var timing *mailvalidate.TimingSignal
 	if domain.IsCatchAll {
 		email.IsDeliverable = "unknown"
+		timing = domain.CatchAll.Timing
 	}
 
 	if syntax.IsSystemGenerated {
@@ -85,6 +137,7 @@ func BuildResponse(
 		IsRoleAccount:   email.IsRoleAccount,
 		IsMailboxFull:   email.IsMailboxFull,
 		IsPrimaryDomain: domain.IsPrimaryDomain,
+		IsDisposable:    domain.IsDisposable || syntax.IsDisposable,
 	}
 
 	cleanEmail := emailAddress
@@ -97,15 +150,20 @@ func BuildResponse(
 		Deliverable:           email.IsDeliverable,
 		IsValidSyntax:         syntax.IsValid,
 		IsCatchAll:            domain.IsCatchAll,
+		CatchAllConfidence:    domain.CatchAll.Confidence,
+		Timing:                timing,
 		Provider:              domain.Provider,
 		SecureGatewayProvider: domain.SecureGatewayProvider,
 		IsRisky:               isRisky,
 		Risk:                  risk,
 		AlternateEmail:        email.AlternateEmail,
+		Canonical:             email.Canonical,
 		RetryValidation:       email.RetryValidation,
 		Syntax:                syntax,
 		Smtp:                  email.SmtpResponse,
 		MailServerHealth:      email.MailServerHealth,
+		Transcript:            email.Transcript,
+		CatchAllTranscript:    domain.Transcript,
 	}
 
 	return response
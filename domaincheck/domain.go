@@ -23,6 +23,14 @@ func CheckDNS(domain string) DNS {
 	var dns DNS
 	var mxErr, spfErr error
 
+	// Mail for a domain literal such as [192.0.2.1] goes straight to that
+	// address, RFC 5321 section 5.1
+	if ip, ok := syntax.DomainLiteralIP(domain); ok {
+		dns.MX = []string{ip}
+		dns.HasA = true
+		return dns
+	}
+
 	dns.HasA = hasAorAAAARecord(domain)
 
 	dns.MX, mxErr = getMXRecordsForDomain(domain)
@@ -328,3 +328,12 @@ func TestPrimaryDomainCheckTimeout(t *testing.T) {
 	assert.Empty(t, primaryDomain,
 		"Should return empty string for timing out domain")
 }
+
+func TestCheckDNSDomainLiteral(t *testing.T) {
+	dns := domaincheck.CheckDNS("[192.0.2.1]")
+	assert.Equal(t, []string{"192.0.2.1"}, dns.MX, "literals are delivered straight to their address")
+	assert.Empty(t, dns.Errors)
+
+	dns = domaincheck.CheckDNS("[IPv6:2001:db8::1]")
+	assert.Equal(t, []string{"2001:db8::1"}, dns.MX)
+}
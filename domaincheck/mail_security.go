@@ -0,0 +1,351 @@
+package domaincheck
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RFC 8461 caps policy files at 64KB
+const maxMtaStsPolicySize = 64 * 1024
+
+// Longest max_age RFC 8461 allows, about a year
+const maxMtaStsMaxAge = 31557600
+
+// MtaStsPolicy is the policy a domain publishes at
+// https://mta-sts.<domain>/.well-known/mta-sts.txt
+type MtaStsPolicy struct {
+	Version string
+	// enforce, testing or none
+	Mode string
+	// MX host patterns. A leading "*." matches exactly one label
+	MX     []string
+	MaxAge int
+}
+
+// MailSecurity is the domain's published mail transport security posture
+type MailSecurity struct {
+	// id= from the _mta-sts TXT record. Empty when MTA-STS isn't published
+	MtaStsId     string
+	MtaStsPolicy *MtaStsPolicy
+	// Senders must use STARTTLS with a valid certificate for the MX hosts.
+	// Informational only: the SMTP probe doesn't enforce the policy, as it
+	// never delivers mail
+	StartTLSRequired bool
+	// MX hosts the policy doesn't cover. Under enforce, mail to them fails
+	MXPolicyMismatch []string
+	// rua= reporting URIs from the _smtp._tls TXT record
+	TlsRptReporting []string
+	Errors          []string
+}
+
+// MtaStsPolicyCache keeps fetched policies for their max_age. A policy is
+// only fetched again once it expires or the domain publishes a new id,
+// RFC 8461 section 3.3.
+type MtaStsPolicyCache struct {
+	mu       sync.Mutex
+	policies map[string]cachedMtaStsPolicy
+}
+
+type cachedMtaStsPolicy struct {
+	id      string
+	policy  MtaStsPolicy
+	expires time.Time
+}
+
+func NewMtaStsPolicyCache() *MtaStsPolicyCache {
+	return &MtaStsPolicyCache{policies: make(map[string]cachedMtaStsPolicy)}
+}
+
+// Get returns the domain's cached policy if it has the given id and hasn't
+// expired
+func (c *MtaStsPolicyCache) Get(domain, id string) (MtaStsPolicy, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.policies[domain]
+	if !ok || cached.id != id || !time.Now().Before(cached.expires) {
+		return MtaStsPolicy{}, false
+	}
+	return cached.policy, true
+}
+
+// Put caches the domain's policy for its max_age
+func (c *MtaStsPolicyCache) Put(domain, id string, policy MtaStsPolicy) {
+	maxAge := policy.MaxAge
+	if maxAge > maxMtaStsMaxAge {
+		maxAge = maxMtaStsMaxAge
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policies[domain] = cachedMtaStsPolicy{
+		id:      id,
+		policy:  policy,
+		expires: time.Now().Add(time.Duration(maxAge) * time.Second),
+	}
+}
+
+var mtaStsPolicies = NewMtaStsPolicyCache()
+
+// CheckMailSecurity looks up the domain's MTA-STS and TLS-RPT records. The
+// policy file is only fetched when the _mta-sts TXT record exists and the
+// policy with its id isn't cached yet.
+func CheckMailSecurity(domain string, mx []string) MailSecurity {
+	var security MailSecurity
+	domain = strings.ToLower(cleanDomain(domain))
+
+	id, err := lookupMtaStsRecord(domain)
+	if err != nil {
+		security.Errors = append(security.Errors, err.Error())
+	}
+	security.MtaStsId = id
+
+	if id != "" {
+		if policy, ok := mtaStsPolicies.Get(domain, id); ok {
+			security.MtaStsPolicy = &policy
+		} else if policy, err := fetchMtaStsPolicy(domain); err != nil {
+			security.Errors = append(security.Errors, err.Error())
+		} else {
+			mtaStsPolicies.Put(domain, id, policy)
+			security.MtaStsPolicy = &policy
+		}
+	}
+
+	reporting, err := lookupTlsRptRecord(domain)
+	if err != nil {
+		security.Errors = append(security.Errors, err.Error())
+	}
+	security.TlsRptReporting = reporting
+
+	evaluateMailSecurity(&security, mx)
+	return security
+}
+
+func evaluateMailSecurity(security *MailSecurity, mx []string) {
+	policy := security.MtaStsPolicy
+	if policy == nil || policy.Mode == "none" {
+		return
+	}
+	security.StartTLSRequired = policy.Mode == "enforce"
+
+	for _, host := range mx {
+		if !policy.MatchesMX(host) {
+			security.MXPolicyMismatch = append(security.MXPolicyMismatch, host)
+		}
+	}
+}
+
+// MatchesMX reports whether the policy allows host as an MX
+func (p MtaStsPolicy) MatchesMX(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.MX {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			label, rest, found := strings.Cut(host, ".")
+			if found && label != "" && rest == suffix {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseMtaStsRecord parses a _mta-sts TXT record and returns its policy id
+func ParseMtaStsRecord(record string) (string, error) {
+	fields := parseTagList(parseTXTRecord(record))
+	if fields["v"] != "STSv1" {
+		return "", errors.New("MTA-STS record is not STSv1")
+	}
+	id := fields["id"]
+	if id == "" || len(id) > 32 || !isAlphanumeric(id) {
+		return "", fmt.Errorf("invalid MTA-STS id %q", id)
+	}
+	return id, nil
+}
+
+// ParseMtaStsPolicy parses the body of an mta-sts.txt policy file
+func ParseMtaStsPolicy(body string) (MtaStsPolicy, error) {
+	var policy MtaStsPolicy
+	hasMaxAge := false
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "version":
+			policy.Version = value
+		case "mode":
+			policy.Mode = value
+		case "mx":
+			policy.MX = append(policy.MX, value)
+		case "max_age":
+			maxAge, err := strconv.Atoi(value)
+			if err != nil || maxAge < 0 {
+				return policy, fmt.Errorf("invalid MTA-STS max_age %q", value)
+			}
+			policy.MaxAge = maxAge
+			hasMaxAge = true
+		}
+	}
+
+	if policy.Version != "STSv1" {
+		return policy, errors.New("MTA-STS policy is not STSv1")
+	}
+	switch policy.Mode {
+	case "enforce", "testing":
+		if len(policy.MX) == 0 {
+			return policy, fmt.Errorf("MTA-STS policy in %s mode has no mx", policy.Mode)
+		}
+	case "none":
+	default:
+		return policy, fmt.Errorf("invalid MTA-STS mode %q", policy.Mode)
+	}
+	if !hasMaxAge {
+		return policy, errors.New("MTA-STS policy has no max_age")
+	}
+	return policy, nil
+}
+
+// ParseTlsRptRecord parses a _smtp._tls TXT record and returns its
+// reporting URIs
+func ParseTlsRptRecord(record string) ([]string, error) {
+	fields := parseTagList(parseTXTRecord(record))
+	if fields["v"] != "TLSRPTv1" {
+		return nil, errors.New("TLS-RPT record is not TLSRPTv1")
+	}
+
+	var reporting []string
+	for _, uri := range strings.Split(fields["rua"], ",") {
+		uri = strings.TrimSpace(uri)
+		if strings.HasPrefix(uri, "mailto:") || strings.HasPrefix(uri, "https:") {
+			reporting = append(reporting, uri)
+		}
+	}
+	if len(reporting) == 0 {
+		return nil, errors.New("TLS-RPT record has no valid rua")
+	}
+	return reporting, nil
+}
+
+func lookupMtaStsRecord(domain string) (string, error) {
+	records, err := net.LookupTXT("_mta-sts." + domain)
+	if isNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error looking up MTA-STS record: %w", err)
+	}
+
+	// More than one STSv1 record means no policy
+	var ids []string
+	var lastErr error
+	for _, record := range records {
+		if !strings.HasPrefix(parseTXTRecord(record), "v=STSv1") {
+			continue
+		}
+		id, err := ParseMtaStsRecord(record)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) > 1 {
+		return "", errors.New("multiple MTA-STS records found")
+	}
+	if len(ids) == 0 {
+		return "", lastErr
+	}
+	return ids[0], nil
+}
+
+func lookupTlsRptRecord(domain string) ([]string, error) {
+	records, err := net.LookupTXT("_smtp._tls." + domain)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error looking up TLS-RPT record: %w", err)
+	}
+	for _, record := range records {
+		if strings.HasPrefix(parseTXTRecord(record), "v=TLSRPTv1") {
+			return ParseTlsRptRecord(record)
+		}
+	}
+	return nil, nil
+}
+
+func fetchMtaStsPolicy(domain string) (MtaStsPolicy, error) {
+	// Policy hosts must not redirect
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Timeout: 5 * time.Second,
+	}
+
+	url := fmt.Sprintf("https://mta-sts.%s/.well-known/mta-sts.txt", domain)
+	resp, err := client.Get(url)
+	if err != nil {
+		return MtaStsPolicy{}, fmt.Errorf("error fetching MTA-STS policy: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return MtaStsPolicy{}, fmt.Errorf("MTA-STS policy returned status %d", resp.StatusCode)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		return MtaStsPolicy{}, errors.New("MTA-STS policy is not text/plain")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxMtaStsPolicySize))
+	if err != nil {
+		return MtaStsPolicy{}, fmt.Errorf("error reading MTA-STS policy: %w", err)
+	}
+	return ParseMtaStsPolicy(string(body))
+}
+
+// isNotFound reports whether a lookup failed because the name has no
+// records, rather than because DNS couldn't answer
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// parseTagList splits "k1=v1; k2=v2" records into a map
+func parseTagList(record string) map[string]string {
+	fields := make(map[string]string)
+	for _, field := range strings.Split(record, ";") {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			continue
+		}
+		fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return fields
+}
+
+func isAlphanumeric(s string) bool {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package domaincheck_test
+
+import (
+	"testing"
+
+	"github.com/customeros/mailsherpa/domaincheck"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMtaStsRecord(t *testing.T) {
+	id, err := domaincheck.ParseMtaStsRecord("v=STSv1; id=20240101T000000;")
+	require.NoError(t, err)
+	assert.Equal(t, "20240101T000000", id)
+
+	_, err = domaincheck.ParseMtaStsRecord("v=STSv2; id=abc")
+	assert.Error(t, err, "wrong version")
+
+	_, err = domaincheck.ParseMtaStsRecord("v=STSv1; id=abc-123")
+	assert.Error(t, err, "id must be alphanumeric")
+}
+
+func TestParseMtaStsPolicy(t *testing.T) {
+	policy, err := domaincheck.ParseMtaStsPolicy("version: STSv1\r\nmode: enforce\r\nmx: mail.acme.com\r\nmx: *.mx.acme.com\r\nmax_age: 604800\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, domaincheck.MtaStsPolicy{
+		Version: "STSv1",
+		Mode:    "enforce",
+		MX:      []string{"mail.acme.com", "*.mx.acme.com"},
+		MaxAge:  604800,
+	}, policy)
+
+	_, err = domaincheck.ParseMtaStsPolicy("version: STSv1\nmode: none\nmax_age: 86400\n")
+	assert.NoError(t, err, "none mode doesn't need mx")
+
+	invalid := map[string]string{
+		"missing mx":      "version: STSv1\nmode: enforce\nmax_age: 86400\n",
+		"unknown mode":    "version: STSv1\nmode: strict\nmx: mail.acme.com\nmax_age: 86400\n",
+		"missing max_age": "version: STSv1\nmode: testing\nmx: mail.acme.com\n",
+		"bad max_age":     "version: STSv1\nmode: testing\nmx: mail.acme.com\nmax_age: soon\n",
+		"missing version": "mode: testing\nmx: mail.acme.com\nmax_age: 86400\n",
+	}
+	for name, body := range invalid {
+		_, err := domaincheck.ParseMtaStsPolicy(body)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestMtaStsPolicyMatchesMX(t *testing.T) {
+	policy := domaincheck.MtaStsPolicy{MX: []string{"mail.acme.com", "*.mx.acme.com"}}
+
+	assert.True(t, policy.MatchesMX("mail.acme.com"))
+	assert.True(t, policy.MatchesMX("MAIL.acme.com."))
+	assert.True(t, policy.MatchesMX("eu1.mx.acme.com"))
+	assert.False(t, policy.MatchesMX("mx.acme.com"), "wildcard needs a label")
+	assert.False(t, policy.MatchesMX("a.eu1.mx.acme.com"), "wildcard matches a single label")
+	assert.False(t, policy.MatchesMX("mail.acme.net"))
+}
+
+func TestParseTlsRptRecord(t *testing.T) {
+	reporting, err := domaincheck.ParseTlsRptRecord("v=TLSRPTv1; rua=mailto:tls@acme.com,https://report.acme.com/tls")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mailto:tls@acme.com", "https://report.acme.com/tls"}, reporting)
+
+	_, err = domaincheck.ParseTlsRptRecord("v=TLSRPTv1; rua=ftp://acme.com")
+	assert.Error(t, err)
+}
+
+func TestMtaStsPolicyCache(t *testing.T) {
+	cache := domaincheck.NewMtaStsPolicyCache()
+	policy := domaincheck.MtaStsPolicy{Version: "STSv1", Mode: "enforce", MX: []string{"mx.acme.com"}, MaxAge: 86400}
+
+	_, ok := cache.Get("acme.com", "20240101")
+	assert.False(t, ok)
+
+	cache.Put("acme.com", "20240101", policy)
+	cached, ok := cache.Get("acme.com", "20240101")
+	require.True(t, ok)
+	assert.Equal(t, policy, cached)
+
+	_, ok = cache.Get("acme.com", "20240202")
+	assert.False(t, ok, "a new id means a new policy")
+
+	cache.Put("globex.com", "1", domaincheck.MtaStsPolicy{Version: "STSv1", Mode: "none"})
+	_, ok = cache.Get("globex.com", "1")
+	assert.False(t, ok, "max_age 0 is not cached")
+}
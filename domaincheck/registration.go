@@ -0,0 +1,91 @@
+package domaincheck
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/customeros/mailsherpa/internal/syntax"
+)
+
+// rdap.org redirects to the registry's RDAP server for the TLD
+const (
+	rdapBootstrapURL    = "https://rdap.org/domain/"
+	maxRdapResponseSize = 1 << 20
+)
+
+var ErrNoRegistrationDate = errors.New("no registration date in RDAP response")
+
+// HasWildcardMX reports whether a made-up subdomain of domain has MX
+// records, i.e. whether any hostname under it accepts mail
+func HasWildcardMX(domain string) bool {
+	label := make([]byte, 8)
+	if _, err := rand.Read(label); err != nil {
+		return false
+	}
+	mx, err := getRawMXRecords(fmt.Sprintf("mailsherpa-%s.%s", hex.EncodeToString(label), domain))
+	return err == nil && len(mx) > 0
+}
+
+// DomainRegistrationDate looks up when the registrable part of domain was
+// registered, over RDAP
+func DomainRegistrationDate(domain string) (time.Time, error) {
+	root, err := syntax.ExtractRootDomain(domain)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	req, err := http.NewRequest(http.MethodGet, rdapBootstrapURL+root, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("RDAP lookup returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRdapResponseSize))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ParseRDAPRegistration(body)
+}
+
+// ParseRDAPRegistration returns the registration event date of an RDAP
+// domain response, RFC 9083 section 4.5
+func ParseRDAPRegistration(body []byte) (time.Time, error) {
+	var response struct {
+		Events []struct {
+			Action string `json:"eventAction"`
+			Date   string `json:"eventDate"`
+		} `json:"events"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return time.Time{}, fmt.Errorf("invalid RDAP response: %w", err)
+	}
+
+	for _, event := range response.Events {
+		if !strings.EqualFold(event.Action, "registration") {
+			continue
+		}
+		registered, err := time.Parse(time.RFC3339, event.Date)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid registration date %q: %w", event.Date, err)
+		}
+		return registered, nil
+	}
+	return time.Time{}, ErrNoRegistrationDate
+}
@@ -0,0 +1,34 @@
+package domaincheck_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/customeros/mailsherpa/domaincheck"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRDAPRegistration(t *testing.T) {
+	body := []byte(`{
+		"objectClassName": "domain",
+		"ldhName": "EXAMPLE.COM",
+		"events": [
+			{"eventAction": "expiration", "eventDate": "2025-08-13T04:00:00Z"},
+			{"eventAction": "registration", "eventDate": "1995-08-14T04:00:00Z"},
+			{"eventAction": "last changed", "eventDate": "2024-08-14T07:01:34Z"}
+		]
+	}`)
+	registered, err := domaincheck.ParseRDAPRegistration(body)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(1995, 8, 14, 4, 0, 0, 0, time.UTC), registered)
+
+	_, err = domaincheck.ParseRDAPRegistration([]byte(`{"events": []}`))
+	assert.ErrorIs(t, err, domaincheck.ErrNoRegistrationDate)
+
+	_, err = domaincheck.ParseRDAPRegistration([]byte(`not json`))
+	assert.Error(t, err)
+
+	_, err = domaincheck.ParseRDAPRegistration([]byte(`{"events": [{"eventAction": "registration", "eventDate": "yesterday"}]}`))
+	assert.Error(t, err)
+}
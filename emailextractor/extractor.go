@@ -0,0 +1,162 @@
+package emailextractor
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/customeros/mailsherpa/internal/canonical"
+	"github.com/customeros/mailsherpa/internal/syntax"
+)
+
+// ExtractedEmail is a distinct address found in a document
+type ExtractedEmail struct {
+	// Normalized with syntax.NormalizeEmailAddress
+	Email string
+	// Only found after undoing obfuscation, e.g. "john [at] acme [dot] com",
+	// HTML entities or a percent-encoded mailto: link
+	Obfuscated bool
+}
+
+var (
+	candidatePattern = regexp.MustCompile(`[\p{L}\p{N}._%+'-]+@[\p{L}\p{N}](?:[\p{L}\p{N}-]*[\p{L}\p{N}])?(?:\.[\p{L}\p{N}](?:[\p{L}\p{N}-]*[\p{L}\p{N}])?)+`)
+	mailtoPattern    = regexp.MustCompile(`(?i)mailto:([^\s"'<>]+)`)
+
+	// john [at] acme [dot] com, john(at)acme(dot)com, john {@} acme . com
+	bracketedAt  = regexp.MustCompile(`(?i)\s*[\[({<]\s*(?:at|@)\s*[\])}>]\s*`)
+	bracketedDot = regexp.MustCompile(`(?i)\s*[\[({<]\s*(?:dot|\.)\s*[\])}>]\s*`)
+	// john AT acme DOT com. A lowercase "at" is ordinary prose, as in
+	// "look at example dot com", so only the shouted form counts
+	spelledOut = regexp.MustCompile(`\b([\p{L}\p{N}._%+-]+)\s+AT\s+([\p{L}\p{N}-]+(?:\s+DOT\s+[\p{L}\p{N}-]+)+)\b`)
+	// john [at] acme dot com: spelled dots right after an explicit @
+	spelledDomain = regexp.MustCompile(`(?i)@([\p{L}\p{N}-]+(?:\s+dot\s+[\p{L}\p{N}-]+)+)\b`)
+	spelledDot    = regexp.MustCompile(`(?i)\s+dot\s+`)
+
+	htmlMarkers = regexp.MustCompile(`(?i)<(?:!doctype|html|head|body|div|p|a|br|span|table|td)[\s/>]`)
+	htmlSkipped = regexp.MustCompile(`(?is)<(script|style)\b.*?</(?:script|style)\s*>|<!--.*?-->`)
+	htmlTag     = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	// Inline tags don't break words, as in press<span>@</span>acme.com
+	htmlInline    = regexp.MustCompile(`(?i)</?(?:span|b|i|u|em|strong|small|font)\b[^>]*>`)
+	htmlLinebreak = regexp.MustCompile(`(?i)<(?:br|/p|/div|/li|/tr|/td)\b[^>]*>`)
+)
+
+// Extract finds the addresses in text or HTML, picking the HTML extractor
+// when the input looks like markup
+func Extract(input string) []ExtractedEmail {
+	if htmlMarkers.MatchString(input) {
+		return ExtractHTML(input)
+	}
+	return ExtractText(input)
+}
+
+// ExtractText finds the addresses in free text, such as signatures or
+// PDFs converted to text. Addresses are returned in order of first
+// appearance, deduplicated on their canonical form, e.g. john.doe@gmail.com
+// and johndoe+news@gmail.com are one address.
+func ExtractText(text string) []ExtractedEmail {
+	return extract(text, html.UnescapeString(text))
+}
+
+// ExtractHTML finds the addresses in an HTML page, in its text as well as
+// in mailto: links. Scripts, styles and comments are ignored.
+func ExtractHTML(page string) []ExtractedEmail {
+	page = htmlSkipped.ReplaceAllString(page, " ")
+
+	// Decode mailto: hrefs before their quotes and entities are lost
+	var links []string
+	for _, match := range mailtoPattern.FindAllStringSubmatch(page, -1) {
+		links = append(links, "mailto:"+html.UnescapeString(match[1]))
+	}
+
+	text := htmlLinebreak.ReplaceAllString(page, "\n")
+	text = htmlInline.ReplaceAllString(text, "")
+	text = htmlTag.ReplaceAllString(text, " ")
+	text = html.UnescapeString(text)
+
+	return extract(page, strings.Join(links, "\n")+"\n"+text)
+}
+
+// normalize returns the clean form of an address, and its canonical form
+// to deduplicate on
+func normalize(candidate string) (cleanEmail, key string, ok bool) {
+	ok, cleanEmail, _, _ = syntax.NormalizeEmailAddress(candidate)
+	if !ok {
+		return "", "", false
+	}
+	key = cleanEmail
+	if address, err := canonical.Canonicalize(cleanEmail, ""); err == nil {
+		key = address.Canonical
+	}
+	return cleanEmail, key, true
+}
+
+// extract collects the addresses of decoded. Those that don't also appear
+// in raw, the input as given, are marked obfuscated.
+func extract(raw, decoded string) []ExtractedEmail {
+	plain := make(map[string]bool)
+	for _, candidate := range candidates(raw) {
+		if _, key, ok := normalize(candidate); ok {
+			plain[key] = true
+		}
+	}
+
+	var found []string
+	found = append(found, mailtoAddresses(decoded)...)
+	found = append(found, candidates(deobfuscate(decoded))...)
+
+	var emails []ExtractedEmail
+	seen := make(map[string]bool)
+	for _, candidate := range found {
+		cleanEmail, key, ok := normalize(candidate)
+		if !ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+		emails = append(emails, ExtractedEmail{
+			Email:      cleanEmail,
+			Obfuscated: !plain[key],
+		})
+	}
+	return emails
+}
+
+func candidates(text string) []string {
+	matches := candidatePattern.FindAllString(text, -1)
+	for i, match := range matches {
+		at := strings.LastIndex(match, "@")
+		// Punctuation hugging the address in prose isn't part of it
+		matches[i] = strings.Trim(match[:at], ".'-") + match[at:]
+	}
+	return matches
+}
+
+// mailtoAddresses returns the recipients of the mailto: URLs in text,
+// percent-decoded, RFC 6068
+func mailtoAddresses(text string) []string {
+	var addresses []string
+	for _, match := range mailtoPattern.FindAllStringSubmatch(text, -1) {
+		to, _, _ := strings.Cut(match[1], "?")
+		to, err := url.PathUnescape(to)
+		if err != nil {
+			continue
+		}
+		for _, address := range strings.Split(to, ",") {
+			addresses = append(addresses, strings.TrimSpace(address))
+		}
+	}
+	return addresses
+}
+
+// deobfuscate rewrites common anti-scraping spellings of @ and . back
+func deobfuscate(text string) string {
+	text = bracketedAt.ReplaceAllString(text, "@")
+	text = bracketedDot.ReplaceAllString(text, ".")
+	text = spelledOut.ReplaceAllStringFunc(text, func(match string) string {
+		parts := spelledOut.FindStringSubmatch(match)
+		return parts[1] + "@" + spelledDot.ReplaceAllString(parts[2], ".")
+	})
+	return spelledDomain.ReplaceAllStringFunc(text, func(match string) string {
+		return spelledDot.ReplaceAllString(match, ".")
+	})
+}
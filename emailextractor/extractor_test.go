@@ -0,0 +1,93 @@
+package emailextractor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []ExtractedEmail
+	}{
+		{
+			name: "plain addresses in prose",
+			text: "Reach John (john.smith@acme.com) or sales@acme.com. Thanks!",
+			want: []ExtractedEmail{
+				{Email: "john.smith@acme.com"},
+				{Email: "sales@acme.com"},
+			},
+		},
+		{
+			name: "bracketed obfuscation",
+			text: "Email: john [at] acme [dot] com, jane(at)acme(dot)co(dot)uk",
+			want: []ExtractedEmail{
+				{Email: "john@acme.com", Obfuscated: true},
+				{Email: "jane@acme.co.uk", Obfuscated: true},
+			},
+		},
+		{
+			name: "spelled out obfuscation",
+			text: "write to john AT acme DOT com or jane [at] acme dot co dot uk",
+			want: []ExtractedEmail{
+				{Email: "john@acme.com", Obfuscated: true},
+				{Email: "jane@acme.co.uk", Obfuscated: true},
+			},
+		},
+		{
+			name: "ordinary sentences aren't addresses",
+			text: "Take a look at example dot com. We met at noon dot. Stay at home dot com era ended.",
+			want: nil,
+		},
+		{
+			name: "duplicates are merged on the canonical form",
+			text: "John.Doe@Gmail.com, johndoe+news@gmail.com; john.doe@googlemail.com",
+			want: []ExtractedEmail{{Email: "john.doe@gmail.com"}},
+		},
+		{
+			name: "html entities and mailto",
+			text: "john&#64;acme.com mailto:jane%40acme.com?subject=Hi",
+			want: []ExtractedEmail{
+				{Email: "jane@acme.com", Obfuscated: true},
+				{Email: "john@acme.com", Obfuscated: true},
+			},
+		},
+		{
+			name: "file names aren't addresses",
+			text: "logo@2x.png and icon@3x.jpg",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ExtractText(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractText() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractHTML(t *testing.T) {
+	page := `<!DOCTYPE html>
+<html><head><style>a[href^="mailto:"] { color: red }</style></head>
+<body>
+  <p>Contact <a href="mailto:Sales@Acme.com?subject=Hello">our sales team</a></p>
+  <p>Support: support&#64;acme&#46;com</p>
+  <p>Press: press<span>@</span>acme.com</p>
+  <!-- old@acme.com -->
+  <script>var x = "tracker@analytics.com";</script>
+</body></html>`
+
+	want := []ExtractedEmail{
+		{Email: "sales@acme.com"},
+		{Email: "support@acme.com", Obfuscated: true},
+		{Email: "press@acme.com", Obfuscated: true},
+	}
+	got := Extract(page)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Extract() = %+v, want %+v", got, want)
+	}
+}
@@ -6,8 +6,10 @@ require (
 	github.com/BurntSushi/toml v1.4.0
 	github.com/lucasepe/codename v0.2.0
 	github.com/pkg/errors v0.9.1
-	golang.org/x/exp v0.0.0-20240719175910-8a7402abbf56
+	github.com/rdegges/go-ipify v0.0.0-20150526035502-2d94a6a86c40
+	github.com/stretchr/testify v1.9.0
 	golang.org/x/net v0.28.0
+	golang.org/x/text v0.17.0
 )
 
 require (
@@ -15,12 +17,9 @@ require (
 	github.com/jpillora/backoff v1.0.0 // indirect
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/rdegges/go-ipify v0.0.0-20150526035502-2d94a6a86c40 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
 	github.com/schollz/progressbar/v3 v3.14.6 // indirect
-	github.com/stretchr/testify v1.9.0 // indirect
 	golang.org/x/sys v0.23.0 // indirect
 	golang.org/x/term v0.23.0 // indirect
-	golang.org/x/text v0.17.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
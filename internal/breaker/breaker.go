@@ -0,0 +1,307 @@
+package breaker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	StateClosed   State = "closed"
+	StateOpen     State = "open"
+	StateHalfOpen State = "half-open"
+)
+
+type Config struct {
+	// Consecutive blacklistings, 421s, timeouts or connection failures before
+	// the circuit opens
+	FailureThreshold int
+	// How long the circuit stays open before letting a trial probe through
+	Cooldown time.Duration
+	// Key circuits by provider instead of MX host when the provider is known
+	ByProvider bool
+}
+
+func DefaultConfig() Config {
+	return Config{
+		FailureThreshold: 5,
+		Cooldown:         5 * time.Minute,
+	}
+}
+
+// OpenError is returned by Allow while a circuit is open, or half-open
+// with its trial probe still running
+type OpenError struct {
+	Key    string
+	State  State
+	Reason string
+	// When the circuit lets a trial probe through. Zero while half-open, as
+	// that depends on the outcome of the pending trial
+	RetryAt time.Time
+}
+
+func (e *OpenError) Error() string {
+	if e.RetryAt.IsZero() {
+		return fmt.Sprintf("circuit breaker %s for %s: %s", e.State, e.Key, e.Reason)
+	}
+	return fmt.Sprintf("circuit breaker open for %s until %s: %s",
+		e.Key, e.RetryAt.UTC().Format(time.RFC3339), e.Reason)
+}
+
+// Circuit is a snapshot of a single circuit
+type Circuit struct {
+	Key                 string
+	State               State
+	ConsecutiveFailures int
+	LastFailure         string
+	OpenedAt            time.Time
+}
+
+// Metrics counts breaker activity since it was created
+type Metrics struct {
+	Opened    int
+	HalfOpens int
+	Closed    int
+	Rejected  int
+	Circuits  []Circuit
+}
+
+// Breaker tracks a circuit per MX host (or provider). A circuit opens after
+// FailureThreshold consecutive failures, rejects probes while open and lets
+// a single trial probe through once the cooldown has passed.
+type Breaker struct {
+	config Config
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+	metrics  Metrics
+	now      func() time.Time
+}
+
+type circuit struct {
+	state       State
+	failures    int
+	lastFailure string
+	openedAt    time.Time
+	trialActive bool
+	// Counts how often the circuit opened, so probes admitted before that
+	// can be told apart
+	generation int
+}
+
+// Probe is a probe let through by Admit. Its outcome is reported once with
+// Success, Failure or Cancel
+type Probe struct {
+	breaker    *Breaker
+	key        string
+	trial      bool
+	generation int
+}
+
+func New(config Config) *Breaker {
+	return &Breaker{
+		config:   config,
+		circuits: make(map[string]*circuit),
+	}
+}
+
+// Key returns the circuit key for an MX host and its provider
+func (b *Breaker) Key(mxHost, provider string) string {
+	if b.config.ByProvider && provider != "" {
+		return provider
+	}
+	return mxHost
+}
+
+// Allow reports whether a probe against key may go ahead. A nil error
+// while half-open means the caller holds the single trial probe and must
+// report its outcome with RecordSuccess, RecordFailure or Cancel.
+func (b *Breaker) Allow(key string) error {
+	_, err := b.Admit(key)
+	return err
+}
+
+// Admit is Allow for callers that report the outcome through the returned
+// Probe. Outcomes of probes admitted before the circuit opened are then
+// told apart from the trial's.
+func (b *Breaker) Admit(key string) (*Probe, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(key)
+	now := b.clock()
+	probe := &Probe{breaker: b, key: key, generation: c.generation}
+
+	switch c.state {
+	case StateOpen:
+		retryAt := c.openedAt.Add(b.config.Cooldown)
+		if now.Before(retryAt) {
+			b.metrics.Rejected++
+			return nil, &OpenError{Key: key, State: StateOpen, Reason: c.lastFailure, RetryAt: retryAt}
+		}
+		c.state = StateHalfOpen
+		c.trialActive = true
+		b.metrics.HalfOpens++
+		probe.trial = true
+	case StateHalfOpen:
+		if c.trialActive {
+			b.metrics.Rejected++
+			return nil, &OpenError{Key: key, State: StateHalfOpen, Reason: "waiting for trial probe"}
+		}
+		c.trialActive = true
+		probe.trial = true
+	}
+	return probe, nil
+}
+
+func (b *Breaker) RecordSuccess(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.close(b.circuit(key))
+}
+
+// RecordFailure counts a failure against key. While half-open it is taken
+// as the trial's, while open it changes nothing but the reason.
+func (b *Breaker) RecordFailure(key, reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(key)
+	c.failures++
+	c.lastFailure = reason
+	switch c.state {
+	case StateClosed:
+		if c.failures >= b.config.FailureThreshold {
+			b.open(c)
+		}
+	case StateHalfOpen:
+		b.open(c)
+	}
+}
+
+// Cancel releases a trial probe that never reached the server
+func (b *Breaker) Cancel(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c, ok := b.circuits[key]; ok {
+		c.trialActive = false
+	}
+}
+
+// Success closes the circuit, unless the probe was admitted before it
+// opened
+func (p *Probe) Success() {
+	b := p.breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(p.key)
+	if p.stale(c) {
+		return
+	}
+	b.close(c)
+}
+
+// Failure counts against the circuit. A probe admitted before the circuit
+// opened only updates the reason, so it neither pushes back the cooldown
+// nor ends the trial.
+func (p *Probe) Failure(reason string) {
+	b := p.breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(p.key)
+	c.lastFailure = reason
+	if p.stale(c) {
+		return
+	}
+	c.failures++
+	if c.state == StateHalfOpen || c.failures >= b.config.FailureThreshold {
+		b.open(c)
+	}
+}
+
+// Cancel reports a probe whose outcome says nothing about the host. A
+// trial probe frees the slot for the next one.
+func (p *Probe) Cancel() {
+	b := p.breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c, ok := b.circuits[p.key]; ok && p.trial && !p.stale(c) {
+		c.trialActive = false
+	}
+}
+
+// stale reports a probe admitted before the circuit last opened, or a
+// regular probe reporting while a trial is under way
+func (p *Probe) stale(c *circuit) bool {
+	return p.generation != c.generation || (c.state != StateClosed && !p.trial)
+}
+
+// open moves a closed or half-open circuit to open
+func (b *Breaker) open(c *circuit) {
+	b.metrics.Opened++
+	c.state = StateOpen
+	c.openedAt = b.clock()
+	c.trialActive = false
+	c.generation++
+}
+
+func (b *Breaker) close(c *circuit) {
+	if c.state != StateClosed {
+		b.metrics.Closed++
+	}
+	c.state = StateClosed
+	c.failures = 0
+	c.trialActive = false
+}
+
+func (b *Breaker) State(key string) State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[key]
+	if !ok {
+		return StateClosed
+	}
+	return c.state
+}
+
+func (b *Breaker) Metrics() Metrics {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	metrics := b.metrics
+	metrics.Circuits = make([]Circuit, 0, len(b.circuits))
+	for key, c := range b.circuits {
+		metrics.Circuits = append(metrics.Circuits, Circuit{
+			Key:                 key,
+			State:               c.state,
+			ConsecutiveFailures: c.failures,
+			LastFailure:         c.lastFailure,
+			OpenedAt:            c.openedAt,
+		})
+	}
+	return metrics
+}
+
+func (b *Breaker) circuit(key string) *circuit {
+	c, ok := b.circuits[key]
+	if !ok {
+		c = &circuit{state: StateClosed}
+		b.circuits[key] = c
+	}
+	return c
+}
+
+func (b *Breaker) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,157 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBreaker(config Config) (*Breaker, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	b := New(config)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	b, _ := newTestBreaker(Config{FailureThreshold: 3, Cooldown: time.Minute})
+	key := "mx1.acme.com"
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, b.Allow(key))
+		b.RecordFailure(key, "connection timed out")
+	}
+	assert.Equal(t, StateClosed, b.State(key))
+
+	require.NoError(t, b.Allow(key))
+	b.RecordFailure(key, "blacklisted: blocked using Spamhaus")
+	assert.Equal(t, StateOpen, b.State(key))
+
+	err := b.Allow(key)
+	var openErr *OpenError
+	require.True(t, errors.As(err, &openErr))
+	assert.Equal(t, key, openErr.Key)
+	assert.Contains(t, openErr.Error(), "Spamhaus")
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	b, _ := newTestBreaker(Config{FailureThreshold: 2, Cooldown: time.Minute})
+	key := "mx1.acme.com"
+
+	b.RecordFailure(key, "timeout")
+	b.RecordSuccess(key)
+	b.RecordFailure(key, "timeout")
+	assert.Equal(t, StateClosed, b.State(key))
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	b, now := newTestBreaker(Config{FailureThreshold: 1, Cooldown: time.Minute})
+	key := "mx1.acme.com"
+
+	b.RecordFailure(key, "timeout")
+	assert.Error(t, b.Allow(key))
+
+	*now = now.Add(2 * time.Minute)
+	require.NoError(t, b.Allow(key), "trial probe should be allowed after cooldown")
+	assert.Equal(t, StateHalfOpen, b.State(key))
+	err := b.Allow(key)
+	var openErr *OpenError
+	require.True(t, errors.As(err, &openErr), "only one trial probe at a time")
+	assert.Equal(t, StateHalfOpen, openErr.State)
+	assert.True(t, openErr.RetryAt.IsZero(), "the retry time depends on the pending trial")
+	assert.Equal(t, "circuit breaker half-open for mx1.acme.com: waiting for trial probe", openErr.Error())
+
+	b.RecordFailure(key, "timeout")
+	assert.Equal(t, StateOpen, b.State(key), "failed trial should reopen the circuit")
+
+	*now = now.Add(2 * time.Minute)
+	require.NoError(t, b.Allow(key))
+	b.RecordSuccess(key)
+	assert.Equal(t, StateClosed, b.State(key))
+
+	metrics := b.Metrics()
+	assert.Equal(t, 2, metrics.Opened)
+	assert.Equal(t, 2, metrics.HalfOpens)
+	assert.Equal(t, 1, metrics.Closed)
+	assert.Equal(t, 2, metrics.Rejected)
+	require.Len(t, metrics.Circuits, 1)
+	assert.Equal(t, StateClosed, metrics.Circuits[0].State)
+}
+
+func TestBreakerCancelReleasesTrial(t *testing.T) {
+	b, now := newTestBreaker(Config{FailureThreshold: 1, Cooldown: time.Minute})
+	key := "mx1.acme.com"
+
+	b.RecordFailure(key, "timeout")
+	*now = now.Add(2 * time.Minute)
+	require.NoError(t, b.Allow(key))
+
+	b.Cancel(key)
+	assert.NoError(t, b.Allow(key), "cancelled trial should free the slot")
+}
+
+func TestBreakerFailureWhileOpenKeepsCooldown(t *testing.T) {
+	b, now := newTestBreaker(Config{FailureThreshold: 1, Cooldown: time.Minute})
+	key := "mx1.acme.com"
+
+	b.RecordFailure(key, "timeout")
+	*now = now.Add(50 * time.Second)
+	b.RecordFailure(key, "timeout")
+
+	*now = now.Add(20 * time.Second)
+	assert.NoError(t, b.Allow(key), "a failure while open shouldn't push back the cooldown")
+	assert.Equal(t, 1, b.Metrics().Opened)
+}
+
+func TestProbeStaleFailure(t *testing.T) {
+	b, now := newTestBreaker(Config{FailureThreshold: 1, Cooldown: time.Minute})
+	key := "mx1.acme.com"
+
+	stale, err := b.Admit(key)
+	require.NoError(t, err)
+	failing, err := b.Admit(key)
+	require.NoError(t, err)
+	failing.Failure("timeout")
+	require.Equal(t, StateOpen, b.State(key))
+
+	*now = now.Add(2 * time.Minute)
+	trial, err := b.Admit(key)
+	require.NoError(t, err)
+
+	stale.Failure("timeout")
+	assert.Equal(t, StateHalfOpen, b.State(key), "a probe from before the circuit opened isn't the trial")
+	assert.Error(t, b.Allow(key), "the trial is still under way")
+
+	trial.Success()
+	assert.Equal(t, StateClosed, b.State(key))
+}
+
+func TestProbeCancel(t *testing.T) {
+	b, now := newTestBreaker(Config{FailureThreshold: 1, Cooldown: time.Minute})
+	key := "mx1.acme.com"
+
+	stale, err := b.Admit(key)
+	require.NoError(t, err)
+	b.RecordFailure(key, "timeout")
+	*now = now.Add(2 * time.Minute)
+	trial, err := b.Admit(key)
+	require.NoError(t, err)
+
+	stale.Cancel()
+	assert.Error(t, b.Allow(key), "a stale probe doesn't free the trial slot")
+
+	trial.Cancel()
+	assert.NoError(t, b.Allow(key))
+}
+
+func TestBreakerKey(t *testing.T) {
+	byHost := New(Config{})
+	assert.Equal(t, "aspmx.l.google.com", byHost.Key("aspmx.l.google.com", "google workspace"))
+
+	byProvider := New(Config{ByProvider: true})
+	assert.Equal(t, "google workspace", byProvider.Key("aspmx.l.google.com", "google workspace"))
+	assert.Equal(t, "mx.acme.com", byProvider.Key("mx.acme.com", ""))
+}
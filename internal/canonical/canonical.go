@@ -0,0 +1,144 @@
+package canonical
+
+import (
+	"embed"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/customeros/mailsherpa/internal/syntax"
+)
+
+//go:embed canonical_rules.toml
+var rulesFile embed.FS
+
+// Address is an email address in the two forms its provider's rules give
+type Address struct {
+	// For deduplication: case folded, with the subaddress tag and, where
+	// the provider ignores them, dots removed
+	Canonical string
+	// For sending: the address as given, with the tag kept and the case
+	// only folded where the provider ignores it
+	Deliverable string
+	// Provider whose rules applied, empty for the default rules
+	Provider string
+	// Subaddress tag dropped from the canonical form, without separator
+	Tag string `json:",omitempty"`
+}
+
+type rule struct {
+	Provider        string   `toml:"provider"`
+	Domains         []string `toml:"domains"`
+	TagSeparators   []string `toml:"tag_separators"`
+	IgnoreDots      bool     `toml:"ignore_dots"`
+	CaseInsensitive bool     `toml:"case_insensitive"`
+}
+
+type rules struct {
+	Providers     []rule            `toml:"providers"`
+	DomainAliases map[string]string `toml:"domain_aliases"`
+
+	byProvider map[string]rule
+	byDomain   map[string]rule
+}
+
+var (
+	rulesOnce   sync.Once
+	loadedRules *rules
+	rulesErr    error
+)
+
+// Canonicalize applies the rules of the mailbox provider, as detected from
+// MX, to email. When provider is empty or has no rules, the rules of the
+// address' own domain are used, and failing that only the case is folded.
+func Canonicalize(email, provider string) (Address, error) {
+	table, err := getRules()
+	if err != nil {
+		return Address{}, err
+	}
+
+	if _, _, _, err := syntax.CheckEmailAddress(email); err != nil {
+		return Address{}, err
+	}
+	// CheckEmailAddress folds the case, so parse again to keep it
+	addr, err := syntax.ParseAddress(norm.NFC.String(email))
+	if err != nil {
+		return Address{}, err
+	}
+	local, domain := addr.LocalPart, strings.ToLower(addr.Domain)
+
+	r, ok := table.byProvider[strings.ToLower(provider)]
+	if !ok {
+		r = table.byDomain[domain]
+	}
+
+	deliverable := local
+	if r.CaseInsensitive {
+		deliverable = strings.ToLower(deliverable)
+	}
+
+	canonical, tag := strings.ToLower(local), ""
+	// Quoted local parts are taken literally
+	if !addr.Quoted {
+		canonical, tag = splitTag(canonical, r.TagSeparators)
+		if r.IgnoreDots {
+			canonical = strings.ReplaceAll(canonical, ".", "")
+		}
+	}
+	canonicalDomain := domain
+	if alias, ok := table.DomainAliases[domain]; ok {
+		canonicalDomain = alias
+	}
+
+	return Address{
+		Canonical:   canonical + "@" + canonicalDomain,
+		Deliverable: deliverable + "@" + domain,
+		Provider:    r.Provider,
+		Tag:         tag,
+	}, nil
+}
+
+// splitTag cuts the local part at the first tag separator. A separator
+// at the start is part of the mailbox name.
+func splitTag(local string, separators []string) (string, string) {
+	cut, width := -1, 0
+	for _, separator := range separators {
+		if i := strings.Index(local, separator); i > 0 && (cut < 0 || i < cut) {
+			cut, width = i, len(separator)
+		}
+	}
+	if cut < 0 {
+		return local, ""
+	}
+	return local[:cut], local[cut+width:]
+}
+
+func getRules() (*rules, error) {
+	rulesOnce.Do(func() {
+		fileData, err := rulesFile.ReadFile("canonical_rules.toml")
+		if err != nil {
+			rulesErr = err
+			return
+		}
+
+		var table rules
+		if _, err := toml.Decode(string(fileData), &table); err != nil {
+			rulesErr = fmt.Errorf("failed to decode TOML: %w", err)
+			return
+		}
+
+		table.byProvider = make(map[string]rule)
+		table.byDomain = make(map[string]rule)
+		for _, r := range table.Providers {
+			table.byProvider[r.Provider] = r
+			for _, domain := range r.Domains {
+				table.byDomain[domain] = r
+			}
+		}
+		loadedRules = &table
+	})
+	return loadedRules, rulesErr
+}
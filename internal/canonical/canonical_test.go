@@ -0,0 +1,127 @@
+package canonical
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		provider string
+		expected Address
+	}{
+		{
+			name:  "Gmail dots and plus tag",
+			email: "John.Doe+News@Gmail.com",
+			expected: Address{
+				Canonical:   "johndoe@gmail.com",
+				Deliverable: "john.doe+news@gmail.com",
+				Provider:    "google workspace",
+				Tag:         "news",
+			},
+		},
+		{
+			name:  "Googlemail is gmail",
+			email: "j.doe@googlemail.com",
+			expected: Address{
+				Canonical:   "jdoe@gmail.com",
+				Deliverable: "j.doe@googlemail.com",
+				Provider:    "google workspace",
+			},
+		},
+		{
+			name:     "Google Workspace detected from MX",
+			email:    "Jane.Smith+crm@acme.com",
+			provider: "google workspace",
+			expected: Address{
+				Canonical:   "janesmith@acme.com",
+				Deliverable: "jane.smith+crm@acme.com",
+				Provider:    "google workspace",
+				Tag:         "crm",
+			},
+		},
+		{
+			name:  "Outlook keeps dots",
+			email: "jane.smith+promo@outlook.com",
+			expected: Address{
+				Canonical:   "jane.smith@outlook.com",
+				Deliverable: "jane.smith+promo@outlook.com",
+				Provider:    "outlook",
+				Tag:         "promo",
+			},
+		},
+		{
+			name:     "Fastmail detected from MX",
+			email:    "bob+lists@example.org",
+			provider: "fastmail",
+			expected: Address{
+				Canonical:   "bob@example.org",
+				Deliverable: "bob+lists@example.org",
+				Provider:    "fastmail",
+				Tag:         "lists",
+			},
+		},
+		{
+			name:  "Yahoo disposable address",
+			email: "shopper-amazon@yahoo.com",
+			expected: Address{
+				Canonical:   "shopper@yahoo.com",
+				Deliverable: "shopper-amazon@yahoo.com",
+				Provider:    "yahoo",
+				Tag:         "amazon",
+			},
+		},
+		{
+			name:  "Plus is part of the name on Yahoo",
+			email: "bob+tag@yahoo.com",
+			expected: Address{
+				Canonical:   "bob+tag@yahoo.com",
+				Deliverable: "bob+tag@yahoo.com",
+				Provider:    "yahoo",
+			},
+		},
+		{
+			name:  "Unknown provider only folds case for dedup",
+			email: "Jane.Smith+crm@acme.com",
+			expected: Address{
+				Canonical:   "jane.smith+crm@acme.com",
+				Deliverable: "Jane.Smith+crm@acme.com",
+			},
+		},
+		{
+			name:  "Leading separator is not a tag",
+			email: "+bob@gmail.com",
+			expected: Address{
+				Canonical:   "+bob@gmail.com",
+				Deliverable: "+bob@gmail.com",
+				Provider:    "google workspace",
+			},
+		},
+		{
+			name:  "Quoted local parts are taken literally",
+			email: `"john doe+x"@gmail.com`,
+			expected: Address{
+				Canonical:   `"john doe+x"@gmail.com`,
+				Deliverable: `"john doe+x"@gmail.com`,
+				Provider:    "google workspace",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, err := Canonicalize(tt.email, tt.provider)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, address)
+		})
+	}
+}
+
+func TestCanonicalizeInvalid(t *testing.T) {
+	_, err := Canonicalize("not an email", "")
+	assert.Error(t, err)
+}
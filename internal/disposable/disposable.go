@@ -0,0 +1,88 @@
+package disposable
+
+import (
+	"strings"
+
+	"github.com/customeros/mailsherpa/internal/registry"
+	"github.com/customeros/mailsherpa/internal/syntax"
+)
+
+// Reasons a domain is considered disposable
+const (
+	ReasonListed       = "listed"
+	ReasonDisposableMX = "disposable_mx"
+	ReasonNewDomain    = "new_domain"
+	ReasonWildcardMX   = "wildcard_mx"
+)
+
+// Signals are the evidence gathered about a domain
+type Signals struct {
+	// The domain, or a parent of it, is a known throwaway service
+	Listed bool
+	// Mail for the domain is handled by a throwaway service
+	DisposableMX bool
+	// The domain was registered very recently
+	NewDomain bool
+	// Every subdomain has MX records, so any made-up address has a host
+	WildcardMX bool
+}
+
+// IsDisposable weighs the signals. The list and the MX hosts are
+// conclusive on their own; new domains and wildcard MX are common enough
+// on legitimate domains that it takes both.
+func (s Signals) IsDisposable() bool {
+	return s.Listed || s.DisposableMX || (s.NewDomain && s.WildcardMX)
+}
+
+// Reasons returns the signals behind a disposable verdict. Signals that
+// didn't count towards it, such as wildcard MX on an old domain, are left
+// out, and a domain that isn't disposable has no reasons.
+func (s Signals) Reasons() []string {
+	if !s.IsDisposable() {
+		return nil
+	}
+
+	var reasons []string
+	if s.Listed {
+		reasons = append(reasons, ReasonListed)
+	}
+	if s.DisposableMX {
+		reasons = append(reasons, ReasonDisposableMX)
+	}
+	if s.NewDomain && s.WildcardMX {
+		reasons = append(reasons, ReasonNewDomain, ReasonWildcardMX)
+	}
+	return reasons
+}
+
+// IsDisposableDomain reports whether domain, or a domain it is a subdomain
+// of, is a known throwaway mailbox service
+func IsDisposableDomain(domain string) (bool, error) {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	for domain != "" {
+		if listed, err := registry.Default().IsDisposableDomain(domain); err != nil || listed {
+			return listed, err
+		}
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			break
+		}
+		domain = parent
+	}
+	return false, nil
+}
+
+// IsDisposableMX reports whether any of the MX hosts belongs to a
+// throwaway mailbox service
+func IsDisposableMX(mx []string) (bool, error) {
+	for _, host := range mx {
+		root, err := syntax.ExtractRootDomain(strings.TrimSuffix(strings.ToLower(host), "."))
+		if err != nil {
+			continue
+		}
+		if listed, err := registry.Default().IsDisposableMX(root); err != nil || listed {
+			return listed, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,55 @@
+package disposable
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsDisposableDomain(t *testing.T) {
+	tests := []struct {
+		domain   string
+		expected bool
+	}{
+		{"mailinator.com", true},
+		{"10minutemail.co.za", true},
+		{"YOPMAIL.com", true},
+		{"team.mailinator.com", true},
+		{"gmail.com", false},
+		{"acme.com", false},
+		{"notmailinator.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			disposable, err := IsDisposableDomain(tt.domain)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, disposable)
+		})
+	}
+}
+
+func TestIsDisposableMX(t *testing.T) {
+	disposable, err := IsDisposableMX([]string{"in.mail.tm."})
+	require.NoError(t, err)
+	assert.True(t, disposable)
+
+	disposable, err = IsDisposableMX([]string{"aspmx.l.google.com", "alt1.aspmx.l.google.com"})
+	require.NoError(t, err)
+	assert.False(t, disposable)
+}
+
+func TestSignals(t *testing.T) {
+	assert.False(t, Signals{}.IsDisposable())
+	assert.True(t, Signals{Listed: true}.IsDisposable())
+	assert.True(t, Signals{DisposableMX: true}.IsDisposable())
+	assert.False(t, Signals{NewDomain: true}.IsDisposable())
+	assert.False(t, Signals{WildcardMX: true}.IsDisposable())
+	assert.True(t, Signals{NewDomain: true, WildcardMX: true}.IsDisposable())
+
+	assert.Equal(t, []string{ReasonListed}, Signals{Listed: true, WildcardMX: true}.Reasons())
+	assert.Equal(t, []string{ReasonNewDomain, ReasonWildcardMX}, Signals{NewDomain: true, WildcardMX: true}.Reasons())
+	assert.Empty(t, Signals{WildcardMX: true}.Reasons())
+	assert.Empty(t, Signals{}.Reasons())
+}
@@ -1,44 +1,14 @@
 package freemail
 
 import (
-	"embed"
-	"fmt"
-
-	"github.com/BurntSushi/toml"
-	"golang.org/x/exp/slices"
+	"github.com/customeros/mailsherpa/internal/registry"
 )
 
-//go:embed free_emails.toml
-var freeEmailsFile embed.FS
-
-type FreeEmails struct {
-	FreeEmailList []string `toml:"free_emails"`
-}
-
 func IsFreeEmailCheck(domain string) (bool, error) {
-	freeEmails, err := getFreeEmailList()
-	if err != nil {
-		return false, err
-	}
-
-	if slices.Contains(freeEmails.FreeEmailList, domain) {
-		return true, nil
-	}
-	return false, nil
+	return registry.Default().IsFreeEmail(domain)
 }
 
-func getFreeEmailList() (FreeEmails, error) {
-	var freeEmails FreeEmails
-
-	// Read the file
-	fileData, err := freeEmailsFile.ReadFile("free_emails.toml")
-	if err != nil {
-		return FreeEmails{}, err
-	}
-
-	if _, err := toml.Decode(string(fileData), &freeEmails); err != nil {
-		return FreeEmails{}, fmt.Errorf("failed to decode TOML: %w", err)
-	}
-
-	return freeEmails, nil
+// FreeEmailDomains returns every known free email provider domain
+func FreeEmailDomains() ([]string, error) {
+	return registry.Default().FreeEmailDomains()
 }
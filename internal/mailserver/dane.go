@@ -0,0 +1,419 @@
+package mailserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DaneStatus is how the MX host's certificate fared against its TLSA records
+type DaneStatus string
+
+const (
+	// No usable, DNSSEC-signed TLSA records for the MX host
+	DaneNone DaneStatus = "none"
+	// The STARTTLS certificate matched a TLSA record
+	DaneValid DaneStatus = "valid"
+	// TLSA records exist but the server offered no STARTTLS, the handshake
+	// failed or no certificate matched. The probe stops there instead of
+	// falling back to plaintext
+	DaneMismatch DaneStatus = "mismatch"
+	// The TLSA lookup failed, e.g. SERVFAIL or a timeout, so whether the
+	// host publishes DANE is unknown. The host isn't probed, RFC 7672
+	// section 2.2. Also set when the connection drops or times out during
+	// STARTTLS, before the certificate could be checked
+	DaneIndeterminate DaneStatus = "indeterminate"
+)
+
+// DaneResult is the outcome of the DANE check for the MX host
+type DaneResult struct {
+	Status DaneStatus
+	// Usable TLSA records published for the MX host
+	Records     int
+	Description string
+}
+
+// TLSARecord is a TLSA resource record, RFC 6698
+type TLSARecord struct {
+	// 2 (DANE-TA) and 3 (DANE-EE) are the usages SMTP relies on, RFC 7672
+	Usage uint8
+	// 0 matches the full certificate, 1 its public key
+	Selector uint8
+	// 0 exact, 1 SHA-256, 2 SHA-512
+	MatchingType uint8
+	Data         []byte
+}
+
+// TLSAResolver looks up TLSA records. authenticated must only be true for
+// answers that passed DNSSEC validation, since unsigned records are
+// ignored. A resolver that reports it wrongly makes DANE meaningless.
+type TLSAResolver interface {
+	LookupTLSA(name string) (records []TLSARecord, authenticated bool, err error)
+}
+
+const typeTLSA = dnsmessage.Type(52)
+
+// DNSSECResolver queries a validating recursive resolver and trusts its AD
+// bit. The AD bit is only meaningful from a resolver that validates DNSSEC
+// itself, over a path nobody can tamper with, e.g. one on localhost. A
+// non-validating resolver or an on-path attacker can set it on anything.
+type DNSSECResolver struct {
+	// host:port of the resolver. Defaults to the first nameserver in
+	// /etc/resolv.conf
+	Server  string
+	Timeout time.Duration
+}
+
+func (r *DNSSECResolver) LookupTLSA(name string) ([]TLSARecord, bool, error) {
+	server := r.Server
+	if server == "" {
+		server = systemNameserver()
+	}
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	query, err := buildTLSAQuery(name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	response, err := exchangeDNS("udp", server, query.message, timeout)
+	if err != nil {
+		return nil, false, err
+	}
+	records, authenticated, truncated, err := parseTLSAResponse(response, query)
+	if err == nil && truncated {
+		response, err = exchangeDNS("tcp", server, query.message, timeout)
+		if err != nil {
+			return nil, false, err
+		}
+		records, authenticated, _, err = parseTLSAResponse(response, query)
+	}
+	return records, authenticated, err
+}
+
+// tlsaQuery is a TLSA query and what its response must echo
+type tlsaQuery struct {
+	message  []byte
+	id       uint16
+	question dnsmessage.Question
+}
+
+func buildTLSAQuery(name string) (tlsaQuery, error) {
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return tlsaQuery{}, fmt.Errorf("invalid TLSA name %q: %w", name, err)
+	}
+
+	// A random ID, so off-path responses are hard to forge
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return tlsaQuery{}, err
+	}
+	query := tlsaQuery{
+		id:       binary.BigEndian.Uint16(id[:]),
+		question: dnsmessage.Question{Name: qname, Type: typeTLSA, Class: dnsmessage.ClassINET},
+	}
+
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:               query.id,
+		RecursionDesired: true,
+		AuthenticData:    true,
+	})
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return tlsaQuery{}, err
+	}
+	if err := builder.Question(query.question); err != nil {
+		return tlsaQuery{}, err
+	}
+
+	// EDNS0 with the DO bit, so the resolver reports DNSSEC status
+	if err := builder.StartAdditionals(); err != nil {
+		return tlsaQuery{}, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(4096, dnsmessage.RCodeSuccess, true); err != nil {
+		return tlsaQuery{}, err
+	}
+	if err := builder.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return tlsaQuery{}, err
+	}
+	query.message, err = builder.Finish()
+	return query, err
+}
+
+func exchangeDNS(network, server string, query []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout(network, server, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach DNS resolver: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read DNS response: %w", err)
+		}
+		return buf[:n], nil
+	}
+
+	// DNS over TCP prefixes messages with their length
+	framed := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(framed, uint16(len(query)))
+	copy(framed[2:], query)
+	if _, err := conn.Write(framed); err != nil {
+		return nil, err
+	}
+	var length uint16
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return nil, fmt.Errorf("failed to read DNS response: %w", err)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, fmt.Errorf("failed to read DNS response: %w", err)
+	}
+	return buf, nil
+}
+
+func parseTLSAResponse(response []byte, query tlsaQuery) (records []TLSARecord, authenticated, truncated bool, err error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(response)
+	if err != nil {
+		return nil, false, false, fmt.Errorf("invalid DNS response: %w", err)
+	}
+	if !header.Response || header.ID != query.id {
+		return nil, false, false, errors.New("DNS response doesn't match the query ID")
+	}
+	questions, err := parser.AllQuestions()
+	if err != nil {
+		return nil, false, false, fmt.Errorf("invalid DNS response: %w", err)
+	}
+	if len(questions) != 1 || !sameQuestion(questions[0], query.question) {
+		return nil, false, false, errors.New("DNS response doesn't match the query question")
+	}
+	if header.Truncated {
+		return nil, false, true, nil
+	}
+
+	switch header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, header.AuthenticData, false, nil
+	default:
+		return nil, false, false, fmt.Errorf("TLSA lookup failed: %s", header.RCode)
+	}
+
+	for {
+		answer, err := parser.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+		if err != nil {
+			return nil, false, false, err
+		}
+		if answer.Type != typeTLSA {
+			if err := parser.SkipAnswer(); err != nil {
+				return nil, false, false, err
+			}
+			continue
+		}
+
+		resource, err := parser.UnknownResource()
+		if err != nil {
+			return nil, false, false, err
+		}
+		if len(resource.Data) < 4 {
+			continue
+		}
+		records = append(records, TLSARecord{
+			Usage:        resource.Data[0],
+			Selector:     resource.Data[1],
+			MatchingType: resource.Data[2],
+			Data:         resource.Data[3:],
+		})
+	}
+	return records, header.AuthenticData, false, nil
+}
+
+// sameQuestion compares names case-insensitively, as resolvers may echo
+// them with another case
+func sameQuestion(a, b dnsmessage.Question) bool {
+	return a.Type == b.Type && a.Class == b.Class && strings.EqualFold(a.Name.String(), b.Name.String())
+}
+
+func systemNameserver() string {
+	if data, err := os.ReadFile("/etc/resolv.conf"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				return net.JoinHostPort(fields[1], "53")
+			}
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+// lookupDane returns the usable TLSA records for the MX host. Only a
+// NXDOMAIN or NODATA answer, or records from an unsigned zone, leave DANE
+// off. A failed lookup could hide records an attacker wants us to miss,
+// so it is indeterminate and the host must be skipped.
+func lookupDane(resolver TLSAResolver, host string) ([]TLSARecord, *DaneResult) {
+	result := &DaneResult{Status: DaneNone}
+
+	records, authenticated, err := resolver.LookupTLSA("_25._tcp." + strings.TrimSuffix(host, "."))
+	if err != nil {
+		result.Status = DaneIndeterminate
+		result.Description = err.Error()
+		return nil, result
+	}
+	if len(records) == 0 {
+		return nil, result
+	}
+	if !authenticated {
+		result.Description = "TLSA records are not DNSSEC-validated"
+		return nil, result
+	}
+
+	var usable []TLSARecord
+	for _, record := range records {
+		if record.usable() {
+			usable = append(usable, record)
+		}
+	}
+	result.Records = len(usable)
+	if len(usable) == 0 {
+		result.Description = "No usable TLSA records"
+	}
+	return usable, result
+}
+
+// usable reports whether an SMTP client can use the record, RFC 7672
+// section 3.1. PKIX usages 0 and 1 are not.
+func (r TLSARecord) usable() bool {
+	return (r.Usage == 2 || r.Usage == 3) && r.Selector <= 1 && r.MatchingType <= 2
+}
+
+func (r TLSARecord) matches(cert *x509.Certificate) bool {
+	data := cert.Raw
+	if r.Selector == 1 {
+		data = cert.RawSubjectPublicKeyInfo
+	}
+
+	switch r.MatchingType {
+	case 1:
+		sum := sha256.Sum256(data)
+		data = sum[:]
+	case 2:
+		sum := sha512.Sum512(data)
+		data = sum[:]
+	}
+	return bytes.Equal(data, r.Data)
+}
+
+// verifyDane checks the server's chain against the TLSA records. DANE-EE
+// pins the leaf and skips name and expiry checks. DANE-TA pins an issuer
+// in the chain, which the leaf must chain to and be valid for host under.
+func verifyDane(state tls.ConnectionState, host string, records []TLSARecord) error {
+	chain := state.PeerCertificates
+	if len(chain) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	leaf := chain[0]
+
+	for _, record := range records {
+		switch record.Usage {
+		case 3:
+			if record.matches(leaf) {
+				return nil
+			}
+		case 2:
+			for _, cert := range chain {
+				if !record.matches(cert) {
+					continue
+				}
+				roots := x509.NewCertPool()
+				roots.AddCert(cert)
+				intermediates := x509.NewCertPool()
+				for _, intermediate := range chain[1:] {
+					intermediates.AddCert(intermediate)
+				}
+				_, err := leaf.Verify(x509.VerifyOptions{
+					DNSName:       strings.TrimSuffix(host, "."),
+					Roots:         roots,
+					Intermediates: intermediates,
+				})
+				if err == nil {
+					return nil
+				}
+			}
+		}
+	}
+	return errors.New("certificate matches no TLSA record")
+}
+
+// startDaneTLS upgrades the session with STARTTLS and verifies the server
+// against its TLSA records, then repeats EHLO as RFC 3207 requires
+func startDaneTLS(session *smtpSession, heloName string, capabilities smtpCapabilities, records []TLSARecord) (smtpCapabilities, error) {
+	if !capabilities.has("STARTTLS") {
+		return nil, errors.New("server doesn't offer STARTTLS")
+	}
+
+	if err := sendSTARTTLS(session); err != nil {
+		return nil, err
+	}
+
+	host := session.mxHost
+	_, err := session.upgradeTLS(&tls.Config{
+		ServerName: strings.TrimSuffix(host, "."),
+		MinVersion: tls.VersionTLS12,
+		// Trust comes from the TLSA records, not the WebPKI
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return verifyDane(state, host, records)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	code, desc, capabilities, err := sendEHLO(session, heloName)
+	if err != nil {
+		return nil, err
+	}
+	if code != "250" {
+		return nil, fmt.Errorf("EHLO after STARTTLS rejected: %s %s", code, desc)
+	}
+	return capabilities, nil
+}
+
+func sendSTARTTLS(session *smtpSession) error {
+	resp, err := session.sendSMTPcommand("STARTTLS")
+	if err != nil {
+		return fmt.Errorf("SMTP STARTTLS command failed: %w", err)
+	}
+	if code, desc := parseSmtpCommand(resp); code != "220" {
+		return fmt.Errorf("STARTTLS rejected: %s %s", code, desc)
+	}
+	return nil
+}
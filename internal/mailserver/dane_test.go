@@ -0,0 +1,321 @@
+package mailserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type fakeTLSAResolver struct {
+	records       []TLSARecord
+	authenticated bool
+	err           error
+	names         []string
+}
+
+func (r *fakeTLSAResolver) LookupTLSA(name string) ([]TLSARecord, bool, error) {
+	r.names = append(r.names, name)
+	return r.records, r.authenticated, r.err
+}
+
+// newCertificate issues a certificate for 127.0.0.1, signed by parent or
+// self-signed when parent is nil
+func newCertificate(t *testing.T, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "mx.acme.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert, key
+}
+
+func serverTLS(key *ecdsa.PrivateKey, chain ...*x509.Certificate) *tls.Config {
+	certificate := tls.Certificate{PrivateKey: key}
+	for _, cert := range chain {
+		certificate.Certificate = append(certificate.Certificate, cert.Raw)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{certificate}}
+}
+
+func spkiSHA256(cert *x509.Certificate) TLSARecord {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return TLSARecord{Usage: 3, Selector: 1, MatchingType: 1, Data: sum[:]}
+}
+
+func daneRequest(server *fakeServer, resolver TLSAResolver) VerifyRequest {
+	return VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		Dane:       resolver,
+		Dns:        server.DNS(),
+	}
+}
+
+func hasCommand(commands []string, prefix string) bool {
+	for _, cmd := range commands {
+		if strings.HasPrefix(cmd, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestVerifyDaneEndEntity(t *testing.T) {
+	cert, key := newCertificate(t, false, nil, nil)
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+		"EHLO": "250-mx.acme.com\n250 STARTTLS",
+	})
+	server.SetTLS(serverTLS(key, cert))
+	resolver := &fakeTLSAResolver{records: []TLSARecord{spkiSHA256(cert)}, authenticated: true}
+
+	results := Verify(daneRequest(server, resolver))
+
+	if results.Dane == nil || results.Dane.Status != DaneValid || results.Dane.Records != 1 {
+		t.Fatalf("expected a valid DANE result, got %+v", results.Dane)
+	}
+	if results.ResponseCode != "250" || !results.CanConnectSmtp {
+		t.Errorf("expected the probe to continue over TLS, got %+v", results)
+	}
+	if len(resolver.names) != 1 || resolver.names[0] != "_25._tcp.127.0.0.1" {
+		t.Errorf("unexpected TLSA lookups %q", resolver.names)
+	}
+
+	commands := server.Received()
+	if len(commands) < 3 || commands[1] != "STARTTLS" || !strings.HasPrefix(commands[2], "EHLO") {
+		t.Errorf("expected EHLO to be repeated after STARTTLS, got %q", commands)
+	}
+}
+
+func TestVerifyDaneTrustAnchor(t *testing.T) {
+	ca, caKey := newCertificate(t, true, nil, nil)
+	leaf, leafKey := newCertificate(t, false, ca, caKey)
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+		"EHLO": "250-mx.acme.com\n250 STARTTLS",
+	})
+	server.SetTLS(serverTLS(leafKey, leaf, ca))
+	resolver := &fakeTLSAResolver{
+		records:       []TLSARecord{{Usage: 2, Selector: 0, MatchingType: 0, Data: ca.Raw}},
+		authenticated: true,
+	}
+
+	results := Verify(daneRequest(server, resolver))
+
+	if results.Dane == nil || results.Dane.Status != DaneValid {
+		t.Fatalf("expected the leaf to chain to the pinned CA, got %+v", results.Dane)
+	}
+}
+
+func TestVerifyDaneRefusesDowngrade(t *testing.T) {
+	cert, key := newCertificate(t, false, nil, nil)
+	other, _ := newCertificate(t, false, nil, nil)
+
+	tests := []struct {
+		name    string
+		ehlo    string
+		tls     *tls.Config
+		records []TLSARecord
+	}{
+		{
+			name:    "certificate doesn't match",
+			ehlo:    "250-mx.acme.com\n250 STARTTLS",
+			tls:     serverTLS(key, cert),
+			records: []TLSARecord{spkiSHA256(other)},
+		},
+		{
+			name:    "STARTTLS not offered",
+			ehlo:    "250-mx.acme.com\n250 PIPELINING",
+			records: []TLSARecord{spkiSHA256(cert)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{"EHLO": tt.ehlo})
+			server.SetTLS(tt.tls)
+			resolver := &fakeTLSAResolver{records: tt.records, authenticated: true}
+
+			results := Verify(daneRequest(server, resolver))
+
+			if results.Dane == nil || results.Dane.Status != DaneMismatch {
+				t.Fatalf("expected a DANE mismatch, got %+v", results.Dane)
+			}
+			if results.CanConnectSmtp {
+				t.Errorf("expected the probe to stop, got %+v", results)
+			}
+			if hasCommand(server.Received(), "MAIL FROM") {
+				t.Errorf("expected no plaintext fallback, got %q", server.Received())
+			}
+		})
+	}
+}
+
+func TestVerifyDaneIgnoresUnusableRecords(t *testing.T) {
+	cert, _ := newCertificate(t, false, nil, nil)
+
+	tests := []struct {
+		name     string
+		resolver *fakeTLSAResolver
+	}{
+		{"not DNSSEC-validated", &fakeTLSAResolver{records: []TLSARecord{spkiSHA256(cert)}}},
+		{"PKIX usage", &fakeTLSAResolver{records: []TLSARecord{{Usage: 1, Selector: 1, MatchingType: 1, Data: []byte{1}}}, authenticated: true}},
+		{"no records", &fakeTLSAResolver{authenticated: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startFakeServer(t, "220 mx.acme.com ESMTP", nil)
+
+			results := Verify(daneRequest(server, tt.resolver))
+
+			if results.Dane == nil || results.Dane.Status != DaneNone {
+				t.Fatalf("expected no DANE, got %+v", results.Dane)
+			}
+			if results.ResponseCode != "250" {
+				t.Errorf("expected the probe to run in plaintext, got %+v", results)
+			}
+			if hasCommand(server.Received(), "STARTTLS") {
+				t.Errorf("expected no STARTTLS, got %q", server.Received())
+			}
+		})
+	}
+}
+
+func TestVerifyDaneSkipsHostOnFailedLookup(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", nil)
+	resolver := &fakeTLSAResolver{err: errors.New("TLSA lookup failed: ServerFailure")}
+
+	results := Verify(daneRequest(server, resolver))
+
+	if results.Dane == nil || results.Dane.Status != DaneIndeterminate {
+		t.Fatalf("expected an indeterminate DANE status, got %+v", results.Dane)
+	}
+	if results.CanConnectSmtp {
+		t.Errorf("expected the host to be skipped, got %+v", results)
+	}
+	if len(server.Received()) != 0 {
+		t.Errorf("expected no plaintext probe, got %q", server.Received())
+	}
+}
+
+func TestVerifyDaneLookupPrecedesAdmission(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", nil)
+	resolver := &fakeTLSAResolver{err: errors.New("TLSA lookup failed: ServerFailure")}
+
+	var admitted []string
+	req := daneRequest(server, resolver)
+	req.Admit = func(host string) (func(SMPTValidation), error) {
+		admitted = append(admitted, host)
+		return nil, nil
+	}
+	results := Verify(req)
+
+	if len(admitted) != 0 {
+		t.Errorf("a failed TLSA lookup must not be admitted as a probe of the host, admitted %q", admitted)
+	}
+	if len(results.MxAttempts) != 1 || !results.MxAttempts[0].Skipped {
+		t.Errorf("expected the host to be reported as skipped, got %+v", results.MxAttempts)
+	}
+}
+
+func TestVerifyDaneDroppedDuringStartTLS(t *testing.T) {
+	cert, _ := newCertificate(t, false, nil, nil)
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+		"EHLO":     "250-mx.acme.com\n250 STARTTLS",
+		"STARTTLS": dropConnection,
+	})
+	resolver := &fakeTLSAResolver{records: []TLSARecord{spkiSHA256(cert)}, authenticated: true}
+
+	results := Verify(daneRequest(server, resolver))
+
+	if results.Dane == nil || results.Dane.Status != DaneIndeterminate {
+		t.Fatalf("expected an indeterminate DANE status, got %+v", results.Dane)
+	}
+	if results.CanConnectSmtp || hasCommand(server.Received(), "MAIL FROM") {
+		t.Errorf("expected the probe to stop, got %+v", results)
+	}
+}
+
+func TestParseTLSAResponse(t *testing.T) {
+	query, err := buildTLSAQuery("_25._tcp.mx.acme.com")
+	if err != nil {
+		t.Fatalf("failed to build query: %v", err)
+	}
+	respond := func(id uint16, question dnsmessage.Question) []byte {
+		builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, Response: true, AuthenticData: true})
+		builder.StartQuestions()
+		builder.Question(question)
+		builder.StartAnswers()
+		builder.UnknownResource(
+			dnsmessage.ResourceHeader{Name: question.Name, Type: typeTLSA, Class: dnsmessage.ClassINET, TTL: 300},
+			dnsmessage.UnknownResource{Type: typeTLSA, Data: []byte{3, 1, 1, 0xab, 0xcd}},
+		)
+		response, err := builder.Finish()
+		if err != nil {
+			t.Fatalf("failed to build response: %v", err)
+		}
+		return response
+	}
+
+	t.Run("should read records and the AD bit", func(t *testing.T) {
+		records, authenticated, truncated, err := parseTLSAResponse(respond(query.id, query.question), query)
+		if err != nil || truncated {
+			t.Fatalf("unexpected error %v, truncated %v", err, truncated)
+		}
+		if !authenticated {
+			t.Errorf("expected the AD bit to be reported")
+		}
+		if len(records) != 1 || records[0].Usage != 3 || records[0].Selector != 1 ||
+			records[0].MatchingType != 1 || string(records[0].Data) != "\xab\xcd" {
+			t.Errorf("unexpected records %+v", records)
+		}
+	})
+
+	t.Run("should reject a response to another query", func(t *testing.T) {
+		other := query.question
+		other.Name = dnsmessage.MustNewName("_25._tcp.mx.evil.com.")
+
+		if _, _, _, err := parseTLSAResponse(respond(query.id+1, query.question), query); err == nil {
+			t.Errorf("expected a mismatched ID to be rejected")
+		}
+		if _, _, _, err := parseTLSAResponse(respond(query.id, other), query); err == nil {
+			t.Errorf("expected a mismatched question to be rejected")
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package mailserver
+
+import (
+	"strings"
+	"sync"
+)
+
+// FailoverOptions controls when lower-preference MX hosts are tried. By
+// default only hosts that can't be reached are passed over. With
+// FullFailover the next host is also tried on 4xx replies to HELO, MAIL
+// FROM and RCPT TO. Backups are trusted like the primary unless they are
+// listed in AcceptAllHosts or were found out by Learned.
+type FailoverOptions struct {
+	// Also move to the next host on temporary failures
+	FullFailover bool
+	// Maximum number of MX hosts to try. Zero tries them all
+	MaxHosts int
+	// Backup hosts known to accept all mail, such as relay-only secondaries.
+	// Probing them says nothing about the mailbox, so they are skipped
+	AcceptAllHosts []string
+	// Backup hosts found to accept all mail while probing: a backup that
+	// accepted the catch-all decoys or runs an MTA of low reliability.
+	// Optional, share it between validations so later ones skip them
+	Learned *AcceptAllBackups
+}
+
+// AcceptAllBackups collects backup MX hosts found to accept all mail. The
+// zero value is ready to use
+type AcceptAllBackups struct {
+	mu    sync.Mutex
+	hosts map[string]string
+}
+
+func NewAcceptAllBackups() *AcceptAllBackups {
+	return &AcceptAllBackups{}
+}
+
+// Add records host as accepting all mail, and why we think so
+func (a *AcceptAllBackups) Add(host, reason string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.hosts == nil {
+		a.hosts = make(map[string]string)
+	}
+	a.hosts[normalizeHost(host)] = reason
+}
+
+// Reason returns why host was found to accept all mail, or "" if it wasn't
+func (a *AcceptAllBackups) Reason(host string) string {
+	if a == nil {
+		return ""
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.hosts[normalizeHost(host)]
+}
+
+// MxAttempt is the outcome of probing one MX host
+type MxAttempt struct {
+	Host         string
+	IP           string
+	ResponseCode string
+	Description  string
+	Skipped      bool
+}
+
+// failoverHosts returns the MX hosts to probe in preference order, and
+// the backup hosts skipped because they accept everything
+func failoverHosts(mxHosts []string, options FailoverOptions) (hosts, skipped []string) {
+	for i, host := range mxHosts {
+		// The primary is always probed, it's the server that decides
+		if i > 0 && (isAcceptAllBackup(host, options.AcceptAllHosts) || options.Learned.Reason(host) != "") {
+			skipped = append(skipped, host)
+			continue
+		}
+		hosts = append(hosts, host)
+		if options.MaxHosts > 0 && len(hosts) == options.MaxHosts {
+			break
+		}
+	}
+	return hosts, skipped
+}
+
+func isAcceptAllBackup(host string, acceptAllHosts []string) bool {
+	host = normalizeHost(host)
+	for _, known := range acceptAllHosts {
+		known = normalizeHost(known)
+		if host == known || strings.HasSuffix(host, "."+known) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func isTemporaryCode(code string) bool {
+	return strings.HasPrefix(code, "4")
+}
@@ -0,0 +1,38 @@
+package mailserver
+
+import (
+	"testing"
+
+	"github.com/customeros/mailsherpa/internal/smtptest"
+)
+
+const (
+	dropConnection = smtptest.DropConnection
+	ignoreCommand  = smtptest.IgnoreCommand
+)
+
+type fakeServer = smtptest.Server
+
+func startFakeServer(t *testing.T, greeting string, replies map[string]string) *fakeServer {
+	return startFakeServerAt(t, "127.0.0.1", greeting, replies)
+}
+
+// startFakeServerAt listens on ip and points smtpPort at it. Servers
+// started in the same test share a port, so several loopback addresses can
+// stand in for several MX hosts.
+func startFakeServerAt(t *testing.T, ip, greeting string, replies map[string]string) *fakeServer {
+	t.Helper()
+
+	port := "0"
+	if smtpPort != "25" {
+		port = smtpPort
+	}
+	server := smtptest.Start(t, ip, port, greeting, replies)
+
+	if port == "0" {
+		previousPort := smtpPort
+		smtpPort = server.Port
+		t.Cleanup(func() { smtpPort = previousPort })
+	}
+	return server
+}
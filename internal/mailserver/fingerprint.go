@@ -0,0 +1,157 @@
+package mailserver
+
+import (
+	"embed"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+//go:embed mta_signatures.toml
+var mtaSignaturesFile embed.FS
+
+// MtaFingerprint identifies the MTA software behind an MX host
+type MtaFingerprint struct {
+	Software string
+	Version  string
+	// How far RCPT TO verdicts from this MTA can be trusted: "high" or "low"
+	Reliability string
+	// Which parts of the conversation matched: greeting, ehlo, error
+	MatchedOn []string
+}
+
+type mtaSignature struct {
+	Name        string   `toml:"name"`
+	Reliability string   `toml:"reliability"`
+	Greeting    []string `toml:"greeting"`
+	Ehlo        []string `toml:"ehlo"`
+	Errors      []string `toml:"errors"`
+
+	greeting, ehlo, errors []*regexp.Regexp
+}
+
+// Weights of each source when several signatures match
+const (
+	greetingWeight = 4
+	ehloWeight     = 2
+	errorWeight    = 1
+)
+
+var (
+	mtaSignatures     []mtaSignature
+	mtaSignaturesOnce sync.Once
+)
+
+func loadMtaSignatures() []mtaSignature {
+	mtaSignaturesOnce.Do(func() {
+		signatures, err := parseMtaSignatures()
+		if err != nil {
+			log.Printf("Error loading MTA signatures: %v", err)
+			return
+		}
+		mtaSignatures = signatures
+	})
+	return mtaSignatures
+}
+
+func parseMtaSignatures() ([]mtaSignature, error) {
+	fileData, err := mtaSignaturesFile.ReadFile("mta_signatures.toml")
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Mta []mtaSignature `toml:"mta"`
+	}
+	if err := toml.Unmarshal(fileData, &file); err != nil {
+		return nil, fmt.Errorf("error decoding TOML: %w", err)
+	}
+
+	for i := range file.Mta {
+		signature := &file.Mta[i]
+		if signature.greeting, err = compilePatterns(signature.Greeting); err != nil {
+			return nil, fmt.Errorf("signature %s: %w", signature.Name, err)
+		}
+		if signature.ehlo, err = compilePatterns(signature.Ehlo); err != nil {
+			return nil, fmt.Errorf("signature %s: %w", signature.Name, err)
+		}
+		if signature.errors, err = compilePatterns(signature.Errors); err != nil {
+			return nil, fmt.Errorf("signature %s: %w", signature.Name, err)
+		}
+	}
+	return file.Mta, nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("(?im)" + pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// fingerprintMTA matches the greeting, EHLO reply and error replies of a
+// session against the signature file. It returns nil when nothing matches.
+func fingerprintMTA(greeting, ehlo string, errorReplies []string) *MtaFingerprint {
+	var best *MtaFingerprint
+	bestScore := 0
+
+	for _, signature := range loadMtaSignatures() {
+		fingerprint := &MtaFingerprint{Software: signature.Name, Reliability: signature.Reliability}
+		score := 0
+
+		if version, ok := matchAny(signature.greeting, greeting); ok {
+			score += greetingWeight
+			fingerprint.Version = version
+			fingerprint.MatchedOn = append(fingerprint.MatchedOn, "greeting")
+		}
+		if version, ok := matchAny(signature.ehlo, ehlo); ok {
+			score += ehloWeight
+			if fingerprint.Version == "" {
+				fingerprint.Version = version
+			}
+			fingerprint.MatchedOn = append(fingerprint.MatchedOn, "ehlo")
+		}
+		for _, reply := range errorReplies {
+			if version, ok := matchAny(signature.errors, reply); ok {
+				score += errorWeight
+				if fingerprint.Version == "" {
+					fingerprint.Version = version
+				}
+				fingerprint.MatchedOn = append(fingerprint.MatchedOn, "error")
+				break
+			}
+		}
+
+		if score > bestScore {
+			best, bestScore = fingerprint, score
+		}
+	}
+	return best
+}
+
+// matchAny reports whether any pattern matches text, along with the
+// "version" capture of the first match
+func matchAny(patterns []*regexp.Regexp, text string) (string, bool) {
+	if text == "" {
+		return "", false
+	}
+	for _, re := range patterns {
+		match := re.FindStringSubmatch(text)
+		if match == nil {
+			continue
+		}
+		if i := re.SubexpIndex("version"); i > 0 {
+			return match[i], true
+		}
+		return "", true
+	}
+	return "", false
+}
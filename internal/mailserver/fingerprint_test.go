@@ -0,0 +1,129 @@
+package mailserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMtaSignaturesParse(t *testing.T) {
+	signatures, err := parseMtaSignatures()
+	if err != nil {
+		t.Fatalf("failed to parse embedded signatures: %v", err)
+	}
+	for _, signature := range signatures {
+		if signature.Name == "" || (signature.Reliability != "high" && signature.Reliability != "low") {
+			t.Errorf("signature %q needs a name and a high or low reliability", signature.Name)
+		}
+	}
+}
+
+func TestFingerprintMTA(t *testing.T) {
+	testCases := []struct {
+		name     string
+		greeting string
+		ehlo     string
+		errors   []string
+		software string
+		version  string
+		matched  string
+	}{
+		{
+			name:     "postfix",
+			greeting: "220 mail.acme.com ESMTP Postfix (Debian/GNU)",
+			software: "Postfix",
+			matched:  "greeting",
+		},
+		{
+			name:     "exim with version",
+			greeting: "220 mx.acme.com ESMTP Exim 4.96 Mon, 02 Oct 2023 10:00:00 +0000",
+			software: "Exim",
+			version:  "4.96",
+			matched:  "greeting",
+		},
+		{
+			name:     "exchange on premise",
+			greeting: "220 mail.acme.com Microsoft ESMTP MAIL Service, Version: 10.0.17763.1 ready",
+			ehlo:     "250-mail.acme.com Hello [203.0.113.10]\n250-SIZE 37748736\n250-X-ANONYMOUSTLS\n250 XRDST",
+			software: "Microsoft Exchange",
+			version:  "10.0.17763.1",
+			matched:  "greeting,ehlo",
+		},
+		{
+			name:     "exchange keyword on the last ehlo line",
+			greeting: "220 mail.acme.com ESMTP",
+			ehlo:     "250-mail.acme.com Hello [203.0.113.10]\n250-SIZE 37748736\n250 XEXCH50",
+			software: "Microsoft Exchange",
+			matched:  "ehlo",
+		},
+		{
+			name:     "exchange online",
+			greeting: "220 BN8NAM12FT045.mail.protection.outlook.com Microsoft ESMTP MAIL Service ready at Mon, 2 Oct 2023",
+			errors:   []string{"550 5.4.1 Recipient address rejected: Access denied. AS(201806281) [BN8NAM12FT045.eop-nam12.prod.protection.outlook.com]"},
+			software: "Exchange Online Protection",
+			matched:  "greeting,error",
+		},
+		{
+			name:     "google from error only",
+			greeting: "220 mx.example ESMTP",
+			errors:   []string{"550-5.1.1 The email account that you tried to reach does not exist.\n550 5.1.1 https://support.google.com/mail/?p=NoSuchUser a1si123 - gsmtp"},
+			software: "Google",
+			matched:  "error",
+		},
+		{
+			name:     "proofpoint",
+			greeting: "220 mx0a-00123456.pphosted.com ESMTP mfa-m0123456",
+			software: "Proofpoint",
+			matched:  "greeting",
+		},
+		{
+			name:     "qmail",
+			greeting: "220 mail.acme.com ESMTP",
+			errors:   []string{"550 sorry, no mailbox here by that name. (#5.1.1)"},
+			software: "qmail",
+			matched:  "error",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fingerprint := fingerprintMTA(tc.greeting, tc.ehlo, tc.errors)
+			if fingerprint == nil {
+				t.Fatalf("expected %s, got no fingerprint", tc.software)
+			}
+			if fingerprint.Software != tc.software || fingerprint.Version != tc.version {
+				t.Errorf("expected %s %q, got %s %q", tc.software, tc.version, fingerprint.Software, fingerprint.Version)
+			}
+			if matched := strings.Join(fingerprint.MatchedOn, ","); matched != tc.matched {
+				t.Errorf("expected match on %q, got %q", tc.matched, matched)
+			}
+		})
+	}
+
+	if fingerprint := fingerprintMTA("220 mx.acme.com ESMTP ready", "", nil); fingerprint != nil {
+		t.Errorf("expected no fingerprint for a generic banner, got %+v", fingerprint)
+	}
+}
+
+func TestVerifyFingerprint(t *testing.T) {
+	server := startFakeServer(t, "220 mail.acme.com Microsoft ESMTP MAIL Service ready", map[string]string{
+		"EHLO": "250-mail.acme.com Hello [127.0.0.1]\n250-XEXCH50\n250 8BITMIME",
+	})
+
+	results := Verify(VerifyRequest{
+		Email:       "john@acme.com",
+		FromDomain:  "probe.example",
+		FromEmail:   "emma.smith@probe.example",
+		Fingerprint: true,
+		Dns:         server.DNS(),
+	})
+
+	if results.MtaFingerprint == nil || results.MtaFingerprint.Software != "Microsoft Exchange" {
+		t.Fatalf("expected Microsoft Exchange, got %+v", results.MtaFingerprint)
+	}
+	if results.MtaFingerprint.Reliability != "low" {
+		t.Errorf("expected low reliability, got %q", results.MtaFingerprint.Reliability)
+	}
+	if commands := server.Received(); commands[0] != "EHLO probe.example" {
+		t.Errorf("expected EHLO, got %q", commands[0])
+	}
+}
@@ -0,0 +1,191 @@
+package mailserver
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Replies slower than this are treated as deliberate delays
+const defaultTarpitThreshold = 15 * time.Second
+
+// PhaseLatency is how long each step of the SMTP conversation took. When a
+// command was sent more than once, the last attempt counts.
+type PhaseLatency struct {
+	ConnectMs  int64
+	GreetingMs int64
+	HeloMs     int64
+	MailFromMs int64
+	RcptMs     int64
+}
+
+// phaseTiming is one reply the server made us wait for
+type phaseTiming struct {
+	phase    string
+	elapsed  time.Duration
+	timedOut bool
+}
+
+// phaseOf names the conversation phase a command belongs to. The greeting
+// has no command. RSET and QUIT aren't phases.
+func phaseOf(cmd string) string {
+	verb := strings.ToUpper(strings.SplitN(cmd, " ", 2)[0])
+	switch {
+	case cmd == "":
+		return "greeting"
+	case verb == "EHLO" || verb == "HELO":
+		return "HELO"
+	case strings.HasPrefix(verb, "MAIL"):
+		return "MAIL FROM"
+	case strings.HasPrefix(verb, "RCPT"):
+		return "RCPT TO"
+	case verb == "VRFY" || verb == "EXPN":
+		return verb
+	}
+	return ""
+}
+
+func (l *PhaseLatency) set(phase string, elapsed time.Duration) {
+	ms := elapsed.Milliseconds()
+	switch phase {
+	case "greeting":
+		l.GreetingMs = ms
+	case "HELO":
+		l.HeloMs = ms
+	case "MAIL FROM":
+		l.MailFromMs = ms
+	case "RCPT TO":
+		l.RcptMs = ms
+	}
+}
+
+// detectTarpit looks for the patterns servers use to slow harvesters down:
+// a reply that never comes, a delayed greeting or reply, or replies that
+// get steadily slower. It returns why the server looks like a tarpit, or
+// an empty string.
+func detectTarpit(timings []phaseTiming, threshold time.Duration) string {
+	if threshold <= 0 {
+		threshold = defaultTarpitThreshold
+	}
+
+	for _, timing := range timings {
+		if timing.timedOut {
+			return fmt.Sprintf("No reply to %s within %s", timing.phase, roundLatency(timing.elapsed))
+		}
+	}
+	for _, timing := range timings {
+		if timing.elapsed >= threshold {
+			return fmt.Sprintf("%s delayed by %s", strings.ToUpper(timing.phase[:1])+timing.phase[1:], roundLatency(timing.elapsed))
+		}
+	}
+
+	// Each reply noticeably slower than the last, ending well above normal
+	if len(timings) >= 3 {
+		last := timings[len(timings)-1].elapsed
+		slowingDown := last >= threshold/2
+		for i := 1; i < len(timings) && slowingDown; i++ {
+			slowingDown = timings[i].elapsed >= timings[i-1].elapsed+time.Second
+		}
+		if slowingDown {
+			return fmt.Sprintf("Replies slowing down progressively, up to %s", roundLatency(last))
+		}
+	}
+	return ""
+}
+
+func roundLatency(d time.Duration) time.Duration {
+	if d >= time.Second {
+		return d.Round(time.Second)
+	}
+	return d.Round(time.Millisecond)
+}
+
+// LatencyTracker learns how slowly each MX host replies and sizes command
+// timeouts to match, so slow but honest hosts get an answer and fast hosts
+// don't hold a worker for the full default timeout.
+type LatencyTracker struct {
+	// Timeout is Multiplier times the host's typical slowest reply,
+	// kept between MinTimeout and MaxTimeout
+	Multiplier float64
+	MinTimeout time.Duration
+	MaxTimeout time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]time.Duration
+}
+
+// Weight of the newest observation in the moving average
+const latencySmoothing = 0.3
+
+func NewLatencyTracker() *LatencyTracker {
+	return &LatencyTracker{
+		Multiplier: 3,
+		MinTimeout: 5 * time.Second,
+		MaxTimeout: 2 * time.Minute,
+		hosts:      make(map[string]time.Duration),
+	}
+}
+
+// Observe records the slowest reply of a probe against host
+func (t *LatencyTracker) Observe(host string, slowest time.Duration) {
+	if t == nil || slowest <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.hosts == nil {
+		t.hosts = make(map[string]time.Duration)
+	}
+	previous, ok := t.hosts[host]
+	if !ok {
+		t.hosts[host] = slowest
+		return
+	}
+	t.hosts[host] = time.Duration(latencySmoothing*float64(slowest) + (1-latencySmoothing)*float64(previous))
+}
+
+// ObserveTimeout records a probe against host whose reply didn't arrive
+// within waited. The host is at least that slow, so the estimate jumps
+// there instead of drifting up through the moving average.
+func (t *LatencyTracker) ObserveTimeout(host string, waited time.Duration) {
+	if t == nil || waited <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.hosts == nil {
+		t.hosts = make(map[string]time.Duration)
+	}
+	if waited > t.hosts[host] {
+		t.hosts[host] = waited
+	}
+}
+
+// Timeout returns the command timeout to use for host, or fallback when
+// the host hasn't been seen yet
+func (t *LatencyTracker) Timeout(host string, fallback time.Duration) time.Duration {
+	if t == nil {
+		return fallback
+	}
+
+	t.mu.Lock()
+	typical, ok := t.hosts[host]
+	t.mu.Unlock()
+	if !ok {
+		return fallback
+	}
+
+	timeout := time.Duration(t.Multiplier * float64(typical))
+	if timeout < t.MinTimeout {
+		timeout = t.MinTimeout
+	}
+	if t.MaxTimeout > 0 && timeout > t.MaxTimeout {
+		timeout = t.MaxTimeout
+	}
+	return timeout
+}
@@ -0,0 +1,148 @@
+package mailserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDetectTarpit(t *testing.T) {
+	testCases := []struct {
+		name     string
+		timings  []phaseTiming
+		expected string
+	}{
+		{
+			name: "fast server",
+			timings: []phaseTiming{
+				{phase: "greeting", elapsed: 50 * time.Millisecond},
+				{phase: "HELO", elapsed: 20 * time.Millisecond},
+				{phase: "RCPT TO", elapsed: 300 * time.Millisecond},
+			},
+		},
+		{
+			name: "delayed greeting",
+			timings: []phaseTiming{
+				{phase: "greeting", elapsed: 20 * time.Second},
+				{phase: "HELO", elapsed: 20 * time.Millisecond},
+			},
+			expected: "Greeting delayed by 20s",
+		},
+		{
+			name: "timed out reply",
+			timings: []phaseTiming{
+				{phase: "greeting", elapsed: 20 * time.Second},
+				{phase: "RCPT TO", elapsed: 30 * time.Second, timedOut: true},
+			},
+			expected: "No reply to RCPT TO within 30s",
+		},
+		{
+			name: "progressive delays",
+			timings: []phaseTiming{
+				{phase: "greeting", elapsed: time.Second},
+				{phase: "HELO", elapsed: 4 * time.Second},
+				{phase: "MAIL FROM", elapsed: 8 * time.Second},
+			},
+			expected: "Replies slowing down progressively, up to 8s",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := detectTarpit(tc.timings, 0); got != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestLatencyTracker(t *testing.T) {
+	tracker := NewLatencyTracker()
+
+	if got := tracker.Timeout("mx1.acme.com", 30*time.Second); got != 30*time.Second {
+		t.Errorf("expected the fallback for an unseen host, got %s", got)
+	}
+
+	tracker.Observe("mx1.acme.com", 100*time.Millisecond)
+	if got := tracker.Timeout("mx1.acme.com", 30*time.Second); got != 5*time.Second {
+		t.Errorf("expected the minimum timeout for a fast host, got %s", got)
+	}
+
+	tracker.Observe("mx2.acme.com", 20*time.Second)
+	tracker.Observe("mx2.acme.com", 40*time.Second)
+	if got := tracker.Timeout("mx2.acme.com", 30*time.Second); got != 78*time.Second {
+		t.Errorf("expected 3x the moving average for a slow host, got %s", got)
+	}
+
+	tracker.Observe("mx3.acme.com", 5*time.Minute)
+	if got := tracker.Timeout("mx3.acme.com", 30*time.Second); got != 2*time.Minute {
+		t.Errorf("expected the maximum timeout, got %s", got)
+	}
+
+	var disabled *LatencyTracker
+	disabled.Observe("mx1.acme.com", time.Second)
+	if got := disabled.Timeout("mx1.acme.com", 30*time.Second); got != 30*time.Second {
+		t.Errorf("expected a nil tracker to use the fallback, got %s", got)
+	}
+}
+
+func TestVerifyDetectsBannerDelay(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", nil)
+	server.SetDelays(200*time.Millisecond, 0)
+
+	results := Verify(VerifyRequest{
+		Email:           "john@acme.com",
+		FromDomain:      "probe.example",
+		FromEmail:       "emma.smith@probe.example",
+		TarpitThreshold: 150 * time.Millisecond,
+		Dns:             server.DNS(),
+	})
+
+	if !results.Tarpitted || !strings.HasPrefix(results.TarpitReason, "Greeting delayed") {
+		t.Errorf("expected a delayed greeting to be flagged, got %q", results.TarpitReason)
+	}
+	if results.Latency.GreetingMs < 200 {
+		t.Errorf("expected greeting latency of at least 200ms, got %d", results.Latency.GreetingMs)
+	}
+	if results.ResponseCode != "250" {
+		t.Errorf("expected the probe to finish, got %q", results.ResponseCode)
+	}
+}
+
+func TestVerifyAdaptsTimeoutToHost(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", nil)
+	server.SetDelays(0, 150*time.Millisecond)
+
+	tracker := NewLatencyTracker()
+	tracker.MinTimeout = 10 * time.Millisecond
+	// A history of fast replies shrinks the timeout below the server's delay
+	tracker.Observe(server.IP, 20*time.Millisecond)
+
+	results := Verify(VerifyRequest{
+		Email:       "john@acme.com",
+		FromDomain:  "probe.example",
+		FromEmail:   "emma.smith@probe.example",
+		QuitTimeout: 50 * time.Millisecond,
+		Latency:     tracker,
+		Dns:         server.DNS(),
+	})
+	if results.CanConnectSmtp || !results.Tarpitted {
+		t.Fatalf("expected the short learned timeout to expire, got %+v", results)
+	}
+
+	// The timeout is remembered, so the next probe waits long enough
+	if got := tracker.Timeout(server.IP, 0); got <= 150*time.Millisecond {
+		t.Fatalf("expected the timeout to grow past the server's delay, got %s", got)
+	}
+	results = Verify(VerifyRequest{
+		Email:       "john@acme.com",
+		FromDomain:  "probe.example",
+		FromEmail:   "emma.smith@probe.example",
+		QuitTimeout: 500 * time.Millisecond,
+		Latency:     tracker,
+		Dns:         server.DNS(),
+	})
+	if results.ResponseCode != "250" {
+		t.Errorf("expected the probe to succeed with the adapted timeout, got %+v", results)
+	}
+}
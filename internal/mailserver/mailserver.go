@@ -12,8 +12,12 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/customeros/mailsherpa/domaincheck"
+	"github.com/customeros/mailsherpa/internal/syntax"
 )
 
+// Port MX hosts are probed on. Overridden in tests
+var smtpPort = "25"
+
 type SMPTValidation struct {
 	CanConnectSmtp bool
 	InboxFull      bool
@@ -21,10 +25,123 @@ type SMPTValidation struct {
 	ErrorCode      string
 	Description    string
 	SmtpResponse   string
+	LocalIP        string
+	MxHost         string
+	MxIP           string
+	// The server closed the connection before the probe finished
+	ConnectionDropped bool
+	// Every MX host tried, in order
+	MxAttempts []MxAttempt
+	// The MAIL FROM mode that produced the verdict
+	SenderMode SenderMode
+	// Outcome of the VRFY/EXPN phase, when it ran. A definitive answer
+	// skips the RCPT TO transaction
+	Vrfy *VrfyResult
+	// MTA software identified from the greeting, EHLO and error replies
+	MtaFingerprint *MtaFingerprint
+	Latency        PhaseLatency
+	// The server deliberately delayed its replies, and why we think so
+	Tarpitted    bool
+	TarpitReason string
+	// Replies to the decoy recipients, in the order they were sent
+	DecoyReplies []RcptReply
+	// RCPT TO latencies of the timing rounds, target and decoys interleaved
+	TimingSamples []TimingSample
+	// The address has a UTF-8 local part and the server doesn't advertise
+	// SMTPUTF8, so it wasn't probed
+	SmtpUtf8Unsupported bool
+	// DANE check of the MX host, when a TLSA resolver was given
+	Dane       *DaneResult
+	Transcript *Transcript
+}
+
+// RcptReply is the server's answer to one RCPT TO
+type RcptReply struct {
+	Email        string
+	ResponseCode string
+	ErrorCode    string
+	Description  string
+	LatencyMs    int64
+}
+
+// Sender is who an MX host is probed from
+type Sender struct {
+	BindIP     string
+	HeloName   string
+	FromDomain string
+	FromEmail  string
+}
+
+// VerifyRequest describes a single SMTP probe
+type VerifyRequest struct {
+	Email      string
+	FromDomain string
+	FromEmail  string
+	// Local IP to bind the connection to. The OS picks one when empty
+	BindIP string
+	// Port MX hosts are probed on. Defaults to 25
+	Port string
+	// Name announced in HELO. Defaults to FromDomain
+	HeloName string
+	// MAIL FROM mode, address by default. When the server refuses the
+	// reverse-path on policy grounds the other mode is tried, unless
+	// DisableSenderFallback is set
+	SenderMode            SenderMode
+	DisableSenderFallback bool
+	// Greet with EHLO and ask the server about the mailbox with VRFY (or
+	// EXPN) before falling back to a RCPT TO transaction. Only servers
+	// advertising the command are asked, unless TryUnadvertisedVrfy is set
+	TryVrfy             bool
+	TryUnadvertisedVrfy bool
+	// Greet with EHLO so its reply can be used to fingerprint the MTA
+	Fingerprint bool
+	// Extra recipients sent after Email in the same transaction, e.g. to
+	// tell whether the server accepts any address
+	Decoys []string
+	// Rounds of repeated RCPT TO for Email and each decoy, timed to compare
+	// how the server handles them. Zero disables timing
+	TimingRounds int
+	// Resolves DNSSEC-validated TLSA records for the MX hosts. Hosts with
+	// usable records are only probed over STARTTLS with a matching
+	// certificate. Nil disables DANE
+	Dane       TLSAResolver
+	Transcript TranscriptOptions
+	// How long to wait for each reply, and for the 221 after QUIT. When
+	// CommandTimeout is unset, Latency sizes it from the host's history
+	CommandTimeout time.Duration
+	QuitTimeout    time.Duration
+	Latency        *LatencyTracker
+	// Replies slower than this mark the server as a tarpit. Defaults to 15s
+	TarpitThreshold time.Duration
+	// When lower-preference MX hosts are tried. Nil, like the zero value,
+	// only moves on from hosts that can't be reached
+	Failover *FailoverOptions
+	// Picks the sender of each MX host before it is admitted, e.g. to keep
+	// every host on its own identity from a pool. An error skips the host.
+	// Nil probes all hosts from the request's sender
+	SenderFor func(host string) (Sender, error)
+	// Asked before each MX host is dialled, e.g. to rate limit or circuit
+	// break per host. An error skips the host. The returned func, if any,
+	// gets the host's result once it has been probed
+	Admit func(host string) (done func(SMPTValidation), err error)
+	Dns   domaincheck.DNS
 }
 
 func VerifyEmailAddress(email, fromDomain, fromEmail string, dnsRecords domaincheck.DNS) SMPTValidation {
-	results := SMPTValidation{}
+	return Verify(VerifyRequest{
+		Email:      email,
+		FromDomain: fromDomain,
+		FromEmail:  fromEmail,
+		Dns:        dnsRecords,
+	})
+}
+
+func Verify(req VerifyRequest) (results SMPTValidation) {
+	dnsRecords := req.Dns
+	transcript := newTranscriptRecorder(req.Transcript, req.FromEmail, req.Email)
+	defer func() {
+		results.Transcript = transcript.result()
+	}()
 
 	// Has MX Record Check
 	if len(dnsRecords.MX) == 0 {
@@ -33,128 +150,352 @@ func VerifyEmailAddress(email, fromDomain, fromEmail string, dnsRecords domainch
 		return results
 	}
 
-	var conn net.Conn
-	var client *bufio.Reader
-	var err error
-	var greetCode string
-	var greetDesc string
+	hosts, skipped := failoverHosts(dnsRecords.MX, req.failoverOptions())
+	var attempts []MxAttempt
+	for _, host := range skipped {
+		description := "Backup MX accepts all mail"
+		if reason := req.failoverOptions().Learned.Reason(host); reason != "" {
+			description += ": " + reason
+		}
+		attempts = append(attempts, MxAttempt{Host: host, Skipped: true, Description: description})
+	}
 
-	for i := 0; i < len(dnsRecords.MX); i++ {
-		conn, client, err = connectToSMTP(dnsRecords.MX[i])
-		if err != nil {
-			continue
+	var refused error
+	for _, host := range hosts {
+		// Looked up before admission, so a failing resolver of ours isn't
+		// held against the host
+		var daneRecords []TLSARecord
+		var dane *DaneResult
+		if req.Dane != nil {
+			daneRecords, dane = lookupDane(req.Dane, host)
+			if dane.Status == DaneIndeterminate {
+				hostResults := SMPTValidation{
+					MxHost:      host,
+					Dane:        dane,
+					Description: "DANE TLSA lookup failed: " + dane.Description,
+				}
+				attempts = append(attempts, MxAttempt{Host: host, Skipped: true, Description: hostResults.Description})
+				if informativeness(hostResults) >= informativeness(results) {
+					results = hostResults
+				}
+				continue
+			}
 		}
-		greetCode, greetDesc = readSMTPgreeting(client)
-		if greetCode == "220" {
+
+		hostReq := req
+		if req.SenderFor != nil {
+			sender, err := req.SenderFor(host)
+			if err != nil {
+				attempts = append(attempts, MxAttempt{Host: host, Skipped: true, Description: err.Error()})
+				refused = err
+				continue
+			}
+			hostReq = req.withSender(sender)
+			transcript.redactSender(sender.FromEmail)
+		}
+
+		var done func(SMPTValidation)
+		if req.Admit != nil {
+			var err error
+			if done, err = req.Admit(host); err != nil {
+				attempts = append(attempts, MxAttempt{Host: host, Skipped: true, Description: err.Error()})
+				refused = err
+				continue
+			}
+		}
+
+		hostResults, retryable := probeHost(host, hostReq, daneRecords, dane, transcript)
+		if done != nil {
+			done(hostResults)
+		}
+		// Verdicts of a backup that may bounce later are worth no more than
+		// a relay's, so later validations skip it
+		if fingerprint := hostResults.MtaFingerprint; host != dnsRecords.MX[0] && fingerprint != nil && fingerprint.Reliability == "low" {
+			req.failoverOptions().Learned.Add(host, fingerprint.Software+" MTA of low reliability")
+		}
+		attempts = append(attempts, MxAttempt{
+			Host:         host,
+			IP:           hostResults.MxIP,
+			ResponseCode: hostResults.ResponseCode,
+			Description:  hostResults.Description,
+		})
+
+		// Keep the most informative answer if every host fails
+		if informativeness(hostResults) >= informativeness(results) {
+			results = hostResults
+		}
+		if !retryable {
 			break
 		}
 	}
 
+	results.MxAttempts = attempts
+	if results.MxHost == "" && refused != nil {
+		// No host was probed, say why rather than blame the network
+		results.Description = refused.Error()
+	}
+	// Every failure after a greeting is described, so only hosts that
+	// couldn't be reached are left blank
+	if !results.CanConnectSmtp && results.ResponseCode == "" && results.Description == "" {
+		results.Description = "Cannot connect to any MX server"
+	}
+	return results
+}
+
+// informativeness ranks a host's result: a reply code beats a description
+// of what went wrong, which beats nothing at all
+func informativeness(results SMPTValidation) int {
+	switch {
+	case results.ResponseCode != "":
+		return 2
+	case results.Description != "":
+		return 1
+	}
+	return 0
+}
+
+// interruptedDescription describes a session that ended without a reply to
+// command, so it isn't mistaken for a host that couldn't be reached
+func interruptedDescription(command string, err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Sprintf("No reply to %s", command)
+	}
+	return fmt.Sprintf("Connection dropped during %s", command)
+}
+
+// withSender returns the request probing from sender
+func (req VerifyRequest) withSender(sender Sender) VerifyRequest {
+	req.BindIP = sender.BindIP
+	req.HeloName = sender.HeloName
+	req.FromDomain = sender.FromDomain
+	req.FromEmail = sender.FromEmail
+	return req
+}
+
+// port returns the port MX hosts are probed on
+func (req VerifyRequest) port() string {
+	if req.Port == "" {
+		return smtpPort
+	}
+	return req.Port
+}
+
+// failoverOptions returns the options in effect
+func (req VerifyRequest) failoverOptions() FailoverOptions {
+	if req.Failover == nil {
+		return FailoverOptions{}
+	}
+	return *req.Failover
+}
+
+// probeHost runs the SMTP conversation against a single MX host. It
+// reports whether the failure was temporary or connection-level, in which
+// case the next MX host is worth trying.
+func probeHost(host string, req VerifyRequest, daneRecords []TLSARecord, dane *DaneResult, transcript *transcriptRecorder) (results SMPTValidation, retryable bool) {
+	results.MxHost = host
+	results.Dane = dane
+	fullFailover := req.failoverOptions().FullFailover
+
+	session, err := connectToSMTP(host, req.port(), req.BindIP, transcript)
+	if err != nil {
+		return results, true
+	}
+	session.commandTimeout = req.CommandTimeout
+	if session.commandTimeout <= 0 {
+		session.commandTimeout = req.Latency.Timeout(host, defaultCommandTimeout)
+	}
+	session.quitTimeout = req.QuitTimeout
+	if session.quitTimeout <= 0 {
+		session.quitTimeout = defaultQuitTimeout
+	}
+	defer session.close()
+	defer func() {
+		results.MtaFingerprint = session.fingerprint()
+		results.Latency = session.latency
+		results.TarpitReason = detectTarpit(session.timings, req.TarpitThreshold)
+		results.Tarpitted = results.TarpitReason != ""
+		if slowest, timedOut := session.slowestReply(); timedOut {
+			req.Latency.ObserveTimeout(host, slowest)
+		} else {
+			req.Latency.Observe(host, slowest)
+		}
+	}()
+
+	results.LocalIP = localIP(session.conn)
+	results.MxIP = session.mxIP
+
+	greetCode, greetDesc := session.readSMTPgreeting()
 	if greetCode != "220" {
 		results.CanConnectSmtp = false
 		results.ResponseCode = greetCode
 		results.Description = greetDesc
-		if results.Description == "" {
-			results.Description = "Cannot connect to any MX server"
-		}
-		return results
+		return results, true
 	}
 
-	defer conn.Close()
+	heloName := req.HeloName
+	if heloName == "" {
+		heloName = req.FromDomain
+	}
 
-	heloCode, heloDesc, heloErr := sendHELO(conn, client, fromDomain)
+	var heloCode, heloDesc string
+	var heloErr error
+	var capabilities smtpCapabilities
+	heloCommand := "HELO"
+	needsUtf8 := syntax.RequiresSMTPUTF8(req.Email)
+	if req.TryVrfy || req.Fingerprint || len(daneRecords) > 0 || needsUtf8 {
+		heloCommand = "EHLO"
+		heloCode, heloDesc, capabilities, heloErr = sendEHLO(session, heloName)
+	} else {
+		heloCode, heloDesc, heloErr = sendHELO(session, heloName)
+	}
 	if heloErr != nil {
 		results.CanConnectSmtp = false
+		results.ConnectionDropped = session.dropped
+		results.Description = interruptedDescription(heloCommand, heloErr)
 		log.Printf(heloErr.Error())
-		return results
+		return results, true
 	}
 	if heloCode != "250" {
 		results.ResponseCode = heloCode
 		results.Description = heloDesc
 		results.CanConnectSmtp = false
-		return results
+		return results, isTemporaryCode(heloCode) && fullFailover
 	}
 
-	fromCode, fromDesc, fromErr := sendMAILFROM(conn, client, fromEmail)
+	// Never fall back to plaintext on a host that publishes DANE
+	if len(daneRecords) > 0 {
+		capabilities, err = startDaneTLS(session, heloName, capabilities, daneRecords)
+		if err != nil {
+			results.Dane.Status = DaneMismatch
+			results.Description = "DANE verification failed: " + err.Error()
+			// A dropped or timed out connection says nothing about the
+			// certificate
+			if isConnectionDropped(err) {
+				results.Dane.Status = DaneIndeterminate
+				results.Description = "DANE verification interrupted: " + err.Error()
+			}
+			results.Dane.Description = err.Error()
+			results.CanConnectSmtp = false
+			results.ConnectionDropped = session.dropped
+			return results, true
+		}
+		results.Dane.Status = DaneValid
+	}
+
+	// UTF-8 local parts can only be sent with the SMTPUTF8 extension
+	if needsUtf8 {
+		if !capabilities.has("SMTPUTF8") {
+			results.CanConnectSmtp = true
+			results.SmtpUtf8Unsupported = true
+			results.Description = "Server doesn't support SMTPUTF8"
+			// The backups are no more likely to support it
+			return results, false
+		}
+		session.smtpUtf8 = true
+	}
+
+	if req.TryVrfy {
+		vrfy, err := probeVrfy(session, req.Email, capabilities, req.TryUnadvertisedVrfy)
+		if err != nil {
+			results.CanConnectSmtp = false
+			results.ConnectionDropped = session.dropped
+			results.Description = interruptedDescription("VRFY", err)
+			log.Printf(err.Error())
+			return results, true
+		}
+		results.Vrfy = vrfy
+		if vrfy.Definitive() {
+			results.CanConnectSmtp = true
+			return results, false
+		}
+	}
+
+	fromCode, fromDesc, senderMode, fromErr := negotiateMailFrom(session, req)
+	results.SenderMode = senderMode
 	if fromErr != nil {
 		results.CanConnectSmtp = false
+		results.ConnectionDropped = session.dropped
+		results.Description = interruptedDescription("MAIL FROM", fromErr)
 		log.Printf(fromErr.Error())
-		return results
+		return results, true
 	}
 	if fromCode != "250" {
 		results.ResponseCode = fromCode
 		results.Description = fromDesc
 		results.CanConnectSmtp = false
-		return results
+		return results, isTemporaryCode(fromCode) && fullFailover
 	}
+	session.inTransaction = true
 
-	results, err = sendRCPTTO(conn, client, email)
+	err = sendRCPTTO(session, req.Email, &results)
 	if err != nil {
 		results.CanConnectSmtp = false
 		results.SmtpResponse = err.Error()
-		return results
+		results.ConnectionDropped = session.dropped
+		results.Description = interruptedDescription("RCPT TO", err)
+		return results, true
 	}
 
-	return results
-}
-
-func connectToSMTP(mxServer string) (net.Conn, *bufio.Reader, error) {
-	conn, err := net.DialTimeout("tcp", mxServer+":25", 10*time.Second)
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to connect to SMTP server")
+	// A 421 at RCPT means the server is going away, not a verdict on the mailbox
+	if results.ResponseCode == "421" {
+		return results, fullFailover
 	}
 
-	client := bufio.NewReader(conn)
-	return conn, client, nil
+	results.DecoyReplies = sendDecoyRCPTs(session, req.Decoys)
+	if req.TimingRounds > 0 && len(req.Decoys) > 0 {
+		results.TimingSamples = sampleRcptTiming(session, req.Email, req.Decoys, req.TimingRounds)
+	}
+	return results, false
 }
 
-func readSMTPgreeting(smtpClient *bufio.Reader) (string, string) {
-	var fullGreeting strings.Builder
-	var code string
-
-	for {
-		line, err := smtpClient.ReadString('\n')
-		if err != nil {
-			return "", ""
+func connectToSMTP(mxServer, port, bindIP string, transcript *transcriptRecorder) (*smtpSession, error) {
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	if bindIP != "" {
+		ip := net.ParseIP(bindIP)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid bind IP %q", bindIP)
 		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
 
-		// Trim the line to remove whitespace and newline characters
-		line = strings.TrimSpace(line)
-
-		// Append this line to the full greeting
-		fullGreeting.WriteString(line + "\n")
+	started := time.Now()
+	conn, err := dialer.Dial("tcp", net.JoinHostPort(mxServer, port))
+	if err != nil {
+		transcript.record(mxServer, "", "CONNECT", "", started, err)
+		return nil, errors.Wrap(err, "failed to connect to SMTP server")
+	}
 
-		// Check if it's a continuation line
-		if strings.HasPrefix(line, "220-") {
-			continue
-		} else if strings.HasPrefix(line, "220 ") {
-			// This is the last line of the greeting
-			code = "220"
-			break
-		} else {
-			// Unexpected response
-			parsedCode, _ := parseSmtpCommand(line)
-			return parsedCode, fullGreeting.String()
-		}
+	session := &smtpSession{
+		conn:       conn,
+		reader:     bufio.NewReader(conn),
+		mxHost:     mxServer,
+		mxIP:       remoteIP(conn),
+		transcript: transcript,
 	}
-	return code, fullGreeting.String()
+	session.latency.ConnectMs = time.Since(started).Milliseconds()
+	transcript.record(session.mxHost, session.mxIP, "CONNECT", "", started, nil)
+	return session, nil
 }
 
-func sendSMTPcommand(conn net.Conn, smtpClient *bufio.Reader, cmd string) (string, error) {
-	_, err := fmt.Fprintf(conn, cmd+"\r\n")
-	if err != nil {
-		return "", fmt.Errorf("failed to send SMTP command %s: %s", cmd, err.Error())
+// localIP returns the source address the OS picked for the connection
+func localIP(conn net.Conn) string {
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
 	}
-	resp, err := smtpClient.ReadString('\n')
-	if err != nil {
-		return "", fmt.Errorf("failed to read response for SMTP command %s: %s", cmd, err.Error())
+	return ""
+}
+
+func remoteIP(conn net.Conn) string {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
 	}
-	return resp, nil
+	return ""
 }
 
-func sendHELO(conn net.Conn, smtpClient *bufio.Reader, fromDomain string) (string, string, error) {
+func sendHELO(session *smtpSession, fromDomain string) (string, string, error) {
 	helo := fmt.Sprintf("HELO %s", fromDomain)
-	resp, err := sendSMTPcommand(conn, smtpClient, helo)
+	resp, err := session.sendSMTPcommand(helo)
 	if err != nil {
 		return "", "", fmt.Errorf("SMTP HELO command failed: %w", err)
 	}
@@ -162,9 +503,12 @@ func sendHELO(conn net.Conn, smtpClient *bufio.Reader, fromDomain string) (strin
 	return statusCode, desc, nil
 }
 
-func sendMAILFROM(conn net.Conn, smtpClient *bufio.Reader, fromEmail string) (string, string, error) {
+func sendMAILFROM(session *smtpSession, fromEmail string) (string, string, error) {
 	mailfrom := fmt.Sprintf("MAIL FROM:<%s>", fromEmail)
-	resp, err := sendSMTPcommand(conn, smtpClient, mailfrom)
+	if session.smtpUtf8 {
+		mailfrom += " SMTPUTF8"
+	}
+	resp, err := session.sendSMTPcommand(mailfrom)
 	if err != nil {
 		return "", "", fmt.Errorf("SMTP MAIL FROM command failed: %w", err)
 	}
@@ -172,11 +516,11 @@ func sendMAILFROM(conn net.Conn, smtpClient *bufio.Reader, fromEmail string) (st
 	return statusCode, desc, nil
 }
 
-func sendRCPTTO(conn net.Conn, smtpClient *bufio.Reader, emailToValidate string) (results SMPTValidation, err error) {
+func sendRCPTTO(session *smtpSession, emailToValidate string, results *SMPTValidation) error {
 	rcpt := fmt.Sprintf("RCPT TO:<%s>", emailToValidate)
-	resp, err := sendSMTPcommand(conn, smtpClient, rcpt)
+	resp, err := session.sendSMTPcommand(rcpt)
 	if err != nil {
-		return results, errors.Wrap(err, "RCPT TO command failed")
+		return errors.Wrap(err, "RCPT TO command failed")
 	}
 
 	results.SmtpResponse = resp
@@ -186,7 +530,34 @@ func sendRCPTTO(conn net.Conn, smtpClient *bufio.Reader, emailToValidate string)
 		results.CanConnectSmtp = true
 	}
 
-	return
+	return nil
+}
+
+// sendDecoyRCPTs sends RCPT TO for each decoy until the server hangs up
+func sendDecoyRCPTs(session *smtpSession, decoys []string) []RcptReply {
+	// Latency.RcptMs stays the target's
+	targetLatency := session.latency.RcptMs
+	defer func() {
+		session.latency.RcptMs = targetLatency
+	}()
+
+	var replies []RcptReply
+	for _, decoy := range decoys {
+		if session.dropped {
+			break
+		}
+
+		started := time.Now()
+		resp, err := session.sendSMTPcommand(fmt.Sprintf("RCPT TO:<%s>", decoy))
+		if err != nil {
+			break
+		}
+
+		reply := RcptReply{Email: decoy, LatencyMs: time.Since(started).Milliseconds()}
+		reply.ResponseCode, reply.ErrorCode, reply.Description = ParseSmtpResponse(resp)
+		replies = append(replies, reply)
+	}
+	return replies
 }
 
 func ParseSmtpResponse(response string) (statusCode, errorCode, description string) {
@@ -204,8 +575,9 @@ func ParseSmtpResponse(response string) (statusCode, errorCode, description stri
 		return
 	}
 
-	// Extract the error code
-	errorCodePattern := `\b(\d\.\d\.\d)\b`
+	// Extract the error code. RFC 3463 allows up to three digits for the
+	// subject and detail, e.g. 5.7.23
+	errorCodePattern := `\b(\d\.\d{1,3}\.\d{1,3})\b`
 	errorCodeRegex := regexp.MustCompile(errorCodePattern)
 	errorCodeMatch := errorCodeRegex.FindStringSubmatch(response)
 	if len(errorCodeMatch) > 0 {
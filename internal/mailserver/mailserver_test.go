@@ -1,6 +1,13 @@
 package mailserver
 
-import "testing"
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/customeros/mailsherpa/domaincheck"
+)
 
 func TestParseSmtpResponse(t *testing.T) {
 	testCases := []struct {
@@ -45,6 +52,12 @@ func TestParseSmtpResponse(t *testing.T) {
 			expectedError: "5.1.1",
 			expectedDesc:  "Invalid recipient <ebun.adebonojo@bbc.com> (#5.1.1)",
 		},
+		{
+			input:         "550 5.7.23 SPF validation failed",
+			expectedCode:  "550",
+			expectedError: "5.7.23",
+			expectedDesc:  "SPF validation failed",
+		},
 		{
 			input:         "550-5.2.1 The email account that you tried to reach is inactive. For more",
 			expectedCode:  "550",
@@ -86,3 +99,944 @@ func TestParseSmtpResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestVerifyTranscript(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+		"RCPT TO": "550 5.1.1 <John.Doe@acme.com>: Recipient address rejected",
+	})
+
+	results := Verify(VerifyRequest{
+		Email:      "John.Doe@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		Transcript: TranscriptOptions{Enabled: true, RedactRecipient: true},
+		Dns:        server.DNS(),
+	})
+
+	if results.ResponseCode != "550" {
+		t.Fatalf("expected 550, got %q", results.ResponseCode)
+	}
+	if results.MxHost != "127.0.0.1" || results.MxIP != "127.0.0.1" {
+		t.Errorf("expected MX host and IP to be recorded, got %q %q", results.MxHost, results.MxIP)
+	}
+	if results.Transcript == nil {
+		t.Fatal("expected a transcript")
+	}
+
+	var commands []string
+	for _, entry := range results.Transcript.Entries {
+		commands = append(commands, entry.Command)
+		if entry.MxHost != "127.0.0.1" || entry.Time.IsZero() {
+			t.Errorf("entry missing host or timestamp: %+v", entry)
+		}
+	}
+	expected := []string{"CONNECT", "", "HELO probe.example", "MAIL FROM:<emma.smith@probe.example>", "RCPT TO:<***@acme.com>"}
+	if len(commands) < len(expected) {
+		t.Fatalf("expected at least %d transcript entries, got %q", len(expected), commands)
+	}
+	if strings.Join(commands[:len(expected)], "|") != strings.Join(expected, "|") {
+		t.Errorf("unexpected transcript commands %q", commands)
+	}
+
+	rcpt := results.Transcript.Entries[4]
+	if strings.Contains(rcpt.Reply, "John.Doe") || !strings.Contains(rcpt.Reply, "***@acme.com") {
+		t.Errorf("recipient should be redacted in replies, got %q", rcpt.Reply)
+	}
+	if !results.Transcript.RedactRecipient || results.Transcript.RedactSender {
+		t.Errorf("redaction settings should be recorded")
+	}
+}
+
+func TestVerifyWithoutTranscript(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", nil)
+
+	results := Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		Dns:        server.DNS(),
+	})
+
+	if results.ResponseCode != "250" || !results.CanConnectSmtp {
+		t.Fatalf("expected 250, got %q", results.ResponseCode)
+	}
+	if results.Transcript != nil {
+		t.Errorf("transcript should be nil when disabled")
+	}
+}
+
+func TestVerifySendsQuitAfterTransaction(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", nil)
+
+	results := Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		Dns:        server.DNS(),
+	})
+	if results.ResponseCode != "250" {
+		t.Fatalf("expected 250, got %q", results.ResponseCode)
+	}
+
+	commands := server.Received()
+	expected := []string{"HELO probe.example", "MAIL FROM:<emma.smith@probe.example>", "RCPT TO:<john@acme.com>", "RSET", "QUIT"}
+	if strings.Join(commands, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected %q, got %q", expected, commands)
+	}
+}
+
+func TestVerifySendsPercentInLocalPart(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", nil)
+
+	Verify(VerifyRequest{
+		Email:      "john%sales@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		Dns:        server.DNS(),
+	})
+
+	if !hasCommand(server.Received(), "RCPT TO:<john%sales@acme.com>") {
+		t.Errorf("expected the address sent as is, got %q", server.Received())
+	}
+}
+
+func TestVerifyQuitsWithoutTransaction(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+		"MAIL FROM": "554 5.7.1 Client host rejected",
+	})
+
+	results := Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		Dns:        server.DNS(),
+	})
+	if results.ResponseCode != "554" {
+		t.Fatalf("expected 554, got %q", results.ResponseCode)
+	}
+
+	commands := server.Received()
+	if commands[len(commands)-1] != "QUIT" || strings.Contains(strings.Join(commands, "|"), "RSET") {
+		t.Errorf("expected QUIT without RSET, got %q", commands)
+	}
+}
+
+func TestVerifyConnectionDroppedMidTransaction(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+		"RCPT TO": dropConnection,
+	})
+
+	results := Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		Dns:        server.DNS(),
+	})
+	if !results.ConnectionDropped {
+		t.Errorf("expected the dropped connection to be reported")
+	}
+	if results.CanConnectSmtp {
+		t.Errorf("expected CanConnectSmtp to be false")
+	}
+	for _, cmd := range server.Received() {
+		if cmd == "QUIT" || cmd == "RSET" {
+			t.Errorf("should not talk to a server that hung up, sent %q", cmd)
+		}
+	}
+}
+
+func TestVerifyDescribesMidSessionFailures(t *testing.T) {
+	tests := []struct {
+		command     string
+		reply       string
+		description string
+	}{
+		{"HELO", dropConnection, "Connection dropped during HELO"},
+		{"MAIL FROM", dropConnection, "Connection dropped during MAIL FROM"},
+		{"MAIL FROM", ignoreCommand, "No reply to MAIL FROM"},
+		{"RCPT TO", ignoreCommand, "No reply to RCPT TO"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+				tt.command: tt.reply,
+			})
+
+			results := Verify(VerifyRequest{
+				Email:          "john@acme.com",
+				FromDomain:     "probe.example",
+				FromEmail:      "emma.smith@probe.example",
+				CommandTimeout: 100 * time.Millisecond,
+				QuitTimeout:    100 * time.Millisecond,
+				Dns:            server.DNS(),
+			})
+			if results.Description != tt.description {
+				t.Errorf("expected %q, got %q", tt.description, results.Description)
+			}
+			if !results.ConnectionDropped {
+				t.Errorf("expected the dropped connection to be reported")
+			}
+		})
+	}
+}
+
+func TestVerifyCannotConnect(t *testing.T) {
+	// Nothing listens on 127.0.0.3
+	results := Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		Dns:        domaincheck.DNS{MX: []string{"127.0.0.3"}},
+	})
+	if results.Description != "Cannot connect to any MX server" {
+		t.Errorf("expected an unreachable host to be reported, got %q", results.Description)
+	}
+}
+
+func TestFailoverKeepsMidSessionFailure(t *testing.T) {
+	primary := startFakeServerAt(t, "127.0.0.1", "220 mx1.acme.com ESMTP", map[string]string{
+		"MAIL FROM": dropConnection,
+	})
+
+	results := Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		// Nothing listens on 127.0.0.3, so the backup fails to connect
+		Dns: domaincheck.DNS{MX: []string{primary.IP, "127.0.0.3"}},
+	})
+	if results.Description != "Connection dropped during MAIL FROM" || results.MxHost != primary.IP {
+		t.Errorf("expected the primary's dropped session to be kept, got %q from %q", results.Description, results.MxHost)
+	}
+}
+
+func TestVerifyQuitTimesOut(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+		"QUIT": ignoreCommand,
+	})
+
+	started := time.Now()
+	results := Verify(VerifyRequest{
+		Email:       "john@acme.com",
+		FromDomain:  "probe.example",
+		FromEmail:   "emma.smith@probe.example",
+		QuitTimeout: 100 * time.Millisecond,
+		Dns:         server.DNS(),
+	})
+	if results.ResponseCode != "250" {
+		t.Fatalf("expected 250, got %q", results.ResponseCode)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("QUIT should time out quickly, took %s", elapsed)
+	}
+}
+
+func TestVerifyCommandTimeout(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+		"RCPT TO": ignoreCommand,
+	})
+
+	results := Verify(VerifyRequest{
+		Email:          "john@acme.com",
+		FromDomain:     "probe.example",
+		FromEmail:      "emma.smith@probe.example",
+		CommandTimeout: 100 * time.Millisecond,
+		QuitTimeout:    100 * time.Millisecond,
+		Dns:            server.DNS(),
+	})
+	if results.CanConnectSmtp || !results.ConnectionDropped {
+		t.Errorf("expected a timed out RCPT to be reported as a dropped connection, got %+v", results)
+	}
+}
+
+func TestMultilineReply(t *testing.T) {
+	server := startFakeServer(t, "220-mx.acme.com ESMTP\n220 ready", map[string]string{
+		"RCPT TO": "550-5.2.1 The email account that you tried to reach is inactive. For more\n550-5.2.1 information, go to\n550 5.2.1 https://support.google.com/mail/?p=DisabledUser",
+	})
+
+	results := Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		Dns:        server.DNS(),
+	})
+
+	expected := "The email account that you tried to reach is inactive. For more information, go to https://support.google.com/mail/?p=DisabledUser"
+	if results.ResponseCode != "550" || results.ErrorCode != "5.2.1" || results.Description != expected {
+		t.Errorf("unexpected parse of multiline reply: %q %q %q", results.ResponseCode, results.ErrorCode, results.Description)
+	}
+
+	commands := server.Received()
+	if commands[len(commands)-1] != "QUIT" {
+		t.Errorf("session should stay in sync after a multiline reply, got %q", commands)
+	}
+}
+
+func TestFailoverOnTemporaryMailFrom(t *testing.T) {
+	primary := startFakeServerAt(t, "127.0.0.1", "220 mx1.acme.com ESMTP", map[string]string{
+		"MAIL FROM": "451 4.7.1 Try again later",
+	})
+	backup := startFakeServerAt(t, "127.0.0.2", "220 mx2.acme.com ESMTP", map[string]string{
+		"RCPT TO": "550 5.1.1 User unknown",
+	})
+
+	results := Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		Failover:   &FailoverOptions{FullFailover: true},
+		Dns:        domaincheck.DNS{MX: []string{primary.IP, backup.IP}},
+	})
+
+	if results.ResponseCode != "550" || results.MxHost != backup.IP {
+		t.Fatalf("expected the backup's 550, got %q from %q", results.ResponseCode, results.MxHost)
+	}
+	if len(results.MxAttempts) != 2 || results.MxAttempts[0].ResponseCode != "451" {
+		t.Errorf("expected both hosts to be recorded, got %+v", results.MxAttempts)
+	}
+}
+
+func TestFailoverZeroValueIsConnectOnly(t *testing.T) {
+	primary := startFakeServerAt(t, "127.0.0.1", "220 mx1.acme.com ESMTP", map[string]string{
+		"HELO": "421 4.3.2 Service shutting down",
+	})
+	backup := startFakeServerAt(t, "127.0.0.2", "220 mx2.acme.com ESMTP", nil)
+
+	results := Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		Failover:   &FailoverOptions{MaxHosts: 2},
+		Dns:        domaincheck.DNS{MX: []string{primary.IP, backup.IP}},
+	})
+
+	if results.ResponseCode != "421" || len(results.MxAttempts) != 1 {
+		t.Errorf("expected to stop at the primary, got %q after %d attempts", results.ResponseCode, len(results.MxAttempts))
+	}
+	if len(backup.Received()) != 0 {
+		t.Errorf("backup should not have been contacted")
+	}
+}
+
+func TestFailoverKeepsMostInformativeResult(t *testing.T) {
+	primary := startFakeServerAt(t, "127.0.0.1", "220 mx1.acme.com ESMTP", map[string]string{
+		"MAIL FROM": "450 4.7.1 Temporarily rejected",
+	})
+
+	results := Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		Failover:   &FailoverOptions{FullFailover: true},
+		// Nothing listens on 127.0.0.3, so the backup fails to connect
+		Dns: domaincheck.DNS{MX: []string{primary.IP, "127.0.0.3"}},
+	})
+
+	if results.ResponseCode != "450" || results.MxHost != primary.IP {
+		t.Errorf("expected the primary's 450 to be kept, got %q from %q", results.ResponseCode, results.MxHost)
+	}
+	if len(results.MxAttempts) != 2 {
+		t.Errorf("expected 2 attempts, got %+v", results.MxAttempts)
+	}
+}
+
+func TestNoFailoverWithoutOptions(t *testing.T) {
+	primary := startFakeServerAt(t, "127.0.0.1", "220 mx1.acme.com ESMTP", map[string]string{
+		"MAIL FROM": "451 4.7.1 Try again later",
+	})
+	backup := startFakeServerAt(t, "127.0.0.2", "220 mx2.acme.com ESMTP", nil)
+
+	results := Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		Dns:        domaincheck.DNS{MX: []string{primary.IP, backup.IP}},
+	})
+
+	if results.ResponseCode != "451" || results.MxHost != primary.IP {
+		t.Errorf("expected the primary's 451, got %q from %q", results.ResponseCode, results.MxHost)
+	}
+	if len(backup.Received()) != 0 {
+		t.Errorf("backup should not have been contacted")
+	}
+}
+
+func TestFailoverAdmitsEachHost(t *testing.T) {
+	primary := startFakeServerAt(t, "127.0.0.1", "220 mx1.acme.com ESMTP", nil)
+	backup := startFakeServerAt(t, "127.0.0.2", "220 mx2.acme.com ESMTP", map[string]string{
+		"RCPT TO": "550 5.1.1 User unknown",
+	})
+
+	var admitted, finished []string
+	results := Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		Failover:   &FailoverOptions{FullFailover: true},
+		Admit: func(host string) (func(SMPTValidation), error) {
+			admitted = append(admitted, host)
+			if host == primary.IP {
+				return nil, errors.New("circuit open")
+			}
+			return func(result SMPTValidation) {
+				finished = append(finished, result.MxHost)
+			}, nil
+		},
+		Dns: domaincheck.DNS{MX: []string{primary.IP, backup.IP}},
+	})
+
+	if results.ResponseCode != "550" || results.MxHost != backup.IP {
+		t.Errorf("expected the backup's 550, got %q from %q", results.ResponseCode, results.MxHost)
+	}
+	if strings.Join(admitted, ",") != primary.IP+","+backup.IP {
+		t.Errorf("expected both hosts to be admitted in order, got %q", admitted)
+	}
+	if strings.Join(finished, ",") != backup.IP {
+		t.Errorf("expected only the backup's result to be reported, got %q", finished)
+	}
+	if len(primary.Received()) != 0 {
+		t.Errorf("refused primary should not have been contacted")
+	}
+	if len(results.MxAttempts) != 2 || !results.MxAttempts[0].Skipped {
+		t.Errorf("expected the refused primary to be recorded as skipped, got %+v", results.MxAttempts)
+	}
+}
+
+func TestFailoverSenderPerHost(t *testing.T) {
+	primary := startFakeServerAt(t, "127.0.0.1", "220 mx1.acme.com ESMTP", map[string]string{
+		"MAIL FROM": "451 4.7.1 Try again later",
+	})
+	backup := startFakeServerAt(t, "127.0.0.2", "220 mx2.acme.com ESMTP", nil)
+
+	senders := map[string]Sender{
+		primary.IP: {HeloName: "mail.one.example", FromDomain: "one.example", FromEmail: "emma.smith@one.example"},
+		backup.IP:  {HeloName: "mail.two.example", FromDomain: "two.example", FromEmail: "liam.jones@two.example"},
+	}
+	var calls []string
+	Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		Failover:   &FailoverOptions{FullFailover: true},
+		SenderFor: func(host string) (Sender, error) {
+			calls = append(calls, "sender "+host)
+			return senders[host], nil
+		},
+		Admit: func(host string) (func(SMPTValidation), error) {
+			calls = append(calls, "admit "+host)
+			return nil, nil
+		},
+		Dns: domaincheck.DNS{MX: []string{primary.IP, backup.IP}},
+	})
+
+	expected := []string{"sender " + primary.IP, "admit " + primary.IP, "sender " + backup.IP, "admit " + backup.IP}
+	if strings.Join(calls, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected each sender to be picked before admission, got %q", calls)
+	}
+	for _, server := range []*fakeServer{primary, backup} {
+		sender := senders[server.IP]
+		if !hasCommand(server.Received(), "HELO "+sender.HeloName) ||
+			!hasCommand(server.Received(), "MAIL FROM:<"+sender.FromEmail+">") {
+			t.Errorf("expected %s to be probed from its own sender, got %q", server.IP, server.Received())
+		}
+	}
+}
+
+func TestAllHostsRefused(t *testing.T) {
+	results := Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		Admit: func(host string) (func(SMPTValidation), error) {
+			return nil, errors.New("rate limited")
+		},
+		Dns: domaincheck.DNS{MX: []string{"127.0.0.3"}},
+	})
+
+	if results.CanConnectSmtp || results.Description != "rate limited" {
+		t.Errorf("expected the refusal to be reported, got %q", results.Description)
+	}
+}
+
+func TestFailoverHosts(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mx       []string
+		options  FailoverOptions
+		expected []string
+		skipped  []string
+	}{
+		{
+			name:     "all hosts",
+			mx:       []string{"mx1.acme.com", "mx2.acme.com"},
+			expected: []string{"mx1.acme.com", "mx2.acme.com"},
+		},
+		{
+			name:     "max hosts",
+			mx:       []string{"mx1.acme.com", "mx2.acme.com", "mx3.acme.com"},
+			options:  FailoverOptions{MaxHosts: 2},
+			expected: []string{"mx1.acme.com", "mx2.acme.com"},
+		},
+		{
+			name:     "backup is not guessed from its name",
+			mx:       []string{"mx1.acme.com", "backup-mx.acme.com"},
+			expected: []string{"mx1.acme.com", "backup-mx.acme.com"},
+		},
+		{
+			name:     "configured accept-all backup",
+			mx:       []string{"mx1.acme.com", "mx.spoolhost.example"},
+			options:  FailoverOptions{AcceptAllHosts: []string{"spoolhost.example"}},
+			expected: []string{"mx1.acme.com"},
+			skipped:  []string{"mx.spoolhost.example"},
+		},
+		{
+			name:     "learned accept-all backup",
+			mx:       []string{"mx1.acme.com", "mx2.acme.com."},
+			options:  FailoverOptions{Learned: learnedBackups("mx2.acme.com")},
+			expected: []string{"mx1.acme.com"},
+			skipped:  []string{"mx2.acme.com."},
+		},
+		{
+			name:     "primary is never skipped",
+			mx:       []string{"mx.spoolhost.example", "mx2.acme.com"},
+			options:  FailoverOptions{AcceptAllHosts: []string{"spoolhost.example"}},
+			expected: []string{"mx.spoolhost.example", "mx2.acme.com"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hosts, skipped := failoverHosts(tc.mx, tc.options)
+			if strings.Join(hosts, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("expected hosts %q, got %q", tc.expected, hosts)
+			}
+			if strings.Join(skipped, ",") != strings.Join(tc.skipped, ",") {
+				t.Errorf("expected skipped %q, got %q", tc.skipped, skipped)
+			}
+		})
+	}
+}
+
+func learnedBackups(hosts ...string) *AcceptAllBackups {
+	learned := NewAcceptAllBackups()
+	for _, host := range hosts {
+		learned.Add(host, "accepted the catch-all decoys")
+	}
+	return learned
+}
+
+func TestVerifyLearnsLowReliabilityBackup(t *testing.T) {
+	backup := startFakeServer(t, "220 mail.acme.com Microsoft ESMTP MAIL Service ready", nil)
+	failover := &FailoverOptions{Learned: NewAcceptAllBackups()}
+	req := VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		Failover:   failover,
+		// Nothing listens on 127.0.0.3, so the backup answers
+		Dns: domaincheck.DNS{MX: []string{"127.0.0.3", backup.IP}},
+	}
+
+	if results := Verify(req); results.MxHost != backup.IP {
+		t.Fatalf("expected the backup to answer, got %q", results.MxHost)
+	}
+	if reason := failover.Learned.Reason(backup.IP); !strings.Contains(reason, "Microsoft Exchange") {
+		t.Errorf("expected the backup to be learned as accepting all mail, got %q", reason)
+	}
+
+	results := Verify(req)
+	if first := results.MxAttempts[0]; first.Host != backup.IP || !first.Skipped {
+		t.Errorf("expected the learned backup to be skipped, got %+v", results.MxAttempts)
+	}
+}
+
+func TestNullSenderMode(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", nil)
+
+	results := Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		SenderMode: SenderModeNull,
+		Dns:        server.DNS(),
+	})
+
+	if results.SenderMode != SenderModeNull {
+		t.Errorf("expected null sender mode, got %q", results.SenderMode)
+	}
+	if commands := server.Received(); commands[1] != "MAIL FROM:<>" {
+		t.Errorf("expected MAIL FROM:<>, got %q", commands[1])
+	}
+}
+
+func TestSenderModeFallback(t *testing.T) {
+	testCases := []struct {
+		name         string
+		mode         SenderMode
+		rejectedFrom string
+		expectedMode SenderMode
+		expectedFrom string
+	}{
+		{
+			name:         "address rejected, null accepted",
+			mode:         SenderModeAddress,
+			rejectedFrom: "MAIL FROM:<EMMA",
+			expectedMode: SenderModeNull,
+			expectedFrom: "MAIL FROM:<>",
+		},
+		{
+			name:         "null rejected, address accepted",
+			mode:         SenderModeNull,
+			rejectedFrom: "MAIL FROM:<>",
+			expectedMode: SenderModeAddress,
+			expectedFrom: "MAIL FROM:<emma.smith@probe.example>",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+				tc.rejectedFrom: "553 5.1.7 Sender address rejected: not accepted here",
+			})
+
+			results := Verify(VerifyRequest{
+				Email:      "john@acme.com",
+				FromDomain: "probe.example",
+				FromEmail:  "emma.smith@probe.example",
+				SenderMode: tc.mode,
+				Dns:        server.DNS(),
+			})
+
+			if results.ResponseCode != "250" || results.SenderMode != tc.expectedMode {
+				t.Fatalf("expected 250 in %q mode, got %q in %q mode", tc.expectedMode, results.ResponseCode, results.SenderMode)
+			}
+			commands := server.Received()
+			if commands[2] != "RSET" || commands[3] != tc.expectedFrom {
+				t.Errorf("expected RSET then %q, got %q", tc.expectedFrom, commands)
+			}
+		})
+	}
+}
+
+func TestSenderModeFallbackDisabled(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+		"MAIL FROM": "550 5.1.8 Bad sender's system address",
+	})
+
+	results := Verify(VerifyRequest{
+		Email:                 "john@acme.com",
+		FromDomain:            "probe.example",
+		FromEmail:             "emma.smith@probe.example",
+		DisableSenderFallback: true,
+		Dns:                   server.DNS(),
+	})
+
+	if results.ResponseCode != "550" || results.SenderMode != SenderModeAddress {
+		t.Errorf("expected the address mode rejection, got %q in %q mode", results.ResponseCode, results.SenderMode)
+	}
+}
+
+func TestIsSenderPolicyRejection(t *testing.T) {
+	testCases := []struct {
+		reply    string
+		expected bool
+	}{
+		{"550 5.1.8 Bad sender's system address", true},
+		{"553 5.1.7 The sender address is invalid", true},
+		{"550 5.7.1 Null sender is not allowed", true},
+		{"550 5.7.23 SPF validation failed", true},
+		{"550 Empty reverse-path not accepted", true},
+		{"554 5.7.1 Client host rejected", false},
+		{"554 5.7.1 Sender address rejected: listed in zen.spamhaus.org", false},
+		{"450 4.7.1 Sender address rejected: greylisted, try again later", false},
+		{"451 4.1.8 Sender address rejected: domain not found", false},
+		{"250 2.1.0 Ok", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.reply, func(t *testing.T) {
+			if got := isSenderPolicyRejection(tc.reply); got != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestVrfyDefinitiveSkipsRcpt(t *testing.T) {
+	testCases := []struct {
+		name   string
+		reply  string
+		status VrfyStatus
+	}{
+		{"exists", "250 John Smith <john@acme.com>", VrfyExists},
+		{"forwarded", "251 User not local; will forward to <john@globex.com>", VrfyExists},
+		{"not exists", "550 5.1.1 No such user", VrfyNotExists},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+				"EHLO": "250-mx.acme.com\n250-VRFY\n250 SIZE 10240000",
+				"VRFY": tc.reply,
+			})
+
+			results := Verify(VerifyRequest{
+				Email:      "john@acme.com",
+				FromDomain: "probe.example",
+				FromEmail:  "emma.smith@probe.example",
+				TryVrfy:    true,
+				Dns:        server.DNS(),
+			})
+
+			if results.Vrfy == nil || results.Vrfy.Status != tc.status || results.Vrfy.Command != "VRFY" {
+				t.Fatalf("expected VRFY %q, got %+v", tc.status, results.Vrfy)
+			}
+			if !results.CanConnectSmtp || results.ResponseCode != "" {
+				t.Errorf("expected a connection without a RCPT verdict, got %+v", results)
+			}
+			expected := []string{"EHLO probe.example", "VRFY john@acme.com", "QUIT"}
+			if commands := server.Received(); strings.Join(commands, ",") != strings.Join(expected, ",") {
+				t.Errorf("expected %q, got %q", expected, commands)
+			}
+		})
+	}
+}
+
+func TestVrfyCannotVerifyFallsBackToRcpt(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+		"EHLO": "250-mx.acme.com\n250 VRFY",
+		"VRFY": "252 2.5.2 Cannot VRFY user, but will accept message",
+		"RCPT": "550 5.1.1 User unknown",
+	})
+
+	results := Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		TryVrfy:    true,
+		Dns:        server.DNS(),
+	})
+
+	if results.Vrfy == nil || results.Vrfy.Status != VrfyCannotVerify {
+		t.Fatalf("expected cannot_verify, got %+v", results.Vrfy)
+	}
+	if results.ResponseCode != "550" {
+		t.Errorf("expected the RCPT TO verdict, got %q", results.ResponseCode)
+	}
+}
+
+func TestVrfyFallsBackToExpnAndHelo(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+		"EHLO": "250-mx.acme.com\n250-EXPN\n250 8BITMIME",
+		"VRFY": "502 5.5.1 VRFY command is disabled",
+		"EXPN": "250 <john@acme.com>",
+	})
+
+	results := Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		TryVrfy:    true,
+		Dns:        server.DNS(),
+	})
+	if results.Vrfy == nil || results.Vrfy.Command != "EXPN" || results.Vrfy.Status != VrfyExists {
+		t.Fatalf("expected EXPN exists, got %+v", results.Vrfy)
+	}
+
+	legacy := startFakeServerAt(t, "127.0.0.2", "220 mx.acme.com SMTP", map[string]string{
+		"EHLO": "500 5.5.1 Command unrecognized",
+		"VRFY": "502 5.5.1 Command not implemented",
+		"RCPT": "250 2.1.5 Ok",
+	})
+	results = Verify(VerifyRequest{
+		Email:               "john@acme.com",
+		FromDomain:          "probe.example",
+		FromEmail:           "emma.smith@probe.example",
+		TryVrfy:             true,
+		TryUnadvertisedVrfy: true,
+		Dns:                 legacy.DNS(),
+	})
+	if results.ResponseCode != "250" || results.Vrfy == nil || results.Vrfy.Status != VrfyUnsupported {
+		t.Errorf("expected RCPT TO after unsupported VRFY, got %q and %+v", results.ResponseCode, results.Vrfy)
+	}
+	if commands := legacy.Received(); commands[1] != "HELO probe.example" || commands[3] != "MAIL FROM:<emma.smith@probe.example>" {
+		t.Errorf("expected HELO fallback and no EXPN, got %q", commands)
+	}
+}
+
+func TestVrfyOnlyWhenAdvertised(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+		"EHLO": "250-mx.acme.com\n250 SIZE 10240000",
+		"RCPT": "250 2.1.5 Ok",
+	})
+
+	results := Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		TryVrfy:    true,
+		Dns:        server.DNS(),
+	})
+
+	if results.Vrfy != nil || results.ResponseCode != "250" {
+		t.Errorf("expected RCPT TO without VRFY, got %q and %+v", results.ResponseCode, results.Vrfy)
+	}
+	if hasCommand(server.Received(), "VRFY") {
+		t.Errorf("expected no VRFY to a server not advertising it, got %q", server.Received())
+	}
+}
+
+func TestClassifyVrfyReply(t *testing.T) {
+	testCases := []struct {
+		code        string
+		errorCode   string
+		description string
+		expected    VrfyStatus
+	}{
+		{"250", "", "John Smith <john@acme.com>", VrfyExists},
+		{"251", "", "User not local; will forward", VrfyExists},
+		{"252", "", "Cannot VRFY user", VrfyCannotVerify},
+		{"550", "", "No such user here", VrfyNotExists},
+		{"550", "5.1.1", "No such user here", VrfyNotExists},
+		{"550", "", "Administrative prohibition", VrfyUnsupported},
+		{"550", "5.7.1", "Access denied", VrfyUnsupported},
+		{"550", "5.4.1", "Recipient address rejected", VrfyCannotVerify},
+		{"551", "", "User not local; please try <john@globex.com>", VrfyCannotVerify},
+		{"553", "", "User ambiguous", VrfyCannotVerify},
+		{"502", "", "Command not implemented", VrfyUnsupported},
+		{"450", "", "Try again later", VrfyCannotVerify},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.code+" "+tc.errorCode+" "+tc.description, func(t *testing.T) {
+			if got := classifyVrfyReply(tc.code, tc.errorCode, tc.description); got != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestVerifyDecoysInSameSession(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+		"RCPT TO:<JOHN@":  "250 2.1.5 Ok",
+		"RCPT TO:<ZQX":    "550 5.1.1 User unknown",
+		"RCPT TO:<OLIVIA": "250 2.1.5 Ok",
+	})
+
+	results := Verify(VerifyRequest{
+		Email:      "john@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		Decoys:     []string{"zqx81k@acme.com", "olivia.ross@acme.com"},
+		Dns:        server.DNS(),
+	})
+
+	if results.ResponseCode != "250" || len(results.DecoyReplies) != 2 {
+		t.Fatalf("expected the target and two decoy replies, got %+v", results)
+	}
+	if results.DecoyReplies[0].ResponseCode != "550" || results.DecoyReplies[1].ResponseCode != "250" {
+		t.Errorf("unexpected decoy replies %+v", results.DecoyReplies)
+	}
+	commands := server.Received()
+	expected := []string{"RCPT TO:<john@acme.com>", "RCPT TO:<zqx81k@acme.com>", "RCPT TO:<olivia.ross@acme.com>", "RSET", "QUIT"}
+	if got := strings.Join(commands[2:], ","); got != strings.Join(expected, ",") {
+		t.Errorf("expected %q, got %q", expected, commands[2:])
+	}
+}
+
+func TestVerifyTimingRounds(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", nil)
+
+	results := Verify(VerifyRequest{
+		Email:        "john@acme.com",
+		FromDomain:   "probe.example",
+		FromEmail:    "emma.smith@probe.example",
+		Decoys:       []string{"bravehawk@acme.com"},
+		TimingRounds: 3,
+		Dns:          server.DNS(),
+	})
+
+	if len(results.TimingSamples) != 6 {
+		t.Fatalf("expected 3 rounds of 2 samples, got %+v", results.TimingSamples)
+	}
+	for i, sample := range results.TimingSamples {
+		expected := "john@acme.com"
+		if i%2 == 1 {
+			expected = "bravehawk@acme.com"
+		}
+		if sample.Email != expected || sample.Round != i/2+1 || sample.ResponseCode != "250" {
+			t.Errorf("unexpected sample %d: %+v", i, sample)
+		}
+	}
+	if commands := server.Received(); len(commands) != 2+2+6+2 {
+		t.Errorf("expected the rounds in the same transaction, got %q", commands)
+	}
+}
+
+func TestVerifySmtpUtf8(t *testing.T) {
+	t.Run("should send SMTPUTF8 to servers that support it", func(t *testing.T) {
+		server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+			"EHLO": "250-mx.acme.com\n250 SMTPUTF8",
+		})
+
+		results := Verify(VerifyRequest{
+			Email:      "josé@acme.com",
+			FromDomain: "probe.example",
+			FromEmail:  "emma.smith@probe.example",
+			Dns:        server.DNS(),
+		})
+
+		if results.SmtpUtf8Unsupported || results.ResponseCode != "250" {
+			t.Errorf("expected the probe to run, got %+v", results)
+		}
+		commands := server.Received()
+		if len(commands) < 3 || commands[1] != "MAIL FROM:<emma.smith@probe.example> SMTPUTF8" {
+			t.Errorf("expected MAIL FROM with SMTPUTF8, got %q", commands)
+		}
+	})
+
+	t.Run("should not probe servers without SMTPUTF8", func(t *testing.T) {
+		server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+			"EHLO": "250-mx.acme.com\n250 PIPELINING",
+		})
+
+		results := Verify(VerifyRequest{
+			Email:      "josé@acme.com",
+			FromDomain: "probe.example",
+			FromEmail:  "emma.smith@probe.example",
+			Dns:        server.DNS(),
+		})
+
+		if !results.SmtpUtf8Unsupported || !results.CanConnectSmtp || results.ResponseCode != "" {
+			t.Errorf("expected SMTPUTF8 to be reported missing, got %+v", results)
+		}
+		for _, cmd := range server.Received() {
+			if strings.HasPrefix(cmd, "MAIL FROM") {
+				t.Errorf("expected no transaction, got %q", server.Received())
+			}
+		}
+	})
+}
+
+func TestSmtpUtf8UnsupportedStopsFailover(t *testing.T) {
+	primary := startFakeServerAt(t, "127.0.0.1", "220 mx1.acme.com ESMTP", map[string]string{
+		"EHLO": "250-mx1.acme.com\n250 PIPELINING",
+	})
+	backup := startFakeServerAt(t, "127.0.0.2", "220 mx2.acme.com ESMTP", map[string]string{
+		"EHLO": "250-mx2.acme.com\n250 SMTPUTF8",
+	})
+
+	results := Verify(VerifyRequest{
+		Email:      "josé@acme.com",
+		FromDomain: "probe.example",
+		FromEmail:  "emma.smith@probe.example",
+		Failover:   &FailoverOptions{FullFailover: true},
+		Dns:        domaincheck.DNS{MX: []string{primary.IP, backup.IP}},
+	})
+
+	if !results.SmtpUtf8Unsupported || results.MxHost != primary.IP {
+		t.Errorf("expected the primary's answer, got %+v", results)
+	}
+	if len(backup.Received()) != 0 {
+		t.Errorf("backup should not have been contacted")
+	}
+}
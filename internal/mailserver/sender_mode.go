@@ -0,0 +1,90 @@
+package mailserver
+
+import "strings"
+
+// SenderMode is the reverse-path used in MAIL FROM
+type SenderMode string
+
+const (
+	// MAIL FROM:<first.last@FromDomain>
+	SenderModeAddress SenderMode = "address"
+	// MAIL FROM:<>, the bounce-style null sender
+	SenderModeNull SenderMode = "null"
+)
+
+func (m SenderMode) other() SenderMode {
+	if m == SenderModeNull {
+		return SenderModeAddress
+	}
+	return SenderModeNull
+}
+
+func (m SenderMode) reversePath(fromEmail string) string {
+	if m == SenderModeNull {
+		return ""
+	}
+	return fromEmail
+}
+
+// Phrases servers use when refusing the null sender specifically. Broader
+// words such as "sender" also appear in blocklist replies
+var nullSenderPhrases = []string{
+	"null sender", "null reverse-path", "null reverse path",
+	"empty sender", "empty reverse-path", "empty reverse path",
+}
+
+// isSenderPolicyRejection reports whether a MAIL FROM reply rejects the
+// reverse-path itself, so the other sender mode may be accepted. Temporary
+// failures never qualify: switching the sender would break the greylisting
+// triplet a retry depends on.
+func isSenderPolicyRejection(reply string) bool {
+	code, errorCode, description := ParseSmtpResponse(reply)
+	if !strings.HasPrefix(code, "5") {
+		return false
+	}
+
+	switch errorCode {
+	case "5.1.7", "5.1.8", "5.7.23", "5.7.25", "5.7.27":
+		return true
+	}
+
+	desc := strings.ToLower(description)
+	for _, phrase := range nullSenderPhrases {
+		if strings.Contains(desc, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateMailFrom sends MAIL FROM in the requested mode and, if the
+// reverse-path is refused on policy grounds, retries with the other mode
+func negotiateMailFrom(session *smtpSession, req VerifyRequest) (code, desc string, mode SenderMode, err error) {
+	mode = req.SenderMode
+	if mode == "" {
+		mode = SenderModeAddress
+	}
+
+	code, desc, err = sendMAILFROM(session, mode.reversePath(req.FromEmail))
+	if err != nil || code == "250" || req.DisableSenderFallback {
+		return code, desc, mode, err
+	}
+	if !isSenderPolicyRejection(code + " " + desc) {
+		return code, desc, mode, nil
+	}
+
+	// Some servers won't take a second MAIL FROM without clearing state first
+	if resetErr := session.reset(); resetErr != nil {
+		return code, desc, mode, nil
+	}
+
+	fallback := mode.other()
+	fallbackCode, fallbackDesc, fallbackErr := sendMAILFROM(session, fallback.reversePath(req.FromEmail))
+	if fallbackErr != nil {
+		return code, desc, mode, fallbackErr
+	}
+	if fallbackCode != "250" {
+		return code, desc, mode, nil
+	}
+	return fallbackCode, fallbackDesc, fallback, nil
+}
@@ -0,0 +1,255 @@
+package mailserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultCommandTimeout = 30 * time.Second
+	defaultQuitTimeout    = 5 * time.Second
+)
+
+// smtpSession is a connection to a single MX host
+type smtpSession struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	mxHost     string
+	mxIP       string
+	transcript *transcriptRecorder
+
+	commandTimeout time.Duration
+	quitTimeout    time.Duration
+
+	// A MAIL FROM was accepted and the transaction hasn't been reset
+	inTransaction bool
+	// The server closed the connection or announced it is closing it
+	dropped bool
+	// MAIL FROM and VRFY carry the SMTPUTF8 parameter
+	smtpUtf8 bool
+
+	// Raw greeting, EHLO and 4xx/5xx replies, for fingerprinting the MTA
+	greeting     string
+	ehlo         string
+	errorReplies []string
+
+	latency PhaseLatency
+	timings []phaseTiming
+}
+
+func (s *smtpSession) readSMTPgreeting() (string, string) {
+	started := time.Now()
+	s.setDeadline(s.commandTimeout)
+
+	reply, raw, err := s.readReply()
+	s.transcript.record(s.mxHost, s.mxIP, "", raw, started, err)
+	s.observe("", time.Since(started), err)
+	if err != nil {
+		s.markDropped(err)
+		return "", ""
+	}
+	s.greeting = raw
+
+	code, _ := parseSmtpCommand(reply)
+	if code == "421" {
+		s.dropped = true
+	}
+	return code, raw + "\n"
+}
+
+func (s *smtpSession) sendSMTPcommand(cmd string) (string, error) {
+	return s.sendWithTimeout(cmd, s.commandTimeout)
+}
+
+func (s *smtpSession) sendWithTimeout(cmd string, timeout time.Duration) (string, error) {
+	reply, _, err := s.exchange(cmd, timeout)
+	return reply, err
+}
+
+// exchange sends cmd and returns its reply both folded and as raw lines
+func (s *smtpSession) exchange(cmd string, timeout time.Duration) (string, string, error) {
+	started := time.Now()
+	s.setDeadline(timeout)
+
+	_, err := io.WriteString(s.conn, cmd+"\r\n")
+	if err != nil {
+		s.transcript.record(s.mxHost, s.mxIP, cmd, "", started, err)
+		s.markDropped(err)
+		return "", "", fmt.Errorf("failed to send SMTP command %s: %w", cmd, err)
+	}
+
+	reply, raw, err := s.readReply()
+	s.transcript.record(s.mxHost, s.mxIP, cmd, raw, started, err)
+	s.observe(cmd, time.Since(started), err)
+	if err != nil {
+		s.markDropped(err)
+		return "", "", fmt.Errorf("failed to read response for SMTP command %s: %w", cmd, err)
+	}
+
+	code, _ := parseSmtpCommand(reply)
+	// 421 means the server is shutting the channel down
+	if code == "421" {
+		s.dropped = true
+	}
+	if strings.HasPrefix(code, "4") || strings.HasPrefix(code, "5") {
+		s.errorReplies = append(s.errorReplies, raw)
+	}
+	return reply, raw, nil
+}
+
+// readReply reads a complete, possibly multiline, reply. It returns the
+// reply folded onto one line for parsing, and the raw lines.
+func (s *smtpSession) readReply() (string, string, error) {
+	var lines []string
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return "", strings.Join(lines, "\n"), err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		lines = append(lines, line)
+
+		// "250-" continues, "250 " (or a bare code) ends the reply
+		if len(line) < 4 || line[3] != '-' {
+			break
+		}
+	}
+	return foldReplyLines(lines), strings.Join(lines, "\n"), nil
+}
+
+// observe records how long the server took to answer cmd
+func (s *smtpSession) observe(cmd string, elapsed time.Duration, err error) {
+	phase := phaseOf(cmd)
+	if phase == "" {
+		return
+	}
+
+	var netErr net.Error
+	timedOut := errors.As(err, &netErr) && netErr.Timeout()
+	if err != nil && !timedOut {
+		return
+	}
+	s.latency.set(phase, elapsed)
+	s.timings = append(s.timings, phaseTiming{phase: phase, elapsed: elapsed, timedOut: timedOut})
+}
+
+// slowestReply is the longest the server made us wait for a reply, and
+// whether that wait ended in a timeout
+func (s *smtpSession) slowestReply() (time.Duration, bool) {
+	var slowest time.Duration
+	var timedOut bool
+	for _, timing := range s.timings {
+		if timing.elapsed > slowest {
+			slowest = timing.elapsed
+		}
+		timedOut = timedOut || timing.timedOut
+	}
+	return slowest, timedOut
+}
+
+// fingerprint identifies the MTA from what the session has seen so far
+func (s *smtpSession) fingerprint() *MtaFingerprint {
+	return fingerprintMTA(s.greeting, s.ehlo, s.errorReplies)
+}
+
+// reset aborts the current mail transaction so the session can start another
+func (s *smtpSession) reset() error {
+	if s.dropped {
+		return errors.New("connection closed by server")
+	}
+	resp, err := s.sendSMTPcommand("RSET")
+	if err != nil {
+		return err
+	}
+	if code, desc := parseSmtpCommand(resp); code != "250" {
+		return fmt.Errorf("RSET rejected: %s %s", code, desc)
+	}
+	s.inTransaction = false
+	return nil
+}
+
+// upgradeTLS runs the TLS handshake after the server accepted STARTTLS
+// and switches the session onto the encrypted connection
+func (s *smtpSession) upgradeTLS(config *tls.Config) (tls.ConnectionState, error) {
+	tlsConn := tls.Client(s.conn, config)
+	s.setDeadline(s.commandTimeout)
+	if err := tlsConn.Handshake(); err != nil {
+		// The connection is unusable after a failed handshake
+		s.dropped = true
+		return tls.ConnectionState{}, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	s.conn = tlsConn
+	s.reader = bufio.NewReader(tlsConn)
+	return tlsConn.ConnectionState(), nil
+}
+
+// close ends the session politely: RSET any open transaction, QUIT and
+// wait a bounded time for the 221 before closing the socket
+func (s *smtpSession) close() {
+	defer s.conn.Close()
+
+	if s.dropped {
+		return
+	}
+	if s.inTransaction {
+		if _, err := s.sendWithTimeout("RSET", s.quitTimeout); err != nil {
+			return
+		}
+		s.inTransaction = false
+	}
+	s.sendWithTimeout("QUIT", s.quitTimeout)
+}
+
+func (s *smtpSession) setDeadline(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultCommandTimeout
+	}
+	s.conn.SetDeadline(time.Now().Add(timeout))
+}
+
+func (s *smtpSession) markDropped(err error) {
+	if isConnectionDropped(err) {
+		s.dropped = true
+	}
+}
+
+// isConnectionDropped reports whether err means the connection is unusable
+func isConnectionDropped(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// foldReplyLines joins a multiline reply into a single line, dropping the
+// repeated reply and enhanced status codes from continuation lines
+func foldReplyLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+
+	folded := lines[0]
+	code, enhanced, _ := ParseSmtpResponse(lines[0])
+	for _, line := range lines[1:] {
+		text := line
+		if code != "" && len(text) >= 3 && text[:3] == code {
+			text = strings.TrimLeft(text[3:], "- ")
+		}
+		if enhanced != "" {
+			text = strings.TrimPrefix(text, enhanced)
+		}
+		if text = strings.TrimSpace(text); text != "" {
+			folded += " " + text
+		}
+	}
+	return folded
+}
@@ -0,0 +1,51 @@
+package mailserver
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimingSample is the latency of one timed RCPT TO
+type TimingSample struct {
+	Email        string
+	Round        int
+	ResponseCode string
+	LatencyMs    float64
+}
+
+// sampleRcptTiming repeats RCPT TO for the target and every decoy, round
+// after round. Interleaving them spreads network jitter and server load
+// evenly over both groups. Sampling stops early if the server hangs up.
+func sampleRcptTiming(session *smtpSession, email string, decoys []string, rounds int) []TimingSample {
+	// Latency.RcptMs stays the target's first reply
+	targetLatency := session.latency.RcptMs
+	defer func() {
+		session.latency.RcptMs = targetLatency
+	}()
+
+	recipients := append([]string{email}, decoys...)
+	var samples []TimingSample
+	for round := 1; round <= rounds; round++ {
+		for _, recipient := range recipients {
+			if session.dropped {
+				return samples
+			}
+
+			started := time.Now()
+			resp, err := session.sendSMTPcommand(fmt.Sprintf("RCPT TO:<%s>", recipient))
+			elapsed := time.Since(started)
+			if err != nil {
+				return samples
+			}
+
+			code, _ := parseSmtpCommand(resp)
+			samples = append(samples, TimingSample{
+				Email:        recipient,
+				Round:        round,
+				ResponseCode: code,
+				LatencyMs:    float64(elapsed.Microseconds()) / 1000,
+			})
+		}
+	}
+	return samples
+}
@@ -0,0 +1,204 @@
+package mailserver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"strings"
+	"time"
+
+	"github.com/customeros/mailsherpa/domaincheck"
+)
+
+// TLSInspectionRequest describes a STARTTLS inspection of a domain's MX hosts
+type TLSInspectionRequest struct {
+	// Name announced in EHLO
+	HeloName string
+	// Local IP to bind the connections to. The OS picks one when empty
+	BindIP string
+	// Defaults to the timeout Verify uses
+	CommandTimeout time.Duration
+	// Trust store for the Trusted flag. Defaults to the system roots
+	Roots *x509.CertPool
+	// Asked before each MX host is dialled, as in VerifyRequest. An error
+	// skips the host and is reported as its Error
+	Admit func(host string) (done func(SMPTValidation), err error)
+	Dns   domaincheck.DNS
+}
+
+// TLSReport is the STARTTLS posture of every MX host of a domain
+type TLSReport struct {
+	Hosts []MxTLS
+}
+
+// MxTLS is what one MX host presented when asked for STARTTLS
+type MxTLS struct {
+	Host string
+	IP   string
+	// The host advertised STARTTLS in its EHLO reply
+	StartTLS    bool
+	Protocol    string
+	CipherSuite string
+	// Peer chain as sent by the server, leaf first
+	Certificates []CertificateInfo
+	// Leaf outside its validity period
+	Expired    bool
+	SelfSigned bool
+	// Leaf isn't valid for the MX hostname
+	HostnameMismatch bool
+	// Chain verifies against the trust store, ignoring the hostname
+	Trusted bool
+	Error   string
+}
+
+// CertificateInfo summarizes a certificate in the peer chain
+type CertificateInfo struct {
+	Subject    string
+	SANs       []string
+	Issuer     string
+	NotBefore  time.Time
+	NotAfter   time.Time
+	SelfSigned bool
+}
+
+// InspectTLS opens a STARTTLS session to each MX host and records its
+// certificate chain and negotiated parameters. Expired or self-signed
+// certificates often mean the mail infrastructure is abandoned.
+func InspectTLS(req TLSInspectionRequest) TLSReport {
+	var report TLSReport
+	for _, host := range req.Dns.MX {
+		report.Hosts = append(report.Hosts, inspectHost(host, req))
+	}
+	return report
+}
+
+func inspectHost(host string, req TLSInspectionRequest) MxTLS {
+	var done func(SMPTValidation)
+	if req.Admit != nil {
+		var err error
+		if done, err = req.Admit(host); err != nil {
+			return MxTLS{Host: host, Error: err.Error()}
+		}
+	}
+
+	result, outcome := inspectSession(host, req)
+	if done != nil {
+		done(outcome)
+	}
+	return result
+}
+
+// inspectSession runs the STARTTLS session. The outcome says whether the
+// host could be reached and what it refused, for the Admit callback
+func inspectSession(host string, req TLSInspectionRequest) (result MxTLS, outcome SMPTValidation) {
+	result.Host = host
+	outcome.MxHost = host
+
+	session, err := connectToSMTP(host, smtpPort, req.BindIP, nil)
+	if err != nil {
+		result.Error = err.Error()
+		outcome.Description = result.Error
+		return result, outcome
+	}
+	session.commandTimeout = req.CommandTimeout
+	if session.commandTimeout <= 0 {
+		session.commandTimeout = defaultCommandTimeout
+	}
+	session.quitTimeout = defaultQuitTimeout
+	defer session.close()
+	result.IP = session.mxIP
+	outcome.MxIP = session.mxIP
+
+	code, desc := session.readSMTPgreeting()
+	outcome.ResponseCode, outcome.Description = code, desc
+	if code != "220" {
+		result.Error = strings.TrimSpace("Greeting failed: " + code + " " + desc)
+		return result, outcome
+	}
+	outcome.CanConnectSmtp = true
+
+	code, desc, capabilities, err := sendEHLO(session, req.HeloName)
+	if err != nil {
+		result.Error = err.Error()
+		return result, outcome
+	}
+	if code != "250" {
+		outcome.ResponseCode, outcome.Description = code, desc
+		result.Error = "EHLO rejected: " + code + " " + desc
+		return result, outcome
+	}
+	result.StartTLS = capabilities.has("STARTTLS")
+	if !result.StartTLS {
+		return result, outcome
+	}
+
+	if err := sendSTARTTLS(session); err != nil {
+		result.Error = err.Error()
+		return result, outcome
+	}
+	// Accept whatever the server presents, so it can be reported on
+	state, err := session.upgradeTLS(&tls.Config{
+		ServerName:         strings.TrimSuffix(host, "."),
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		result.Error = err.Error()
+		return result, outcome
+	}
+
+	result.Protocol = tls.VersionName(state.Version)
+	result.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	inspectChain(&result, state.PeerCertificates, req.Roots, time.Now())
+	return result, outcome
+}
+
+func inspectChain(result *MxTLS, chain []*x509.Certificate, roots *x509.CertPool, now time.Time) {
+	if len(chain) == 0 {
+		result.Error = "server presented no certificate"
+		return
+	}
+
+	for _, cert := range chain {
+		result.Certificates = append(result.Certificates, CertificateInfo{
+			Subject:    cert.Subject.String(),
+			SANs:       subjectAltNames(cert),
+			Issuer:     cert.Issuer.String(),
+			NotBefore:  cert.NotBefore,
+			NotAfter:   cert.NotAfter,
+			SelfSigned: isSelfSigned(cert),
+		})
+	}
+
+	leaf := chain[0]
+	result.Expired = now.Before(leaf.NotBefore) || now.After(leaf.NotAfter)
+	result.SelfSigned = isSelfSigned(leaf)
+	result.HostnameMismatch = leaf.VerifyHostname(strings.TrimSuffix(result.Host, ".")) != nil
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	})
+	result.Trusted = err == nil
+}
+
+func subjectAltNames(cert *x509.Certificate) []string {
+	names := append([]string(nil), cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+// isSelfSigned reports whether cert is its own issuer and signed by its
+// own key
+func isSelfSigned(cert *x509.Certificate) bool {
+	if !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+		return false
+	}
+	return cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
@@ -0,0 +1,122 @@
+package mailserver
+
+import (
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestInspectTLS(t *testing.T) {
+	t.Run("should report a self-signed certificate", func(t *testing.T) {
+		cert, key := newCertificate(t, false, nil, nil)
+		server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+			"EHLO": "250-mx.acme.com\n250 STARTTLS",
+		})
+		server.SetTLS(serverTLS(key, cert))
+
+		report := InspectTLS(TLSInspectionRequest{HeloName: "probe.example", Dns: server.DNS()})
+
+		if len(report.Hosts) != 1 {
+			t.Fatalf("expected one host, got %+v", report)
+		}
+		host := report.Hosts[0]
+		if host.Error != "" || !host.StartTLS || host.Protocol != "TLS 1.3" || host.CipherSuite == "" {
+			t.Errorf("unexpected TLS session %+v", host)
+		}
+		if !host.SelfSigned || host.Trusted || host.Expired || host.HostnameMismatch {
+			t.Errorf("unexpected flags %+v", host)
+		}
+		if len(host.Certificates) != 1 || host.Certificates[0].SANs[0] != "127.0.0.1" ||
+			host.Certificates[0].Subject != "CN=mx.acme.com" {
+			t.Errorf("unexpected chain %+v", host.Certificates)
+		}
+	})
+
+	t.Run("should trust a chain to a known root", func(t *testing.T) {
+		ca, caKey := newCertificate(t, true, nil, nil)
+		leaf, leafKey := newCertificate(t, false, ca, caKey)
+		server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+			"EHLO": "250-mx.acme.com\n250 STARTTLS",
+		})
+		server.SetTLS(serverTLS(leafKey, leaf, ca))
+		roots := x509.NewCertPool()
+		roots.AddCert(ca)
+
+		report := InspectTLS(TLSInspectionRequest{HeloName: "probe.example", Roots: roots, Dns: server.DNS()})
+
+		host := report.Hosts[0]
+		if !host.Trusted || host.SelfSigned || len(host.Certificates) != 2 || !host.Certificates[1].SelfSigned {
+			t.Errorf("unexpected report %+v", host)
+		}
+	})
+
+	t.Run("should report hosts without STARTTLS", func(t *testing.T) {
+		server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+			"EHLO": "250-mx.acme.com\n250 PIPELINING",
+		})
+
+		report := InspectTLS(TLSInspectionRequest{HeloName: "probe.example", Dns: server.DNS()})
+
+		host := report.Hosts[0]
+		if host.StartTLS || host.Error != "" || len(host.Certificates) != 0 {
+			t.Errorf("unexpected report %+v", host)
+		}
+		for _, cmd := range server.Received() {
+			if cmd == "STARTTLS" {
+				t.Errorf("expected no STARTTLS, got %q", server.Received())
+			}
+		}
+	})
+}
+
+func TestInspectTLSAdmit(t *testing.T) {
+	t.Run("should report the outcome of an admitted host", func(t *testing.T) {
+		server := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{
+			"EHLO": "250-mx.acme.com\n250 PIPELINING",
+		})
+
+		var outcome *SMPTValidation
+		report := InspectTLS(TLSInspectionRequest{
+			HeloName: "probe.example",
+			Admit: func(host string) (func(SMPTValidation), error) {
+				return func(result SMPTValidation) { outcome = &result }, nil
+			},
+			Dns: server.DNS(),
+		})
+
+		if report.Hosts[0].Error != "" || outcome == nil || !outcome.CanConnectSmtp {
+			t.Errorf("expected the admitted host's outcome, got %+v %+v", report.Hosts[0], outcome)
+		}
+	})
+
+	t.Run("should skip a refused host", func(t *testing.T) {
+		server := startFakeServer(t, "220 mx.acme.com ESMTP", nil)
+
+		report := InspectTLS(TLSInspectionRequest{
+			HeloName: "probe.example",
+			Admit: func(host string) (func(SMPTValidation), error) {
+				return nil, errors.New("circuit breaker open")
+			},
+			Dns: server.DNS(),
+		})
+
+		if report.Hosts[0].Error != "circuit breaker open" || len(server.Received()) != 0 {
+			t.Errorf("expected the refused host to be skipped, got %+v %q", report.Hosts[0], server.Received())
+		}
+	})
+}
+
+func TestInspectChainFlags(t *testing.T) {
+	cert, _ := newCertificate(t, false, nil, nil)
+
+	result := MxTLS{Host: "mx.acme.com"}
+	inspectChain(&result, []*x509.Certificate{cert}, nil, time.Now().Add(48*time.Hour))
+
+	if !result.Expired {
+		t.Errorf("expected an expired certificate")
+	}
+	if !result.HostnameMismatch {
+		t.Errorf("expected the certificate not to cover mx.acme.com")
+	}
+}
@@ -0,0 +1,120 @@
+package mailserver
+
+import (
+	"strings"
+	"time"
+)
+
+// TranscriptOptions controls whether the SMTP conversation is recorded
+type TranscriptOptions struct {
+	Enabled bool
+	// Mask the local part of the recipient in commands and replies
+	RedactRecipient bool
+	// Mask the local part of the MAIL FROM address
+	RedactSender bool
+}
+
+// Transcript is every command and reply exchanged during a probe
+type Transcript struct {
+	RedactRecipient bool
+	RedactSender    bool
+	Entries         []TranscriptEntry
+}
+
+// TranscriptEntry is one command and its reply. Command is "CONNECT" for
+// the TCP connection and empty for the server greeting.
+type TranscriptEntry struct {
+	Time      time.Time
+	LatencyMs int64
+	MxHost    string
+	MxIP      string
+	Command   string
+	Reply     string
+	Error     string
+}
+
+const redactedLocalPart = "***"
+
+type transcriptRecorder struct {
+	transcript Transcript
+	pairs      []string
+	replacer   *strings.Replacer
+}
+
+// newTranscriptRecorder returns nil when transcripts are disabled. All
+// recorder methods are safe to call on nil.
+func newTranscriptRecorder(options TranscriptOptions, fromEmail, toEmail string) *transcriptRecorder {
+	if !options.Enabled {
+		return nil
+	}
+
+	var pairs []string
+	if options.RedactRecipient {
+		pairs = append(pairs, redactionPairs(toEmail)...)
+	}
+	if options.RedactSender {
+		pairs = append(pairs, redactionPairs(fromEmail)...)
+	}
+
+	return &transcriptRecorder{
+		transcript: Transcript{
+			RedactRecipient: options.RedactRecipient,
+			RedactSender:    options.RedactSender,
+			Entries:         []TranscriptEntry{},
+		},
+		pairs:    pairs,
+		replacer: strings.NewReplacer(pairs...),
+	}
+}
+
+// redactSender also masks fromEmail, for MX hosts probed from another sender
+func (r *transcriptRecorder) redactSender(fromEmail string) {
+	if r == nil || !r.transcript.RedactSender {
+		return
+	}
+	r.pairs = append(r.pairs, redactionPairs(fromEmail)...)
+	r.replacer = strings.NewReplacer(r.pairs...)
+}
+
+func (r *transcriptRecorder) record(mxHost, mxIP, command, reply string, started time.Time, err error) {
+	if r == nil {
+		return
+	}
+
+	entry := TranscriptEntry{
+		Time:      started,
+		LatencyMs: time.Since(started).Milliseconds(),
+		MxHost:    mxHost,
+		MxIP:      mxIP,
+		Command:   r.replacer.Replace(command),
+		Reply:     r.replacer.Replace(reply),
+	}
+	if err != nil {
+		entry.Error = r.replacer.Replace(err.Error())
+	}
+	r.transcript.Entries = append(r.transcript.Entries, entry)
+}
+
+func (r *transcriptRecorder) result() *Transcript {
+	if r == nil {
+		return nil
+	}
+	transcript := r.transcript
+	return &transcript
+}
+
+// redactionPairs masks the local part of email, matching the casing servers
+// commonly echo it back in
+func redactionPairs(email string) []string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return nil
+	}
+	redacted := redactedLocalPart + email[at:]
+
+	pairs := []string{email, redacted}
+	if lower := strings.ToLower(email); lower != email {
+		pairs = append(pairs, lower, strings.ToLower(redacted))
+	}
+	return pairs
+}
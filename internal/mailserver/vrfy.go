@@ -0,0 +1,168 @@
+package mailserver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VrfyStatus is what a VRFY or EXPN reply says about the mailbox
+type VrfyStatus string
+
+const (
+	// 250 or 251: the mailbox exists, locally or by forwarding
+	VrfyExists VrfyStatus = "exists"
+	// 550 with a 5.1.x or no enhanced code: no such mailbox
+	VrfyNotExists VrfyStatus = "not_exists"
+	// 252, 551, 553 or a temporary failure: the server won't say
+	VrfyCannotVerify VrfyStatus = "cannot_verify"
+	// The server doesn't implement, has disabled or refuses us the command,
+	// e.g. 550 5.7.1
+	VrfyUnsupported VrfyStatus = "unsupported"
+)
+
+// VrfyResult is the outcome of the VRFY/EXPN phase
+type VrfyResult struct {
+	// VRFY or EXPN, whichever produced the answer
+	Command      string
+	ResponseCode string
+	ErrorCode    string
+	Description  string
+	Status       VrfyStatus
+}
+
+// Definitive reports whether the reply settles the mailbox's existence
+// without a RCPT TO transaction
+func (r *VrfyResult) Definitive() bool {
+	return r != nil && (r.Status == VrfyExists || r.Status == VrfyNotExists)
+}
+
+// Reply text servers use when VRFY or EXPN is switched off by policy
+var vrfyDisabledKeywords = []string{
+	"disabled", "not allowed", "not permitted", "prohibit",
+	"not implemented", "not supported", "unrecognized", "refused",
+}
+
+// classifyVrfyReply maps a VRFY or EXPN reply to a status per RFC 5321
+// section 3.5.3. A 550 only denies the mailbox when its enhanced code, if
+// any, is an addressing one (5.1.x); 5.7.x is a policy refusal.
+func classifyVrfyReply(code, errorCode, description string) VrfyStatus {
+	switch code {
+	case "250", "251":
+		return VrfyExists
+	case "252", "551", "553":
+		// 252 is "cannot VRFY user, but will accept message", 551 names
+		// a forward-path we'd have to follow and 553 is "user ambiguous"
+		return VrfyCannotVerify
+	case "500", "502", "504":
+		return VrfyUnsupported
+	case "550":
+		switch {
+		case strings.HasPrefix(errorCode, "5.7."):
+			return VrfyUnsupported
+		case errorCode != "" && !strings.HasPrefix(errorCode, "5.1."):
+			return VrfyCannotVerify
+		}
+		desc := strings.ToLower(description)
+		for _, keyword := range vrfyDisabledKeywords {
+			if strings.Contains(desc, keyword) {
+				return VrfyUnsupported
+			}
+		}
+		return VrfyNotExists
+	}
+	return VrfyCannotVerify
+}
+
+// probeVrfy asks the server about the mailbox with VRFY, falling back to
+// EXPN when VRFY is unavailable and the server advertises EXPN. VRFY is
+// only sent to servers that advertise it, unless unadvertised is set,
+// since many accept it without listing it in EHLO. The result is nil when
+// neither command was sent.
+func probeVrfy(session *smtpSession, email string, capabilities smtpCapabilities, unadvertised bool) (*VrfyResult, error) {
+	var result *VrfyResult
+	if unadvertised || capabilities.has("VRFY") {
+		var err error
+		if result, err = sendVerifyCommand(session, "VRFY", email); err != nil {
+			return nil, err
+		}
+		if result.Status != VrfyUnsupported || session.dropped {
+			return result, nil
+		}
+	}
+	if !capabilities.has("EXPN") {
+		return result, nil
+	}
+
+	expn, err := sendVerifyCommand(session, "EXPN", email)
+	if err != nil && result == nil {
+		return nil, err
+	}
+	if err != nil {
+		return result, nil
+	}
+	return expn, nil
+}
+
+func sendVerifyCommand(session *smtpSession, command, email string) (*VrfyResult, error) {
+	cmd := fmt.Sprintf("%s %s", command, email)
+	if session.smtpUtf8 {
+		cmd += " SMTPUTF8"
+	}
+	resp, err := session.sendSMTPcommand(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("SMTP %s command failed: %w", command, err)
+	}
+
+	result := &VrfyResult{Command: command}
+	result.ResponseCode, result.ErrorCode, result.Description = ParseSmtpResponse(resp)
+	result.Status = classifyVrfyReply(result.ResponseCode, result.ErrorCode, result.Description)
+	return result, nil
+}
+
+// smtpCapabilities are the EHLO keywords a server advertised, with their
+// parameters
+type smtpCapabilities map[string]string
+
+func (c smtpCapabilities) has(keyword string) bool {
+	_, ok := c[keyword]
+	return ok
+}
+
+// parseCapabilities reads the keyword lines of a raw EHLO reply. The first
+// line is the server's greeting, not a capability.
+func parseCapabilities(raw string) smtpCapabilities {
+	capabilities := smtpCapabilities{}
+	lines := strings.Split(raw, "\n")
+	for _, line := range lines[1:] {
+		if len(line) < 4 {
+			continue
+		}
+		fields := strings.Fields(line[4:])
+		if len(fields) == 0 {
+			continue
+		}
+		capabilities[strings.ToUpper(fields[0])] = strings.Join(fields[1:], " ")
+	}
+	return capabilities
+}
+
+// sendEHLO greets with EHLO to learn the server's capabilities, falling
+// back to HELO for servers that reject it
+func sendEHLO(session *smtpSession, heloName string) (string, string, smtpCapabilities, error) {
+	resp, raw, err := session.exchange(fmt.Sprintf("EHLO %s", heloName), session.commandTimeout)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("SMTP EHLO command failed: %w", err)
+	}
+
+	statusCode, desc := parseSmtpCommand(resp)
+	if statusCode == "250" {
+		session.ehlo = raw
+		return statusCode, desc, parseCapabilities(raw), nil
+	}
+	if !strings.HasPrefix(statusCode, "5") || session.dropped {
+		return statusCode, desc, nil, nil
+	}
+
+	statusCode, desc, err = sendHELO(session, heloName)
+	return statusCode, desc, smtpCapabilities{}, err
+}
@@ -0,0 +1,170 @@
+package publicip
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rdegges/go-ipify"
+)
+
+const (
+	// Environment variable holding the public IP of the mail server
+	serverIPEnvVar = "MAIL_SERVER_IP"
+
+	defaultCacheTTL    = time.Hour
+	defaultNegativeTTL = time.Minute
+)
+
+// Resolver discovers the public IP address our SMTP traffic egresses from
+type Resolver interface {
+	PublicIP() (string, error)
+}
+
+// Static always resolves to the configured IP address
+type Static string
+
+func (s Static) PublicIP() (string, error) {
+	ip := strings.TrimSpace(string(s))
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("invalid static IP address %q", ip)
+	}
+	return ip, nil
+}
+
+// EnvResolver reads the IP address from an environment variable
+type EnvResolver struct {
+	Key string
+}
+
+func (e EnvResolver) PublicIP() (string, error) {
+	key := e.Key
+	if key == "" {
+		key = serverIPEnvVar
+	}
+	value, exists := os.LookupEnv(key)
+	if !exists || strings.TrimSpace(value) == "" {
+		return "", fmt.Errorf("%s environment variable not set", key)
+	}
+	return Static(value).PublicIP()
+}
+
+// IpifyResolver discovers the IP address using the ipify HTTP service
+type IpifyResolver struct{}
+
+func (IpifyResolver) PublicIP() (string, error) {
+	return ipify.GetIp()
+}
+
+// CachingResolver wraps a Resolver and caches its answer. Failures are
+// cached for a shorter period so an offline host isn't queried on every call.
+type CachingResolver struct {
+	Resolver    Resolver
+	TTL         time.Duration
+	NegativeTTL time.Duration
+
+	mu      sync.Mutex
+	ip      string
+	err     error
+	expires time.Time
+	now     func() time.Time
+}
+
+func NewCachingResolver(resolver Resolver, ttl time.Duration) *CachingResolver {
+	return &CachingResolver{
+		Resolver:    resolver,
+		TTL:         ttl,
+		NegativeTTL: defaultNegativeTTL,
+	}
+}
+
+func (c *CachingResolver) PublicIP() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock()
+	if now.Before(c.expires) {
+		return c.ip, c.err
+	}
+
+	c.ip, c.err = c.Resolver.PublicIP()
+	if c.err != nil {
+		c.expires = now.Add(c.NegativeTTL)
+	} else {
+		c.expires = now.Add(c.TTL)
+	}
+	return c.ip, c.err
+}
+
+// Invalidate drops the cached answer, e.g. after the egress IP changed
+func (c *CachingResolver) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expires = time.Time{}
+}
+
+func (c *CachingResolver) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+type chain []Resolver
+
+// Chain returns a Resolver that tries each resolver in order and returns
+// the first successful answer
+func Chain(resolvers ...Resolver) Resolver {
+	return chain(resolvers)
+}
+
+func (c chain) PublicIP() (string, error) {
+	var errs []string
+	for _, resolver := range c {
+		ip, err := resolver.PublicIP()
+		if err == nil {
+			return ip, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	if len(errs) == 0 {
+		return "", fmt.Errorf("no public IP resolvers configured")
+	}
+	return "", fmt.Errorf("unable to discover public IP: %s", strings.Join(errs, "; "))
+}
+
+var (
+	defaultResolver     Resolver
+	defaultResolverOnce sync.Once
+)
+
+// Default resolves from the MAIL_SERVER_IP environment variable and falls
+// back to a cached ipify lookup
+func Default() Resolver {
+	defaultResolverOnce.Do(func() {
+		defaultResolver = Chain(
+			EnvResolver{},
+			NewCachingResolver(IpifyResolver{}, defaultCacheTTL),
+		)
+	})
+	return defaultResolver
+}
+
+// Carrier-grade NAT range of RFC 6598. Hosts there share a public IP
+// with other customers of the carrier
+var sharedAddressSpace = &net.IPNet{
+	IP:   net.IPv4(100, 64, 0, 0),
+	Mask: net.CIDRMask(10, 32),
+}
+
+// IsPublic reports whether ip is a globally routable unicast address
+func IsPublic(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	return parsed.IsGlobalUnicast() && !parsed.IsPrivate() && !sharedAddressSpace.Contains(parsed)
+}
@@ -0,0 +1,121 @@
+package publicip
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingResolver struct {
+	ip    string
+	err   error
+	calls int
+}
+
+func (c *countingResolver) PublicIP() (string, error) {
+	c.calls++
+	return c.ip, c.err
+}
+
+func TestStatic(t *testing.T) {
+	ip, err := Static(" 203.0.113.7 ").PublicIP()
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.7", ip)
+
+	_, err = Static("not-an-ip").PublicIP()
+	assert.Error(t, err)
+}
+
+func TestEnvResolver(t *testing.T) {
+	t.Setenv("TEST_MAIL_SERVER_IP", "198.51.100.4")
+	ip, err := EnvResolver{Key: "TEST_MAIL_SERVER_IP"}.PublicIP()
+	assert.NoError(t, err)
+	assert.Equal(t, "198.51.100.4", ip)
+
+	_, err = EnvResolver{Key: "TEST_MAIL_SERVER_IP_UNSET"}.PublicIP()
+	assert.Error(t, err)
+}
+
+func TestChain(t *testing.T) {
+	failing := &countingResolver{err: errors.New("offline")}
+	working := &countingResolver{ip: "203.0.113.9"}
+
+	ip, err := Chain(failing, working).PublicIP()
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.9", ip)
+	assert.Equal(t, 1, failing.calls)
+
+	_, err = Chain(failing).PublicIP()
+	assert.Error(t, err)
+
+	_, err = Chain().PublicIP()
+	assert.Error(t, err)
+}
+
+func TestCachingResolver(t *testing.T) {
+	backend := &countingResolver{ip: "203.0.113.10"}
+	now := time.Unix(1700000000, 0)
+
+	cache := NewCachingResolver(backend, time.Hour)
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		ip, err := cache.PublicIP()
+		assert.NoError(t, err)
+		assert.Equal(t, "203.0.113.10", ip)
+	}
+	assert.Equal(t, 1, backend.calls, "answer should be cached")
+
+	now = now.Add(2 * time.Hour)
+	_, _ = cache.PublicIP()
+	assert.Equal(t, 2, backend.calls, "answer should expire after TTL")
+
+	cache.Invalidate()
+	_, _ = cache.PublicIP()
+	assert.Equal(t, 3, backend.calls, "invalidate should force a lookup")
+}
+
+func TestCachingResolverCachesFailures(t *testing.T) {
+	backend := &countingResolver{err: errors.New("offline")}
+	now := time.Unix(1700000000, 0)
+
+	cache := NewCachingResolver(backend, time.Hour)
+	cache.now = func() time.Time { return now }
+
+	_, err := cache.PublicIP()
+	assert.Error(t, err)
+	_, err = cache.PublicIP()
+	assert.Error(t, err)
+	assert.Equal(t, 1, backend.calls, "failures should be cached")
+
+	now = now.Add(2 * time.Minute)
+	_, _ = cache.PublicIP()
+	assert.Equal(t, 2, backend.calls, "failures should expire after the negative TTL")
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"10.0.0.5", false},
+		{"192.168.1.20", false},
+		{"127.0.0.1", false},
+		{"fd00::1", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"100.128.0.1", true},
+		{"", false},
+		{"garbage", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsPublic(tt.ip))
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package registry
+
+// substringMatcher is an Aho-Corasick automaton: it finds whether a string
+// contains any of the patterns in one pass, instead of one strings.Contains
+// per pattern
+type substringMatcher struct {
+	nodes []matcherNode
+}
+
+type matcherNode struct {
+	next map[byte]int
+	// Longest proper suffix of this node's path that is also in the trie
+	fail int
+	// A pattern ends here, or at a node reachable through fail
+	terminal bool
+}
+
+func newSubstringMatcher(patterns []string) *substringMatcher {
+	m := &substringMatcher{nodes: []matcherNode{{next: map[byte]int{}}}}
+
+	// Build the trie of patterns
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		node := 0
+		for i := 0; i < len(pattern); i++ {
+			child, ok := m.nodes[node].next[pattern[i]]
+			if !ok {
+				child = len(m.nodes)
+				m.nodes = append(m.nodes, matcherNode{next: map[byte]int{}})
+				m.nodes[node].next[pattern[i]] = child
+			}
+			node = child
+		}
+		m.nodes[node].terminal = true
+	}
+
+	// Link each node to its fallback, breadth first so shallower links
+	// are ready when deeper nodes need them
+	var queue []int
+	for _, child := range m.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for c, child := range m.nodes[node].next {
+			m.nodes[child].fail = m.step(m.nodes[node].fail, c)
+			m.nodes[child].terminal = m.nodes[child].terminal || m.nodes[m.nodes[child].fail].terminal
+			queue = append(queue, child)
+		}
+	}
+	return m
+}
+
+// matchAny reports whether s contains any of the patterns
+func (m *substringMatcher) matchAny(s string) bool {
+	node := 0
+	for i := 0; i < len(s); i++ {
+		node = m.step(node, s[i])
+		if m.nodes[node].terminal {
+			return true
+		}
+	}
+	return false
+}
+
+// step follows c from node, falling back along fail links until a node
+// has a transition for it or the root is reached
+func (m *substringMatcher) step(node int, c byte) int {
+	for {
+		if child, ok := m.nodes[node].next[c]; ok {
+			return child
+		}
+		if node == 0 {
+			return 0
+		}
+		node = m.nodes[node].fail
+	}
+}
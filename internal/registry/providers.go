@@ -0,0 +1,57 @@
+package registry
+
+type ProviderCategory struct {
+	Type    string     `toml:"type"`
+	Domains [][]string `toml:"domains"`
+}
+
+type KnownProviders struct {
+	Enterprise ProviderCategory `toml:"enterprise"`
+	Hosting    ProviderCategory `toml:"hosting"`
+	Webmail    ProviderCategory `toml:"webmail"`
+	Security   ProviderCategory `toml:"security"`
+
+	// Provider name and category by domain, built by the registry
+	byDomain map[string][2]string
+}
+
+// GetProviderByDomain returns the provider name and category of domain,
+// or empty strings when it isn't known
+func (kp *KnownProviders) GetProviderByDomain(domain string) (string, string) {
+	if kp.byDomain != nil {
+		entry := kp.byDomain[domain]
+		return entry[0], entry[1]
+	}
+
+	// Not loaded by the registry, so there's no index
+	for _, category := range kp.categories() {
+		for _, provider := range category.Domains {
+			if provider[0] == domain {
+				return provider[1], category.Type
+			}
+		}
+	}
+	return "", ""
+}
+
+// buildIndex indexes the providers by domain. A domain listed in several
+// categories keeps the first, as in the unindexed lookup.
+func (kp *KnownProviders) buildIndex() {
+	kp.byDomain = make(map[string][2]string)
+	for _, category := range kp.categories() {
+		for _, provider := range category.Domains {
+			if _, exists := kp.byDomain[provider[0]]; !exists {
+				kp.byDomain[provider[0]] = [2]string{provider[1], category.Type}
+			}
+		}
+	}
+}
+
+func (kp *KnownProviders) categories() []ProviderCategory {
+	return []ProviderCategory{
+		kp.Enterprise,
+		kp.Hosting,
+		kp.Webmail,
+		kp.Security,
+	}
+}
@@ -0,0 +1,184 @@
+package registry
+
+import (
+	"embed"
+	"fmt"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+//go:embed free_emails.toml role_emails.toml known_email_providers.toml disposable_emails.toml
+var listFiles embed.FS
+
+// Registry holds the embedded lists, decoded and indexed on first use.
+// It is safe for concurrent use.
+type Registry struct {
+	freeEmails struct {
+		once    sync.Once
+		domains []string
+		set     map[string]bool
+		err     error
+	}
+	roleAccounts struct {
+		once     sync.Once
+		matches  map[string]bool
+		contains *substringMatcher
+		err      error
+	}
+	providers struct {
+		once  sync.Once
+		known *KnownProviders
+		err   error
+	}
+	disposable struct {
+		once    sync.Once
+		domains map[string]bool
+		mx      map[string]bool
+		err     error
+	}
+}
+
+var defaultRegistry = New()
+
+// New returns a registry that loads each list the first time it's needed
+func New() *Registry {
+	return &Registry{}
+}
+
+// Default is the registry shared by the package-level checks
+func Default() *Registry {
+	return defaultRegistry
+}
+
+// IsFreeEmail reports whether domain is a free email provider
+func (r *Registry) IsFreeEmail(domain string) (bool, error) {
+	if err := r.loadFreeEmails(); err != nil {
+		return false, err
+	}
+	return r.freeEmails.set[domain], nil
+}
+
+// FreeEmailDomains returns every free email provider domain. The slice is
+// shared and must not be modified.
+func (r *Registry) FreeEmailDomains() ([]string, error) {
+	if err := r.loadFreeEmails(); err != nil {
+		return nil, err
+	}
+	return r.freeEmails.domains, nil
+}
+
+// IsRoleAccount reports whether username is, or contains, a role name
+// such as info or support
+func (r *Registry) IsRoleAccount(username string) (bool, error) {
+	if err := r.loadRoleAccounts(); err != nil {
+		return false, err
+	}
+	if r.roleAccounts.matches[username] {
+		return true, nil
+	}
+	return r.roleAccounts.contains.matchAny(username), nil
+}
+
+// KnownProviders returns the known email providers, indexed by domain.
+// The result is shared and must not be modified.
+func (r *Registry) KnownProviders() (*KnownProviders, error) {
+	r.providers.once.Do(func() {
+		var providers KnownProviders
+		if err := decodeList("known_email_providers.toml", &providers); err != nil {
+			r.providers.err = err
+			return
+		}
+		providers.Enterprise.Type = "enterprise"
+		providers.Hosting.Type = "hosting"
+		providers.Webmail.Type = "webmail"
+		providers.Security.Type = "security"
+		providers.buildIndex()
+		r.providers.known = &providers
+	})
+	return r.providers.known, r.providers.err
+}
+
+// IsDisposableDomain reports whether domain is listed as a throwaway
+// mailbox service
+func (r *Registry) IsDisposableDomain(domain string) (bool, error) {
+	if err := r.loadDisposable(); err != nil {
+		return false, err
+	}
+	return r.disposable.domains[domain], nil
+}
+
+// IsDisposableMX reports whether domain is listed as the MX domain of a
+// throwaway mailbox service
+func (r *Registry) IsDisposableMX(domain string) (bool, error) {
+	if err := r.loadDisposable(); err != nil {
+		return false, err
+	}
+	return r.disposable.mx[domain], nil
+}
+
+func (r *Registry) loadFreeEmails() error {
+	r.freeEmails.once.Do(func() {
+		var list struct {
+			FreeEmailList []string `toml:"free_emails"`
+		}
+		if err := decodeList("free_emails.toml", &list); err != nil {
+			r.freeEmails.err = err
+			return
+		}
+		r.freeEmails.domains = list.FreeEmailList
+		r.freeEmails.set = toSet(list.FreeEmailList)
+	})
+	return r.freeEmails.err
+}
+
+func (r *Registry) loadRoleAccounts() error {
+	r.roleAccounts.once.Do(func() {
+		var list struct {
+			Contains []string `toml:"contains"`
+			Matches  []string `toml:"matches"`
+		}
+		if err := decodeList("role_emails.toml", &list); err != nil {
+			r.roleAccounts.err = err
+			return
+		}
+		r.roleAccounts.matches = toSet(list.Matches)
+		r.roleAccounts.contains = newSubstringMatcher(list.Contains)
+	})
+	return r.roleAccounts.err
+}
+
+func (r *Registry) loadDisposable() error {
+	r.disposable.once.Do(func() {
+		var list struct {
+			Domains []string `toml:"disposable_emails"`
+			MX      []string `toml:"disposable_mx"`
+		}
+		if err := decodeList("disposable_emails.toml", &list); err != nil {
+			r.disposable.err = err
+			return
+		}
+		r.disposable.domains = toSet(list.Domains)
+		r.disposable.mx = toSet(list.MX)
+	})
+	return r.disposable.err
+}
+
+func decodeList(name string, v interface{}) error {
+	fileData, err := listFiles.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if _, err := toml.Decode(string(fileData), v); err != nil {
+		return fmt.Errorf("failed to decode TOML: %w", err)
+	}
+	return nil
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
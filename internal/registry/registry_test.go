@@ -0,0 +1,195 @@
+package registry
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsFreeEmail(t *testing.T) {
+	r := New()
+	for domain, expected := range map[string]bool{
+		"gmail.com":     true,
+		"yahoo.com":     true,
+		"microsoft.com": false,
+		"":              false,
+	} {
+		free, err := r.IsFreeEmail(domain)
+		require.NoError(t, err)
+		assert.Equal(t, expected, free, domain)
+	}
+
+	domains, err := r.FreeEmailDomains()
+	require.NoError(t, err)
+	assert.Greater(t, len(domains), 4000)
+}
+
+func TestIsRoleAccount(t *testing.T) {
+	r := New()
+	for username, expected := range map[string]bool{
+		"info":          true,
+		"support":       true,
+		"abuse-reports": true,
+		"team-leave":    true,
+		"john.smith":    false,
+		"":              false,
+	} {
+		role, err := r.IsRoleAccount(username)
+		require.NoError(t, err)
+		assert.Equal(t, expected, role, username)
+	}
+}
+
+func TestKnownProvidersIndex(t *testing.T) {
+	providers, err := New().KnownProviders()
+	require.NoError(t, err)
+
+	// The index agrees with a scan of the lists, outlook.com being listed
+	// as both enterprise and webmail
+	unindexed := *providers
+	unindexed.byDomain = nil
+	for _, category := range providers.categories() {
+		for _, provider := range category.Domains {
+			name, categoryType := providers.GetProviderByDomain(provider[0])
+			expectedName, expectedType := unindexed.GetProviderByDomain(provider[0])
+			assert.Equal(t, expectedName, name, provider[0])
+			assert.Equal(t, expectedType, categoryType, provider[0])
+		}
+	}
+
+	name, category := providers.GetProviderByDomain("outlook.com")
+	assert.Equal(t, "outlook", name)
+	assert.Equal(t, "enterprise", category)
+
+	name, category = providers.GetProviderByDomain("example.com")
+	assert.Empty(t, name)
+	assert.Empty(t, category)
+}
+
+func TestIsDisposable(t *testing.T) {
+	r := New()
+	listed, err := r.IsDisposableDomain("mailinator.com")
+	require.NoError(t, err)
+	assert.True(t, listed)
+
+	listed, err = r.IsDisposableMX("mail.tm")
+	require.NoError(t, err)
+	assert.True(t, listed)
+
+	listed, err = r.IsDisposableDomain("gmail.com")
+	require.NoError(t, err)
+	assert.False(t, listed)
+}
+
+func TestConcurrentLoad(t *testing.T) {
+	r := New()
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			free, err := r.IsFreeEmail("gmail.com")
+			assert.NoError(t, err)
+			assert.True(t, free)
+			role, err := r.IsRoleAccount("sales")
+			assert.NoError(t, err)
+			assert.True(t, role)
+			_, err = r.KnownProviders()
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSubstringMatcher(t *testing.T) {
+	patterns := []string{"he", "she", "his", "hers", "abuse", "-leave"}
+	m := newSubstringMatcher(patterns)
+
+	for _, s := range []string{"", "h", "ushers", "ahishers", "xyz", "abus", "reportabuse", "on-leave", "leave", "sh", "hx", "abuabuse"} {
+		expected := false
+		for _, pattern := range patterns {
+			expected = expected || strings.Contains(s, pattern)
+		}
+		assert.Equal(t, expected, m.matchAny(s), s)
+	}
+
+	assert.False(t, newSubstringMatcher(nil).matchAny("anything"))
+}
+
+// The benchmarks below compare the registry with decoding the list on
+// every call, as the checks used to
+
+func BenchmarkIsFreeEmail(b *testing.B) {
+	r := New()
+	r.IsFreeEmail("gmail.com")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.IsFreeEmail("zoho.com")
+	}
+}
+
+func BenchmarkIsFreeEmailDecodeEachCall(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		var list struct {
+			FreeEmailList []string `toml:"free_emails"`
+		}
+		fileData, _ := listFiles.ReadFile("free_emails.toml")
+		toml.Decode(string(fileData), &list)
+		for _, domain := range list.FreeEmailList {
+			if domain == "zoho.com" {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkIsRoleAccount(b *testing.B) {
+	r := New()
+	r.IsRoleAccount("info")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.IsRoleAccount("john.smith")
+	}
+}
+
+func BenchmarkIsRoleAccountDecodeEachCall(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		var list struct {
+			Contains []string `toml:"contains"`
+			Matches  []string `toml:"matches"`
+		}
+		fileData, _ := listFiles.ReadFile("role_emails.toml")
+		toml.Decode(string(fileData), &list)
+		for _, value := range list.Matches {
+			if value == "john.smith" {
+				break
+			}
+		}
+		for _, value := range list.Contains {
+			if strings.Contains("john.smith", value) {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkGetProviderByDomain(b *testing.B) {
+	providers, _ := New().KnownProviders()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		providers.GetProviderByDomain("zixmail.net")
+	}
+}
+
+func BenchmarkGetProviderByDomainDecodeEachCall(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		var providers KnownProviders
+		fileData, _ := listFiles.ReadFile("known_email_providers.toml")
+		toml.Unmarshal(fileData, &providers)
+		providers.GetProviderByDomain("zixmail.net")
+	}
+}
@@ -0,0 +1,346 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var ErrThrottled = errors.New("rate limit wait exceeds MaxWait or the deadline")
+
+// Limit is a token bucket: Rate tokens per second, up to Burst at once.
+// A zero Rate means unlimited.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+type Config struct {
+	PerHost     Limit
+	PerProvider Limit
+	PerSender   Limit
+	// Overrides keyed by MX host or provider name
+	HostLimits     map[string]Limit
+	ProviderLimits map[string]Limit
+
+	// Maximum simultaneous connections to a single MX host. Zero is unlimited
+	MaxConnsPerHost int
+
+	// Backoff applied to a host after a throttling reply, doubled on each
+	// consecutive throttle up to BackoffMax
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+
+	// Longest Acquire will wait before giving up with ErrThrottled. Zero
+	// waits forever, or until the context is done
+	MaxWait time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		PerHost:         Limit{Rate: 2, Burst: 5},
+		PerProvider:     Limit{Rate: 10, Burst: 20},
+		PerSender:       Limit{Rate: 5, Burst: 10},
+		MaxConnsPerHost: 3,
+		BackoffBase:     30 * time.Second,
+		BackoffMax:      30 * time.Minute,
+		MaxWait:         30 * time.Second,
+	}
+}
+
+// Keys identifies what a probe counts against
+type Keys struct {
+	MXHost   string
+	Provider string
+	Sender   string
+}
+
+// HostState is a snapshot of the scheduler's view of an MX host
+type HostState struct {
+	Host         string
+	InFlight     int
+	Backoff      time.Duration
+	BlockedUntil time.Time
+}
+
+// Scheduler enforces politeness limits in front of SMTP probes
+type Scheduler struct {
+	config Config
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	conns     map[string]chan struct{}
+	backoff   map[string]*hostBackoff
+	now       func() time.Time
+	sleepFunc func(ctx context.Context, d time.Duration) error
+}
+
+type hostBackoff struct {
+	delay time.Duration
+	until time.Time
+}
+
+// Ticket is held for the duration of a probe and must be released with
+// the reply that ended it
+type Ticket struct {
+	scheduler *Scheduler
+	host      string
+	conn      chan struct{}
+	once      sync.Once
+}
+
+func New(config Config) *Scheduler {
+	return &Scheduler{
+		config:  config,
+		buckets: make(map[string]*bucket),
+		conns:   make(map[string]chan struct{}),
+		backoff: make(map[string]*hostBackoff),
+	}
+}
+
+// Acquire blocks until a probe against keys is allowed to start. A wait
+// that would outlast MaxWait or the context's deadline fails right away.
+// Tokens reserved for a probe that never starts are given back.
+func (s *Scheduler) Acquire(ctx context.Context, keys Keys) (*Ticket, error) {
+	s.mu.Lock()
+	now := s.clock()
+
+	var wait time.Duration
+	if b, ok := s.backoff[keys.MXHost]; ok && b.until.After(now) {
+		wait = b.until.Sub(now)
+	}
+
+	var reserved []*bucket
+	for _, b := range s.bucketsFor(keys) {
+		if w := b.reserve(now); w > wait {
+			wait = w
+		}
+		reserved = append(reserved, b)
+	}
+
+	tooLong := s.config.MaxWait > 0 && wait > s.config.MaxWait
+	if deadline, ok := ctx.Deadline(); ok && now.Add(wait).After(deadline) {
+		tooLong = true
+	}
+	if tooLong {
+		for _, b := range reserved {
+			b.cancel()
+		}
+		s.mu.Unlock()
+		return nil, errors.Wrapf(ErrThrottled, "%s needs to wait %s", keys.MXHost, wait.Round(time.Second))
+	}
+
+	conn := s.connSlots(keys.MXHost)
+	s.mu.Unlock()
+
+	if wait > 0 {
+		if err := s.sleep(ctx, wait); err != nil {
+			s.refund(reserved)
+			return nil, err
+		}
+	}
+
+	if conn != nil {
+		select {
+		case conn <- struct{}{}:
+		case <-ctx.Done():
+			s.refund(reserved)
+			return nil, ctx.Err()
+		}
+	}
+
+	return &Ticket{scheduler: s, host: keys.MXHost, conn: conn}, nil
+}
+
+// refund gives back the tokens of a probe that was abandoned while waiting
+func (s *Scheduler) refund(reserved []*bucket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range reserved {
+		b.cancel()
+	}
+}
+
+// Release frees the connection slot and feeds the reply into the host's
+// adaptive backoff
+func (t *Ticket) Release(responseCode, errorCode string) {
+	t.once.Do(func() {
+		if t.conn != nil {
+			<-t.conn
+		}
+		t.scheduler.observe(t.host, responseCode, errorCode)
+	})
+}
+
+// Hosts returns the current state of every MX host seen so far
+func (s *Scheduler) Hosts() []HostState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[string]*HostState)
+	for host, conn := range s.conns {
+		seen[host] = &HostState{Host: host, InFlight: len(conn)}
+	}
+	for host, b := range s.backoff {
+		state, ok := seen[host]
+		if !ok {
+			state = &HostState{Host: host}
+			seen[host] = state
+		}
+		state.Backoff = b.delay
+		state.BlockedUntil = b.until
+	}
+
+	hosts := make([]HostState, 0, len(seen))
+	for _, state := range seen {
+		hosts = append(hosts, *state)
+	}
+	return hosts
+}
+
+// IsThrottlingReply reports whether the reply asks us to slow down
+func IsThrottlingReply(responseCode, errorCode string) bool {
+	return responseCode == "421" || strings.HasPrefix(errorCode, "4.7.")
+}
+
+func (s *Scheduler) observe(host, responseCode, errorCode string) {
+	if host == "" || responseCode == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.backoff[host]
+	if !ok {
+		b = &hostBackoff{}
+		s.backoff[host] = b
+	}
+
+	if IsThrottlingReply(responseCode, errorCode) {
+		b.delay *= 2
+		if b.delay < s.config.BackoffBase {
+			b.delay = s.config.BackoffBase
+		}
+		if s.config.BackoffMax > 0 && b.delay > s.config.BackoffMax {
+			b.delay = s.config.BackoffMax
+		}
+		b.until = s.clock().Add(b.delay)
+		return
+	}
+
+	// Ease off the backoff once the host answers normally again
+	b.delay /= 2
+	if b.delay < s.config.BackoffBase {
+		b.delay = 0
+	}
+}
+
+func (s *Scheduler) bucketsFor(keys Keys) []*bucket {
+	var buckets []*bucket
+
+	add := func(key string, limit Limit) {
+		if limit.Rate <= 0 {
+			return
+		}
+		b, ok := s.buckets[key]
+		if !ok {
+			b = newBucket(limit, s.clock())
+			s.buckets[key] = b
+		}
+		buckets = append(buckets, b)
+	}
+
+	if keys.MXHost != "" {
+		limit := s.config.PerHost
+		if override, ok := s.config.HostLimits[keys.MXHost]; ok {
+			limit = override
+		}
+		add(fmt.Sprintf("host:%s", keys.MXHost), limit)
+	}
+	if keys.Provider != "" {
+		limit := s.config.PerProvider
+		if override, ok := s.config.ProviderLimits[keys.Provider]; ok {
+			limit = override
+		}
+		add(fmt.Sprintf("provider:%s", keys.Provider), limit)
+	}
+	if keys.Sender != "" {
+		add(fmt.Sprintf("sender:%s", keys.Sender), s.config.PerSender)
+	}
+
+	return buckets
+}
+
+func (s *Scheduler) connSlots(host string) chan struct{} {
+	if s.config.MaxConnsPerHost <= 0 || host == "" {
+		return nil
+	}
+	conn, ok := s.conns[host]
+	if !ok {
+		conn = make(chan struct{}, s.config.MaxConnsPerHost)
+		s.conns[host] = conn
+	}
+	return conn
+}
+
+func (s *Scheduler) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func (s *Scheduler) sleep(ctx context.Context, d time.Duration) error {
+	if s.sleepFunc != nil {
+		return s.sleepFunc(ctx, d)
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(limit Limit, now time.Time) *bucket {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &bucket{rate: limit.Rate, burst: burst, tokens: burst, last: now}
+}
+
+// reserve takes a token and returns how long until it is actually available
+func (b *bucket) reserve(now time.Time) time.Duration {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *bucket) cancel() {
+	b.tokens++
+}
@@ -0,0 +1,179 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock advances instead of sleeping so tests run instantly
+type fakeClock struct {
+	now   time.Time
+	slept []time.Duration
+}
+
+func (c *fakeClock) install(s *Scheduler) {
+	s.now = func() time.Time { return c.now }
+	s.sleepFunc = func(ctx context.Context, d time.Duration) error {
+		c.slept = append(c.slept, d)
+		c.now = c.now.Add(d)
+		return nil
+	}
+}
+
+func newTestScheduler(config Config) (*Scheduler, *fakeClock) {
+	s := New(config)
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	clock.install(s)
+	return s, clock
+}
+
+func TestTokenBucketPerHost(t *testing.T) {
+	s, clock := newTestScheduler(Config{PerHost: Limit{Rate: 1, Burst: 2}})
+	keys := Keys{MXHost: "gmail-smtp-in.l.google.com"}
+
+	for i := 0; i < 3; i++ {
+		ticket, err := s.Acquire(context.Background(), keys)
+		require.NoError(t, err)
+		ticket.Release("250", "2.1.5")
+	}
+
+	require.Len(t, clock.slept, 1, "third probe should exceed the burst")
+	assert.Equal(t, time.Second, clock.slept[0])
+}
+
+func TestLimitsAreIndependentPerHost(t *testing.T) {
+	s, clock := newTestScheduler(Config{PerHost: Limit{Rate: 1, Burst: 1}})
+
+	for _, host := range []string{"mx1.acme.com", "mx1.globex.com", "mx1.initech.com"} {
+		ticket, err := s.Acquire(context.Background(), Keys{MXHost: host})
+		require.NoError(t, err)
+		ticket.Release("250", "")
+	}
+	assert.Empty(t, clock.slept)
+}
+
+func TestProviderLimitOverride(t *testing.T) {
+	s, clock := newTestScheduler(Config{
+		PerProvider:    Limit{Rate: 100, Burst: 100},
+		ProviderLimits: map[string]Limit{"google workspace": {Rate: 0.5, Burst: 1}},
+	})
+
+	for _, host := range []string{"aspmx.l.google.com", "alt1.aspmx.l.google.com"} {
+		ticket, err := s.Acquire(context.Background(), Keys{MXHost: host, Provider: "google workspace"})
+		require.NoError(t, err)
+		ticket.Release("250", "")
+	}
+
+	require.Len(t, clock.slept, 1, "provider limit should apply across hosts")
+	assert.Equal(t, 2*time.Second, clock.slept[0])
+}
+
+func TestMaxWait(t *testing.T) {
+	s, _ := newTestScheduler(Config{PerSender: Limit{Rate: 0.1, Burst: 1}, MaxWait: time.Second})
+	keys := Keys{Sender: "probe-1"}
+
+	ticket, err := s.Acquire(context.Background(), keys)
+	require.NoError(t, err)
+	ticket.Release("250", "")
+
+	_, err = s.Acquire(context.Background(), keys)
+	assert.ErrorIs(t, err, ErrThrottled)
+
+	// The rejected probe must not have consumed a token
+	s.now = func() time.Time { return time.Unix(1700000010, 0) }
+	_, err = s.Acquire(context.Background(), keys)
+	assert.NoError(t, err)
+}
+
+func TestMaxConnsPerHost(t *testing.T) {
+	s, _ := newTestScheduler(Config{MaxConnsPerHost: 1})
+	keys := Keys{MXHost: "mx1.acme.com"}
+
+	first, err := s.Acquire(context.Background(), keys)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = s.Acquire(ctx, keys)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "second connection should block")
+
+	first.Release("250", "")
+	second, err := s.Acquire(context.Background(), keys)
+	require.NoError(t, err)
+	second.Release("250", "")
+}
+
+func TestAdaptiveBackoff(t *testing.T) {
+	s, clock := newTestScheduler(Config{BackoffBase: 30 * time.Second, BackoffMax: time.Minute})
+	keys := Keys{MXHost: "mx1.acme.com"}
+
+	ticket, _ := s.Acquire(context.Background(), keys)
+	ticket.Release("421", "4.7.0")
+
+	ticket, _ = s.Acquire(context.Background(), keys)
+	require.Len(t, clock.slept, 1)
+	assert.Equal(t, 30*time.Second, clock.slept[0])
+	ticket.Release("450", "4.7.1")
+
+	ticket, _ = s.Acquire(context.Background(), keys)
+	require.Len(t, clock.slept, 2)
+	assert.Equal(t, time.Minute, clock.slept[1], "backoff should double")
+	ticket.Release("421", "")
+
+	ticket, _ = s.Acquire(context.Background(), keys)
+	assert.Equal(t, time.Minute, clock.slept[2], "backoff should be capped")
+	ticket.Release("250", "2.1.5")
+
+	hosts := s.Hosts()
+	require.Len(t, hosts, 1)
+	assert.Equal(t, 30*time.Second, hosts[0].Backoff, "backoff should ease after a normal reply")
+}
+
+func TestIsThrottlingReply(t *testing.T) {
+	assert.True(t, IsThrottlingReply("421", ""))
+	assert.True(t, IsThrottlingReply("450", "4.7.1"))
+	assert.False(t, IsThrottlingReply("451", "4.3.0"))
+	assert.False(t, IsThrottlingReply("550", "5.7.1"))
+}
+
+func TestCancelledWaitRefundsTokens(t *testing.T) {
+	s, clock := newTestScheduler(Config{PerSender: Limit{Rate: 1, Burst: 1}})
+	keys := Keys{Sender: "probe-1"}
+
+	ticket, err := s.Acquire(context.Background(), keys)
+	require.NoError(t, err)
+	ticket.Release("250", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.sleepFunc = func(ctx context.Context, d time.Duration) error { return ctx.Err() }
+	_, err = s.Acquire(ctx, keys)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Only the first probe's token is spent, so a second later one is free
+	clock.install(s)
+	clock.now = clock.now.Add(time.Second)
+	_, err = s.Acquire(context.Background(), keys)
+	require.NoError(t, err)
+	assert.Empty(t, clock.slept)
+}
+
+func TestWaitBeyondDeadline(t *testing.T) {
+	s, clock := newTestScheduler(Config{PerSender: Limit{Rate: 0.1, Burst: 1}})
+	clock.now = time.Now()
+	keys := Keys{Sender: "probe-1"}
+
+	ticket, err := s.Acquire(context.Background(), keys)
+	require.NoError(t, err)
+	ticket.Release("250", "")
+
+	ctx, cancel := context.WithDeadline(context.Background(), clock.now.Add(time.Second))
+	defer cancel()
+	_, err = s.Acquire(ctx, keys)
+	assert.ErrorIs(t, err, ErrThrottled)
+	assert.Empty(t, clock.slept, "a wait past the deadline should fail without sleeping")
+}
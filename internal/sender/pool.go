@@ -0,0 +1,294 @@
+package sender
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+
+	"github.com/customeros/mailsherpa/internal/util"
+)
+
+var (
+	ErrNoHealthyIdentity = errors.New("no healthy sender identity available")
+	ErrEmptyPool         = errors.New("sender pool has no identities")
+)
+
+// Most MX hosts that keep their identity when MaxStickyHosts is zero
+const defaultMaxStickyHosts = 10000
+
+// Identity is a sender we probe from: the local IP to bind to, the name we
+// announce in HELO and the domain used for MAIL FROM
+type Identity struct {
+	Name       string `toml:"name"`
+	BindIP     string `toml:"bind_ip"`
+	HeloName   string `toml:"helo_name"`
+	FromDomain string `toml:"from_domain"`
+}
+
+func (i Identity) IsZero() bool {
+	return i == Identity{}
+}
+
+// String returns the configured name, or a name derived from the identity
+func (i Identity) String() string {
+	if i.Name != "" {
+		return i.Name
+	}
+	if i.BindIP == "" {
+		return i.FromDomain
+	}
+	return fmt.Sprintf("%s/%s", i.BindIP, i.FromDomain)
+}
+
+// Helo returns the name to announce in HELO, defaulting to the from domain
+func (i Identity) Helo() string {
+	if i.HeloName != "" {
+		return i.HeloName
+	}
+	return i.FromDomain
+}
+
+// GenerateFromEmail invents a plausible first.last sender at the identity's domain
+func (i Identity) GenerateFromEmail() string {
+	firstName, lastName := util.GenerateNames()
+	return fmt.Sprintf("%s.%s@%s", firstName, lastName, i.FromDomain)
+}
+
+// IdentityStatus is a snapshot of an identity's health in the pool
+type IdentityStatus struct {
+	Identity     Identity
+	Healthy      bool
+	Blacklisted  int
+	RetiredUntil time.Time
+	LastUsed     time.Time
+}
+
+type member struct {
+	identity     Identity
+	retired      bool
+	retiredUntil time.Time
+	blacklisted  int
+	lastUsed     time.Time
+}
+
+// Pool hands out sender identities per MX host. An MX host keeps getting
+// the same identity while it stays healthy, new hosts are assigned round
+// robin, and blacklisted identities are retired.
+type Pool struct {
+	// How long a blacklisted identity is retired for. Zero retires it
+	// until Restore is called.
+	RetireFor time.Duration
+	// How many MX hosts keep their identity. The least recently picked
+	// host is forgotten past it. Zero means 10000
+	MaxStickyHosts int
+
+	mu      sync.Mutex
+	members []*member
+	// MX hosts and their identity, most recently picked first
+	sticky      map[string]*list.Element
+	stickyOrder *list.List
+	next        int
+	now         func() time.Time
+}
+
+type stickyHost struct {
+	host string
+	idx  int
+}
+
+type poolFile struct {
+	RetireFor      string     `toml:"retire_for"`
+	MaxStickyHosts int        `toml:"max_sticky_hosts"`
+	Senders        []Identity `toml:"sender"`
+}
+
+func NewPool(identities []Identity) *Pool {
+	pool := &Pool{}
+	for _, identity := range identities {
+		pool.members = append(pool.members, &member{identity: identity})
+	}
+	return pool
+}
+
+// LoadPool reads identities from a TOML file of [[sender]] tables
+func LoadPool(path string) (*Pool, error) {
+	fileData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config poolFile
+	if err := toml.Unmarshal(fileData, &config); err != nil {
+		return nil, fmt.Errorf("error decoding TOML: %w", err)
+	}
+	if len(config.Senders) == 0 {
+		return nil, fmt.Errorf("no senders configured in %s", path)
+	}
+	for _, identity := range config.Senders {
+		if identity.FromDomain == "" {
+			return nil, fmt.Errorf("sender %s has no from_domain", identity)
+		}
+	}
+
+	pool := NewPool(config.Senders)
+	pool.MaxStickyHosts = config.MaxStickyHosts
+	if config.RetireFor != "" {
+		pool.RetireFor, err = time.ParseDuration(config.RetireFor)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid retire_for")
+		}
+	}
+	return pool, nil
+}
+
+// Pick returns the identity to use against mxHost
+func (p *Pool) Pick(mxHost string) (Identity, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.members) == 0 {
+		return Identity{}, ErrEmptyPool
+	}
+	now := p.clock()
+
+	if element, ok := p.sticky[mxHost]; ok {
+		idx := element.Value.(stickyHost).idx
+		if p.isHealthy(p.members[idx], now) {
+			p.stickyOrder.MoveToFront(element)
+			p.members[idx].lastUsed = now
+			return p.members[idx].identity, nil
+		}
+	}
+
+	for i := 0; i < len(p.members); i++ {
+		idx := (p.next + i) % len(p.members)
+		if !p.isHealthy(p.members[idx], now) {
+			continue
+		}
+		p.next = idx + 1
+		p.stick(mxHost, idx)
+		p.members[idx].lastUsed = now
+		return p.members[idx].identity, nil
+	}
+
+	return Identity{}, ErrNoHealthyIdentity
+}
+
+// stick assigns idx to mxHost, forgetting the least recently picked host
+// once MaxStickyHosts is reached
+func (p *Pool) stick(mxHost string, idx int) {
+	if p.sticky == nil {
+		p.sticky = make(map[string]*list.Element)
+		p.stickyOrder = list.New()
+	}
+	if element, ok := p.sticky[mxHost]; ok {
+		element.Value = stickyHost{host: mxHost, idx: idx}
+		p.stickyOrder.MoveToFront(element)
+		return
+	}
+	p.sticky[mxHost] = p.stickyOrder.PushFront(stickyHost{host: mxHost, idx: idx})
+
+	maxHosts := p.MaxStickyHosts
+	if maxHosts <= 0 {
+		maxHosts = defaultMaxStickyHosts
+	}
+	for p.stickyOrder.Len() > maxHosts {
+		p.unstick(p.stickyOrder.Back())
+	}
+}
+
+func (p *Pool) unstick(element *list.Element) {
+	delete(p.sticky, element.Value.(stickyHost).host)
+	p.stickyOrder.Remove(element)
+}
+
+// ReportBlacklisted retires the identity and releases any MX hosts stuck to it
+func (p *Pool) ReportBlacklisted(identity Identity) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for idx, m := range p.members {
+		if m.identity != identity {
+			continue
+		}
+		m.blacklisted++
+		m.retired = true
+		if p.RetireFor > 0 {
+			m.retiredUntil = p.clock().Add(p.RetireFor)
+		}
+		for _, element := range p.sticky {
+			if element.Value.(stickyHost).idx == idx {
+				p.unstick(element)
+			}
+		}
+	}
+}
+
+// Restore puts a retired identity back into rotation
+func (p *Pool) Restore(identity Identity) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, m := range p.members {
+		if m.identity == identity {
+			m.retired = false
+			m.retiredUntil = time.Time{}
+		}
+	}
+}
+
+// Find returns the identity with the given name
+func (p *Pool) Find(name string) (Identity, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, m := range p.members {
+		if name != "" && m.identity.String() == name {
+			return m.identity, true
+		}
+	}
+	return Identity{}, false
+}
+
+// Status returns the health of every identity in the pool
+func (p *Pool) Status() []IdentityStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.clock()
+	status := make([]IdentityStatus, 0, len(p.members))
+	for _, m := range p.members {
+		status = append(status, IdentityStatus{
+			Identity:     m.identity,
+			Healthy:      p.isHealthy(m, now),
+			Blacklisted:  m.blacklisted,
+			RetiredUntil: m.retiredUntil,
+			LastUsed:     m.lastUsed,
+		})
+	}
+	return status
+}
+
+func (p *Pool) isHealthy(m *member, now time.Time) bool {
+	if !m.retired {
+		return true
+	}
+	if !m.retiredUntil.IsZero() && !now.Before(m.retiredUntil) {
+		m.retired = false
+		m.retiredUntil = time.Time{}
+		return true
+	}
+	return false
+}
+
+func (p *Pool) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
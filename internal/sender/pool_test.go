@@ -0,0 +1,158 @@
+package sender
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	probeOne = Identity{Name: "probe-1", BindIP: "203.0.113.10", FromDomain: "one.example"}
+	probeTwo = Identity{Name: "probe-2", BindIP: "203.0.113.11", FromDomain: "two.example"}
+)
+
+func TestPoolStickiness(t *testing.T) {
+	pool := NewPool([]Identity{probeOne, probeTwo})
+
+	first, err := pool.Pick("mx1.acme.com")
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		again, err := pool.Pick("mx1.acme.com")
+		require.NoError(t, err)
+		assert.Equal(t, first, again, "MX host should keep its identity")
+	}
+}
+
+func TestPoolForgetsLeastRecentlyPickedHost(t *testing.T) {
+	pool := NewPool([]Identity{probeOne, probeTwo})
+	pool.MaxStickyHosts = 2
+
+	a, _ := pool.Pick("mx1.acme.com")
+	pool.Pick("mx1.globex.com")
+	pool.Pick("mx1.acme.com")
+	pool.Pick("mx1.initech.com")
+	assert.Len(t, pool.sticky, 2)
+
+	again, _ := pool.Pick("mx1.acme.com")
+	assert.Equal(t, a, again, "a recently picked host keeps its identity")
+	_, forgotten := pool.sticky["mx1.globex.com"]
+	assert.False(t, forgotten, "the least recently picked host is forgotten")
+}
+
+func TestEmptyPool(t *testing.T) {
+	for name, pool := range map[string]*Pool{"zero value": {}, "no identities": NewPool(nil)} {
+		t.Run(name, func(t *testing.T) {
+			_, err := pool.Pick("mx1.acme.com")
+			assert.ErrorIs(t, err, ErrEmptyPool)
+		})
+	}
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	pool := NewPool([]Identity{probeOne, probeTwo})
+
+	a, _ := pool.Pick("mx1.acme.com")
+	b, _ := pool.Pick("mx1.globex.com")
+	c, _ := pool.Pick("mx1.initech.com")
+
+	assert.Equal(t, probeOne, a)
+	assert.Equal(t, probeTwo, b)
+	assert.Equal(t, probeOne, c)
+}
+
+func TestPoolRetiresBlacklisted(t *testing.T) {
+	pool := NewPool([]Identity{probeOne, probeTwo})
+
+	first, _ := pool.Pick("mx1.acme.com")
+	pool.ReportBlacklisted(first)
+
+	next, err := pool.Pick("mx1.acme.com")
+	require.NoError(t, err)
+	assert.NotEqual(t, first, next, "blacklisted identity should be replaced")
+
+	pool.ReportBlacklisted(next)
+	_, err = pool.Pick("mx1.acme.com")
+	assert.ErrorIs(t, err, ErrNoHealthyIdentity)
+
+	pool.Restore(first)
+	restored, err := pool.Pick("mx1.acme.com")
+	require.NoError(t, err)
+	assert.Equal(t, first, restored)
+}
+
+func TestPoolRetireForExpires(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	pool := NewPool([]Identity{probeOne})
+	pool.RetireFor = time.Hour
+	pool.now = func() time.Time { return now }
+
+	pool.ReportBlacklisted(probeOne)
+	_, err := pool.Pick("mx1.acme.com")
+	assert.ErrorIs(t, err, ErrNoHealthyIdentity)
+
+	status := pool.Status()
+	require.Len(t, status, 1)
+	assert.False(t, status[0].Healthy)
+	assert.Equal(t, 1, status[0].Blacklisted)
+
+	now = now.Add(2 * time.Hour)
+	identity, err := pool.Pick("mx1.acme.com")
+	require.NoError(t, err)
+	assert.Equal(t, probeOne, identity)
+}
+
+func TestIdentityDefaults(t *testing.T) {
+	identity := Identity{BindIP: "203.0.113.10", FromDomain: "one.example"}
+	assert.Equal(t, "203.0.113.10/one.example", identity.String())
+	assert.Equal(t, "one.example", identity.Helo())
+	assert.True(t, strings.HasSuffix(identity.GenerateFromEmail(), "@one.example"))
+	assert.True(t, Identity{}.IsZero())
+}
+
+func TestLoadPool(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "senders.toml")
+	config := `
+retire_for = "30m"
+
+[[sender]]
+name = "probe-1"
+bind_ip = "203.0.113.10"
+helo_name = "mail1.one.example"
+from_domain = "one.example"
+
+[[sender]]
+from_domain = "two.example"
+`
+	require.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+
+	pool, err := LoadPool(path)
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Minute, pool.RetireFor)
+
+	status := pool.Status()
+	require.Len(t, status, 2)
+	assert.Equal(t, "mail1.one.example", status[0].Identity.HeloName)
+	assert.Equal(t, "two.example", status[1].Identity.FromDomain)
+
+	require.NoError(t, os.WriteFile(path, []byte("[[sender]]\nname = \"x\"\n"), 0o600))
+	_, err = LoadPool(path)
+	assert.Error(t, err, "sender without from_domain should be rejected")
+}
+
+func TestPoolFind(t *testing.T) {
+	pool := NewPool([]Identity{probeOne, probeTwo})
+
+	identity, ok := pool.Find("probe-2")
+	assert.True(t, ok)
+	assert.Equal(t, probeTwo, identity)
+
+	_, ok = pool.Find("probe-3")
+	assert.False(t, ok)
+	_, ok = pool.Find("")
+	assert.False(t, ok)
+}
@@ -0,0 +1,174 @@
+// Package smtptest provides a scripted SMTP server for exercising the prober
+// in tests
+package smtptest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/customeros/mailsherpa/domaincheck"
+)
+
+// Special replies: close the connection, or never answer
+const (
+	DropConnection = "<drop>"
+	IgnoreCommand  = "<ignore>"
+)
+
+// Server is a scripted SMTP server
+type Server struct {
+	IP       string
+	Port     string
+	listener net.Listener
+	greeting string
+	// Replies keyed by command prefix, e.g. "RCPT TO". Unlisted commands get 250
+	replies map[string]string
+
+	mu       sync.Mutex
+	commands []string
+	// Wait this long before the greeting and before each reply
+	greetingDelay time.Duration
+	replyDelay    time.Duration
+	// When set, STARTTLS upgrades the connection with this config
+	tlsConfig *tls.Config
+}
+
+// Start listens on ip and port, or on a free port when port is "0" or
+// empty. Servers on several loopback addresses can share a port to stand
+// in for several MX hosts. The server stops when the test ends.
+func Start(t *testing.T, ip, port, greeting string, replies map[string]string) *Server {
+	t.Helper()
+
+	if port == "" {
+		port = "0"
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip, port))
+	if err != nil {
+		t.Fatalf("failed to start fake SMTP server: %v", err)
+	}
+	_, port, _ = net.SplitHostPort(listener.Addr().String())
+
+	server := &Server{IP: ip, Port: port, listener: listener, greeting: greeting, replies: replies}
+	t.Cleanup(func() { listener.Close() })
+
+	go server.serve()
+	return server
+}
+
+func (f *Server) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *Server) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	greetingDelay, replyDelay := f.delays()
+	time.Sleep(greetingDelay)
+	writeLines(conn, f.greeting)
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+
+		f.mu.Lock()
+		f.commands = append(f.commands, cmd)
+		f.mu.Unlock()
+
+		if tlsConfig := f.tls(); strings.EqualFold(cmd, "STARTTLS") && tlsConfig != nil {
+			writeLines(conn, "220 Ready to start TLS")
+			tlsConn := tls.Server(conn, tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, reader = tlsConn, bufio.NewReader(tlsConn)
+			continue
+		}
+
+		reply, ok := f.reply(cmd)
+		if !ok {
+			reply = "250 OK"
+		}
+		if reply == DropConnection {
+			return
+		}
+		if reply == IgnoreCommand {
+			continue
+		}
+		time.Sleep(replyDelay)
+		writeLines(conn, reply)
+		if strings.HasPrefix(strings.ToUpper(cmd), "QUIT") {
+			return
+		}
+	}
+}
+
+func (f *Server) reply(cmd string) (string, bool) {
+	upper := strings.ToUpper(cmd)
+	for prefix, reply := range f.replies {
+		if strings.HasPrefix(upper, prefix) {
+			return reply, true
+		}
+	}
+	if strings.HasPrefix(upper, "QUIT") {
+		return "221 Bye", true
+	}
+	return "", false
+}
+
+// SetDelays slows the server down for connections made afterwards
+func (f *Server) SetDelays(greeting, reply time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.greetingDelay, f.replyDelay = greeting, reply
+}
+
+func (f *Server) delays() (time.Duration, time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.greetingDelay, f.replyDelay
+}
+
+// SetTLS makes the server offer STARTTLS with config
+func (f *Server) SetTLS(config *tls.Config) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tlsConfig = config
+}
+
+func (f *Server) tls() *tls.Config {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tlsConfig
+}
+
+// Received returns the commands the server got, in order
+func (f *Server) Received() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.commands...)
+}
+
+// DNS returns records with the server as the only MX host
+func (f *Server) DNS() domaincheck.DNS {
+	return domaincheck.DNS{MX: []string{f.IP}}
+}
+
+func writeLines(conn net.Conn, reply string) {
+	for _, line := range strings.Split(reply, "\n") {
+		conn.Write([]byte(line + "\r\n"))
+	}
+}
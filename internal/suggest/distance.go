@@ -0,0 +1,184 @@
+package suggest
+
+import "math"
+
+// Edit costs. Slips of the finger are cheaper than other edits because
+// they are the typos people actually make.
+const (
+	editCost          = 1.0
+	adjacentKeyCost   = 0.5
+	transpositionCost = 0.5
+	doubledLetterCost = 0.5
+)
+
+var keyboardRows = []string{
+	"1234567890",
+	"qwertyuiop",
+	"asdfghjkl",
+	"zxcvbnm",
+}
+
+// adjacent[a][b] is set when ASCII keys a and b touch on a QWERTY
+// keyboard. Rows are staggered, so a key touches the key above it and the
+// one to its upper right.
+var adjacent = func() (table [128][128]bool) {
+	for row, keys := range keyboardRows {
+		for col := range keys {
+			if col > 0 {
+				setAdjacent(&table, keys[col], keys[col-1])
+			}
+			if row == 0 {
+				continue
+			}
+			above := keyboardRows[row-1]
+			setAdjacent(&table, keys[col], above[col])
+			if col+1 < len(above) {
+				setAdjacent(&table, keys[col], above[col+1])
+			}
+		}
+	}
+	return table
+}()
+
+func setAdjacent(table *[128][128]bool, a, b byte) {
+	table[a][b] = true
+	table[b][a] = true
+}
+
+// Distance is the optimal string alignment distance between a and b, with
+// reduced costs for hitting a neighbouring QWERTY key, swapping two
+// adjacent letters, and doubling or dropping a repeated letter
+func Distance(a, b string) float64 {
+	var m distanceMatrix
+	distance, _ := m.within([]rune(a), []rune(b), math.Inf(1))
+	return distance
+}
+
+// distanceMatrix keeps the three rows optimal string alignment needs, so
+// comparing a domain with many candidates allocates once
+type distanceMatrix struct {
+	previous2, previous, current []float64
+}
+
+// within returns the distance between s and t, or false as soon as it is
+// certain to exceed limit. Every step off the diagonal costs at least
+// doubledLetterCost, so only a band around it is computed.
+func (m *distanceMatrix) within(s, t []rune, limit float64) (float64, bool) {
+	band := len(s) + len(t)
+	if !math.IsInf(limit, 1) {
+		band = int(limit / doubledLetterCost)
+	}
+	if abs(len(s)-len(t)) > band {
+		return 0, false
+	}
+
+	inf := math.Inf(1)
+	m.resize(len(t) + 1)
+	for j := range m.previous {
+		m.previous[j] = inf
+		m.current[j] = inf
+		if j <= band {
+			m.previous[j] = float64(j) * editCost
+		}
+	}
+	previousMin := 0.0
+
+	for i := 1; i <= len(s); i++ {
+		lo, hi := max(1, i-band), min(len(t), i+band)
+		m.current[lo-1] = inf
+		if lo == 1 && i <= band {
+			m.current[0] = float64(i) * editCost
+		}
+		if hi < len(t) {
+			m.current[hi+1] = inf
+		}
+
+		rowMin := m.current[lo-1]
+		for j := lo; j <= hi; j++ {
+			substitution := 0.0
+			if s[i-1] != t[j-1] {
+				substitution = editCost
+				if adjacentKeys(s[i-1], t[j-1]) {
+					substitution = adjacentKeyCost
+				}
+			}
+
+			deletion := editCost
+			if i > 1 && s[i-1] == s[i-2] {
+				deletion = doubledLetterCost
+			}
+			insertion := editCost
+			if j > 1 && t[j-1] == t[j-2] {
+				insertion = doubledLetterCost
+			}
+
+			cost := min3(
+				m.previous[j]+deletion,
+				m.current[j-1]+insertion,
+				m.previous[j-1]+substitution,
+			)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] && s[i-1] != s[i-2] {
+				if swapped := m.previous2[j-2] + transpositionCost; swapped < cost {
+					cost = swapped
+				}
+			}
+			m.current[j] = cost
+			if cost < rowMin {
+				rowMin = cost
+			}
+		}
+
+		// Every later cell builds on this row or the one before it
+		if rowMin > limit && previousMin > limit {
+			return 0, false
+		}
+		previousMin = rowMin
+		m.previous2, m.previous, m.current = m.previous, m.current, m.previous2
+	}
+
+	distance := m.previous[len(t)]
+	return distance, distance <= limit
+}
+
+func (m *distanceMatrix) resize(n int) {
+	if cap(m.current) < n {
+		m.previous2 = make([]float64, n)
+		m.previous = make([]float64, n)
+		m.current = make([]float64, n)
+	}
+	m.previous2 = m.previous2[:n]
+	m.previous = m.previous[:n]
+	m.current = m.current[:n]
+}
+
+// adjacentKeys reports whether a and b touch on a QWERTY keyboard
+func adjacentKeys(a, b rune) bool {
+	if a < 0 || b < 0 || a >= 128 || b >= 128 {
+		return false
+	}
+	return adjacent[a][b]
+}
+
+func min3(a, b, c float64) float64 {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,278 @@
+package suggest
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/publicsuffix"
+
+	emailproviders "github.com/customeros/mailsherpa/internal/email_providers"
+	freemail "github.com/customeros/mailsherpa/internal/free_emails"
+)
+
+// Suggestion is a domain the address was probably meant to have
+type Suggestion struct {
+	Domain string
+	// Between 0 and 1, higher is more likely
+	Confidence float64
+}
+
+const (
+	maxSuggestions = 3
+	// Cost of a typo the TLD rules recognise, such as .con for .com
+	tldTypoCost = 0.5
+	// Cost at which confidence drops to zero
+	costScale = 3.0
+	// Confidence factor for domains outside popularDomains
+	lessCommonFactor = 0.8
+	// Confidence Likely requires. Only popular domains reach it
+	MinLikelyConfidence = 0.8
+)
+
+// tldTypos are misspellings of common TLDs that are no TLD themselves
+var tldTypos = map[string]string{
+	"con":  "com",
+	"cmo":  "com",
+	"ocm":  "com",
+	"vom":  "com",
+	"xom":  "com",
+	"cpm":  "com",
+	"cim":  "com",
+	"c0m":  "com",
+	"comm": "com",
+	"coom": "com",
+	"nte":  "net",
+	"ner":  "net",
+	"nett": "net",
+	"ogr":  "org",
+	"rog":  "org",
+	"orgg": "org",
+}
+
+// commonTLDs are tried when an unknown TLD isn't in tldTypos
+var commonTLDs = []string{
+	"com", "net", "org", "edu", "gov", "io", "co", "us", "uk", "de",
+	"fr", "nl", "es", "it", "ca", "au", "in", "info", "biz", "me",
+}
+
+// popularDomains are the mailbox providers most addresses belong to. They
+// rank above the long tail of the free email list.
+var popularDomains = map[string]bool{
+	"gmail.com":      true,
+	"googlemail.com": true,
+	"yahoo.com":      true,
+	"yahoo.co.uk":    true,
+	"hotmail.com":    true,
+	"hotmail.co.uk":  true,
+	"outlook.com":    true,
+	"live.com":       true,
+	"msn.com":        true,
+	"icloud.com":     true,
+	"me.com":         true,
+	"aol.com":        true,
+	"protonmail.com": true,
+	"proton.me":      true,
+	"gmx.com":        true,
+	"gmx.de":         true,
+	"web.de":         true,
+	"mail.com":       true,
+	"mail.ru":        true,
+	"yandex.ru":      true,
+	"qq.com":         true,
+	"163.com":        true,
+	"comcast.net":    true,
+	"verizon.net":    true,
+	"att.net":        true,
+}
+
+var (
+	knownOnce sync.Once
+	known     *index
+	popular   *index
+	knownErr  error
+)
+
+// index holds candidate domains bucketed by length, so a search only
+// compares domains of about the same length
+type index struct {
+	domains  map[string]bool
+	byLength map[int][]candidate
+}
+
+type candidate struct {
+	domain string
+	runes  []rune
+}
+
+func newIndex(domains map[string]bool) *index {
+	idx := &index{domains: domains, byLength: make(map[int][]candidate)}
+	for domain := range domains {
+		runes := []rune(domain)
+		idx.byLength[len(runes)] = append(idx.byLength[len(runes)], candidate{domain: domain, runes: runes})
+	}
+	return idx
+}
+
+// Domain returns up to three domains the given one is likely a typo of,
+// most likely first. Candidates are the free email and known provider
+// domains, and the domain itself with a misspelled TLD corrected. Known
+// domains get no suggestions. Use it when the domain can't be right, e.g.
+// its TLD doesn't exist or it has no MX records.
+func Domain(domain string) ([]Suggestion, error) {
+	if err := loadKnownDomains(); err != nil {
+		return nil, err
+	}
+	return search(domain, known, 0), nil
+}
+
+// Likely returns only the suggestions confident enough to offer for a
+// domain that may well be real: a slip of the finger away from a popular
+// provider. bp.com and web.dev get none.
+func Likely(domain string) ([]Suggestion, error) {
+	if err := loadKnownDomains(); err != nil {
+		return nil, err
+	}
+	return search(domain, popular, MinLikelyConfidence), nil
+}
+
+func search(domain string, candidates *index, minConfidence float64) []Suggestion {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if domain == "" || known.domains[domain] {
+		return nil
+	}
+
+	costs := make(map[string]float64)
+	consider := func(candidate string, cost float64) {
+		if candidate == domain {
+			return
+		}
+		if current, ok := costs[candidate]; !ok || cost < current {
+			costs[candidate] = cost
+		}
+	}
+
+	var matrix distanceMatrix
+	for _, variant := range tldVariants(domain) {
+		if variant.cost > 0 {
+			consider(variant.domain, variant.cost)
+		}
+		runes := []rune(variant.domain)
+		limit := maxDistance(variant.domain)
+		spread := int(2 * limit)
+		for length := len(runes) - spread; length <= len(runes)+spread; length++ {
+			for _, c := range candidates.byLength[length] {
+				if distance, ok := matrix.within(runes, c.runes, limit); ok {
+					consider(c.domain, variant.cost+distance)
+				}
+			}
+		}
+	}
+
+	suggestions := make([]Suggestion, 0, len(costs))
+	for candidate, cost := range costs {
+		confidence := 1 - cost/costScale
+		if !popularDomains[candidate] {
+			confidence *= lessCommonFactor
+		}
+		if confidence > 0 && confidence >= minConfidence {
+			suggestions = append(suggestions, Suggestion{Domain: candidate, Confidence: confidence})
+		}
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Confidence != suggestions[j].Confidence {
+			return suggestions[i].Confidence > suggestions[j].Confidence
+		}
+		return suggestions[i].Domain < suggestions[j].Domain
+	})
+	if len(suggestions) > maxSuggestions {
+		suggestions = suggestions[:maxSuggestions]
+	}
+	if len(suggestions) == 0 {
+		return nil
+	}
+	return suggestions
+}
+
+type variant struct {
+	domain string
+	cost   float64
+}
+
+// tldVariants returns the domain along with its TLD typo corrections
+func tldVariants(domain string) []variant {
+	variants := []variant{{domain: domain}}
+
+	tld, icann := publicsuffix.PublicSuffix(domain)
+	name := strings.TrimSuffix(domain, "."+tld)
+	if name == domain || icann {
+		return variants
+	}
+
+	if fixed, ok := tldTypos[tld]; ok {
+		return append(variants, variant{domain: name + "." + fixed, cost: tldTypoCost})
+	}
+	for _, common := range commonTLDs {
+		if distance := Distance(tld, common); distance <= 1 {
+			variants = append(variants, variant{domain: name + "." + common, cost: distance})
+		}
+	}
+	return variants
+}
+
+// maxDistance is the largest edit distance still treated as a typo. Short
+// domains tolerate less, as every other short domain is close to them.
+func maxDistance(domain string) float64 {
+	name := domain
+	if dot := strings.IndexByte(domain, '.'); dot >= 0 {
+		name = domain[:dot]
+	}
+	if len(name) < 8 {
+		return 1
+	}
+	return 1.5
+}
+
+func loadKnownDomains() error {
+	knownOnce.Do(func() {
+		freeDomains, err := freemail.FreeEmailDomains()
+		if err != nil {
+			knownErr = err
+			return
+		}
+		providers, err := emailproviders.GetKnownProviders()
+		if err != nil {
+			knownErr = err
+			return
+		}
+
+		domains := make(map[string]bool)
+		for _, domain := range freeDomains {
+			domains[domain] = true
+		}
+		for _, category := range []emailproviders.ProviderCategory{
+			providers.Enterprise,
+			providers.Hosting,
+			providers.Webmail,
+			providers.Security,
+		} {
+			for _, provider := range category.Domains {
+				domains[provider[0]] = true
+			}
+		}
+		for domain := range popularDomains {
+			domains[domain] = true
+		}
+		known = newIndex(domains)
+		popular = newIndex(popularDomains)
+	})
+	return knownErr
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,114 @@
+package suggest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected float64
+	}{
+		{"gmail.com", "gmail.com", 0},
+		{"gmial.com", "gmail.com", transpositionCost},
+		{"gmaik.com", "gmail.com", adjacentKeyCost},
+		{"gmaill.com", "gmail.com", doubledLetterCost},
+		{"outlok.com", "outlook.com", doubledLetterCost},
+		{"gmpil.com", "gmail.com", editCost},
+		{"acme.com", "acre.com", editCost},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Distance(tt.a, tt.b))
+		})
+	}
+}
+
+func TestDomain(t *testing.T) {
+	tests := []struct {
+		domain   string
+		expected string
+	}{
+		{"gmial.com", "gmail.com"},
+		{"outlok.com", "outlook.com"},
+		{"hotmial.com", "hotmail.com"},
+		{"yhaoo.com", "yahoo.com"},
+		{"gmail.con", "gmail.com"},
+		{"gmial.cmo", "gmail.com"},
+		{"acme.con", "acme.com"},
+		{"acme.nte", "acme.net"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			suggestions, err := Domain(tt.domain)
+			require.NoError(t, err)
+			require.NotEmpty(t, suggestions)
+			assert.Equal(t, tt.expected, suggestions[0].Domain)
+			assert.LessOrEqual(t, len(suggestions), maxSuggestions)
+			for i := 1; i < len(suggestions); i++ {
+				assert.GreaterOrEqual(t, suggestions[i-1].Confidence, suggestions[i].Confidence)
+			}
+		})
+	}
+}
+
+func TestDomainWithoutSuggestions(t *testing.T) {
+	for _, domain := range []string{"gmail.com", "outlook.com", "customeros.ai", "microsoft.com"} {
+		suggestions, err := Domain(domain)
+		require.NoError(t, err)
+		assert.Empty(t, suggestions, domain)
+	}
+}
+
+func TestPopularDomainsRankFirst(t *testing.T) {
+	suggestions, err := Domain("gmial.com")
+	require.NoError(t, err)
+	require.NotEmpty(t, suggestions)
+	assert.Equal(t, "gmail.com", suggestions[0].Domain)
+	assert.Greater(t, suggestions[0].Confidence, 0.8)
+}
+
+func TestLikely(t *testing.T) {
+	suggestions, err := Likely("gmial.com")
+	require.NoError(t, err)
+	require.NotEmpty(t, suggestions)
+	assert.Equal(t, "gmail.com", suggestions[0].Domain)
+
+	// Real domains a short edit away from a provider
+	for _, domain := range []string{"bp.com", "web.dev", "acme.com", "stripe.com"} {
+		suggestions, err := Likely(domain)
+		require.NoError(t, err)
+		assert.Empty(t, suggestions, domain)
+	}
+}
+
+func TestDistanceWithinLimit(t *testing.T) {
+	var m distanceMatrix
+	_, ok := m.within([]rune("customeros.ai"), []rune("gmail.com"), 1.5)
+	assert.False(t, ok)
+
+	distance, ok := m.within([]rune("gmial.com"), []rune("gmail.com"), 1)
+	assert.True(t, ok)
+	assert.Equal(t, transpositionCost, distance)
+}
+
+func BenchmarkDomain(b *testing.B) {
+	for _, domain := range []string{"acme.com", "stripe.com", "customeros.ai"} {
+		b.Run(domain, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Domain(domain)
+			}
+		})
+	}
+}
+
+func BenchmarkLikely(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		Likely("customeros.ai")
+	}
+}
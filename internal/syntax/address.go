@@ -0,0 +1,445 @@
+package syntax
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// Length limits from RFC 5321 section 4.5.3.1
+const (
+	maxLocalPartLength = 64
+	maxDomainLength    = 255
+	maxLabelLength     = 63
+	// A 256 octet path minus the angle brackets
+	maxAddressLength = 254
+)
+
+var (
+	ErrEmptyAddress        = errors.New("address is empty")
+	ErrMissingAt           = errors.New("missing @ between local part and domain")
+	ErrEmptyLocalPart      = errors.New("local part is empty")
+	ErrEmptyDomain         = errors.New("domain is empty")
+	ErrInvalidCharacter    = errors.New("invalid character")
+	ErrSurroundingSpace    = errors.New("leading or trailing whitespace")
+	ErrInvalidUTF8         = errors.New("address is not valid UTF-8")
+	ErrInvalidIDN          = errors.New("internationalized domain is invalid")
+	ErrConsecutiveDots     = errors.New("consecutive dots")
+	ErrLeadingDot          = errors.New("leading dot")
+	ErrTrailingDot         = errors.New("trailing dot")
+	ErrUnterminatedQuote   = errors.New("unterminated quoted string")
+	ErrUnterminatedComment = errors.New("unterminated comment")
+	ErrUnterminatedLiteral = errors.New("unterminated domain literal")
+	ErrInvalidLiteral      = errors.New("domain literal is not a valid IP address")
+	ErrLocalPartTooLong    = errors.New("local part exceeds 64 characters")
+	ErrDomainTooLong       = errors.New("domain exceeds 255 characters")
+	ErrLabelTooLong        = errors.New("domain label exceeds 63 characters")
+	ErrInvalidLabel        = errors.New("domain label starts or ends with a hyphen")
+	ErrAddressTooLong      = errors.New("address exceeds 254 characters")
+	ErrUnknownTLD          = errors.New("domain has no recognised top-level domain")
+	ErrTooManyLabels       = errors.New("domain has too many labels")
+)
+
+// SyntaxError is a grammar violation and where in the address it occurred
+type SyntaxError struct {
+	Err error
+	// Offset into the address, or -1 when the whole address is at fault
+	Pos    int
+	Detail string
+}
+
+func (e *SyntaxError) Error() string {
+	msg := e.Err.Error()
+	if e.Detail != "" {
+		msg = fmt.Sprintf("%s %s", msg, e.Detail)
+	}
+	if e.Pos >= 0 {
+		msg = fmt.Sprintf("%s at position %d", msg, e.Pos+1)
+	}
+	return msg
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// Address is an RFC 5322 addr-spec
+type Address struct {
+	// Dot-atom form when possible, otherwise the quoted string with its quotes
+	LocalPart string
+	// Hostname, or the bracketed literal such as [192.0.2.1]
+	Domain string
+	// The local part needs quoting, e.g. "john smith"
+	Quoted bool
+	// The domain is an address literal rather than a hostname
+	DomainLiteral bool
+	// Comments removed from around the local part and domain
+	Comments []string
+}
+
+func (a Address) String() string {
+	return a.LocalPart + "@" + a.Domain
+}
+
+// ParseAddress parses an addr-spec: a dot-atom or quoted-string local part
+// and a dot-atom or literal domain, optionally surrounded by comments.
+// Whitespace is only allowed inside quoted strings and comments, since
+// RFC 5321 doesn't allow it in SMTP paths.
+func ParseAddress(address string) (Address, error) {
+	if address == "" {
+		return Address{}, &SyntaxError{Err: ErrEmptyAddress, Pos: -1}
+	}
+
+	p := &addressParser{input: address}
+	addr, err := p.parse()
+	if err != nil {
+		return Address{}, err
+	}
+
+	if len(addr.LocalPart) > maxLocalPartLength {
+		return Address{}, &SyntaxError{Err: ErrLocalPartTooLong, Pos: -1}
+	}
+	if len(addr.Domain) > maxDomainLength {
+		return Address{}, &SyntaxError{Err: ErrDomainTooLong, Pos: -1}
+	}
+	if len(addr.String()) > maxAddressLength {
+		return Address{}, &SyntaxError{Err: ErrAddressTooLong, Pos: -1}
+	}
+	return addr, nil
+}
+
+type addressParser struct {
+	input string
+	pos   int
+}
+
+func (p *addressParser) parse() (Address, error) {
+	var addr Address
+
+	if err := p.skipComments(&addr); err != nil {
+		return addr, err
+	}
+	if p.done() || p.peek() == '@' {
+		return addr, p.errorAt(ErrEmptyLocalPart)
+	}
+
+	if p.peek() == '"' {
+		local, err := p.quotedString()
+		if err != nil {
+			return addr, err
+		}
+		addr.LocalPart, addr.Quoted = canonicalLocalPart(local)
+	} else {
+		local, err := p.dotAtom("local part")
+		if err != nil {
+			return addr, err
+		}
+		addr.LocalPart = local
+	}
+
+	if err := p.skipComments(&addr); err != nil {
+		return addr, err
+	}
+	if p.done() {
+		return addr, &SyntaxError{Err: ErrMissingAt, Pos: -1}
+	}
+	if p.peek() != '@' {
+		return addr, p.invalidCharacter("in local part")
+	}
+	p.pos++
+
+	if err := p.skipComments(&addr); err != nil {
+		return addr, err
+	}
+	if p.done() {
+		return addr, p.errorAt(ErrEmptyDomain)
+	}
+
+	if p.peek() == '[' {
+		literal, err := p.domainLiteral()
+		if err != nil {
+			return addr, err
+		}
+		addr.Domain, addr.DomainLiteral = literal, true
+	} else {
+		start := p.pos
+		domain, err := p.dotAtom("domain")
+		if err != nil {
+			return addr, err
+		}
+
+		// Internationalized domains are checked in their ASCII form, where
+		// positions in the original no longer apply
+		if !isASCII(domain) {
+			ascii, err := idna.Lookup.ToASCII(domain)
+			if err != nil {
+				return addr, &SyntaxError{Err: ErrInvalidIDN, Pos: start}
+			}
+			domain, start = ascii, -1
+		}
+		if err := checkLabels(domain, start); err != nil {
+			return addr, err
+		}
+		addr.Domain = domain
+	}
+
+	if err := p.skipComments(&addr); err != nil {
+		return addr, err
+	}
+	if !p.done() {
+		return addr, p.invalidCharacter("after domain")
+	}
+	return addr, nil
+}
+
+// dotAtom reads atoms separated by single dots
+func (p *addressParser) dotAtom(where string) (string, error) {
+	start := p.pos
+	for !p.done() {
+		c := p.peek()
+		if c == '.' {
+			if p.pos == start {
+				return "", p.errorAt(ErrLeadingDot)
+			}
+			if p.input[p.pos-1] == '.' {
+				return "", p.errorAt(ErrConsecutiveDots)
+			}
+		} else if !isAtext(c) {
+			break
+		}
+		p.pos++
+	}
+
+	if p.pos == start {
+		return "", p.invalidCharacter("in " + where)
+	}
+	if p.input[p.pos-1] == '.' {
+		return "", &SyntaxError{Err: ErrTrailingDot, Pos: p.pos - 1, Detail: "in " + where}
+	}
+	return p.input[start:p.pos], nil
+}
+
+// quotedString reads a quoted-string and returns its content with quoted
+// pairs resolved
+func (p *addressParser) quotedString() (string, error) {
+	start := p.pos
+	p.pos++
+
+	var content strings.Builder
+	for !p.done() {
+		c := p.peek()
+		switch {
+		case c == '"':
+			p.pos++
+			return content.String(), nil
+		case c == '\\':
+			p.pos++
+			if p.done() {
+				return "", &SyntaxError{Err: ErrUnterminatedQuote, Pos: start}
+			}
+			if !isQuotedPairChar(p.peek()) {
+				return "", p.invalidCharacter("in quoted string")
+			}
+			content.WriteByte(p.peek())
+		case isQtext(c) || c == ' ' || c == '\t':
+			content.WriteByte(c)
+		default:
+			return "", p.invalidCharacter("in quoted string")
+		}
+		p.pos++
+	}
+	return "", &SyntaxError{Err: ErrUnterminatedQuote, Pos: start}
+}
+
+// domainLiteral reads [IPv4] or [IPv6:address], RFC 5321 section 4.1.3
+func (p *addressParser) domainLiteral() (string, error) {
+	start := p.pos
+	end := strings.IndexByte(p.input[start:], ']')
+	if end < 0 {
+		return "", &SyntaxError{Err: ErrUnterminatedLiteral, Pos: start}
+	}
+	content := p.input[start+1 : start+end]
+	p.pos = start + end + 1
+
+	if ipv6, ok := cutPrefixFold(content, "IPv6:"); ok {
+		if ip := net.ParseIP(ipv6); ip != nil && strings.Contains(ipv6, ":") {
+			return "[IPv6:" + strings.ToLower(ipv6) + "]", nil
+		}
+		return "", &SyntaxError{Err: ErrInvalidLiteral, Pos: start}
+	}
+	if ip := net.ParseIP(content); ip != nil && ip.To4() != nil && !strings.Contains(content, ":") {
+		return "[" + content + "]", nil
+	}
+	return "", &SyntaxError{Err: ErrInvalidLiteral, Pos: start}
+}
+
+// DomainLiteralIP returns the IP address of a domain literal such as
+// [192.0.2.1] or [IPv6:2001:db8::1]
+func DomainLiteralIP(domain string) (string, bool) {
+	if !strings.HasPrefix(domain, "[") || !strings.HasSuffix(domain, "]") {
+		return "", false
+	}
+	content := domain[1 : len(domain)-1]
+	if ipv6, ok := cutPrefixFold(content, "IPv6:"); ok {
+		content = ipv6
+	}
+	if net.ParseIP(content) == nil {
+		return "", false
+	}
+	return content, true
+}
+
+// skipComments skips comments, which may nest, and collects their text
+func (p *addressParser) skipComments(addr *Address) error {
+	for !p.done() && p.peek() == '(' {
+		start := p.pos
+		p.pos++
+
+		var text strings.Builder
+		for depth := 1; depth > 0; {
+			if p.done() {
+				return &SyntaxError{Err: ErrUnterminatedComment, Pos: start}
+			}
+			c := p.peek()
+			p.pos++
+			switch c {
+			case '\\':
+				if p.done() {
+					return &SyntaxError{Err: ErrUnterminatedComment, Pos: start}
+				}
+				c = p.peek()
+				p.pos++
+			case '(':
+				depth++
+			case ')':
+				depth--
+				if depth == 0 {
+					continue
+				}
+			}
+			text.WriteByte(c)
+		}
+		addr.Comments = append(addr.Comments, strings.TrimSpace(text.String()))
+	}
+	return nil
+}
+
+func (p *addressParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *addressParser) peek() byte {
+	return p.input[p.pos]
+}
+
+func (p *addressParser) errorAt(err error) error {
+	return &SyntaxError{Err: err, Pos: p.pos}
+}
+
+func (p *addressParser) invalidCharacter(where string) error {
+	if p.done() {
+		return &SyntaxError{Err: ErrInvalidCharacter, Pos: -1, Detail: "end of address " + where}
+	}
+	return &SyntaxError{Err: ErrInvalidCharacter, Pos: p.pos, Detail: fmt.Sprintf("%q %s", p.peek(), where)}
+}
+
+// checkLabels applies the RFC 5321 hostname rules to a dot-atom domain.
+// A negative offset leaves positions out of the errors.
+func checkLabels(domain string, offset int) error {
+	at := func(pos int) int {
+		if offset < 0 {
+			return -1
+		}
+		return offset + pos
+	}
+
+	pos := 0
+	for _, label := range strings.Split(domain, ".") {
+		if len(label) > maxLabelLength {
+			return &SyntaxError{Err: ErrLabelTooLong, Pos: at(pos)}
+		}
+		for i := 0; i < len(label); i++ {
+			if !isLetDig(label[i]) && label[i] != '-' {
+				return &SyntaxError{Err: ErrInvalidCharacter, Pos: at(pos + i), Detail: fmt.Sprintf("%q in domain", label[i])}
+			}
+		}
+		if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return &SyntaxError{Err: ErrInvalidLabel, Pos: at(pos)}
+		}
+		pos += len(label) + 1
+	}
+	return nil
+}
+
+// canonicalLocalPart drops quotes a local part doesn't need, as RFC 5321
+// section 4.1.2 asks, and otherwise quotes it minimally
+func canonicalLocalPart(content string) (string, bool) {
+	if content != "" && isDotAtom(content) {
+		return content, false
+	}
+
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for i := 0; i < len(content); i++ {
+		if content[i] == '"' || content[i] == '\\' {
+			quoted.WriteByte('\\')
+		}
+		quoted.WriteByte(content[i])
+	}
+	quoted.WriteByte('"')
+	return quoted.String(), true
+}
+
+// UnquoteLocalPart returns the content of a quoted local part, with its
+// quotes stripped and quoted pairs resolved. Dot-atom local parts are
+// returned as they are.
+func UnquoteLocalPart(local string) string {
+	if len(local) < 2 || local[0] != '"' || local[len(local)-1] != '"' {
+		return local
+	}
+
+	var content strings.Builder
+	for i := 1; i < len(local)-1; i++ {
+		if local[i] == '\\' && i+1 < len(local)-1 {
+			i++
+		}
+		content.WriteByte(local[i])
+	}
+	return content.String()
+}
+
+func isDotAtom(s string) bool {
+	p := &addressParser{input: s}
+	_, err := p.dotAtom("")
+	return err == nil && p.done()
+}
+
+// isAtext includes the bytes of UTF-8 encoded non-ASCII characters, which
+// RFC 6531 allows in atoms and quoted strings
+func isAtext(c byte) bool {
+	return isLetDig(c) || strings.IndexByte("!#$%&'*+-/=?^_`{|}~", c) >= 0 || c >= utf8.RuneSelf
+}
+
+func isLetDig(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// isQtext is any printable character except backslash and the double quote
+func isQtext(c byte) bool {
+	return c >= 33 && c <= 126 && c != '\\' && c != '"' || c >= utf8.RuneSelf
+}
+
+func isQuotedPairChar(c byte) bool {
+	return c >= 32 && c <= 126 || c == '\t'
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return s, false
+}
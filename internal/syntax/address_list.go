@@ -0,0 +1,241 @@
+package syntax
+
+import (
+	"errors"
+	"mime"
+	"strings"
+)
+
+var (
+	ErrUnterminatedAngle = errors.New("missing closing > after address")
+	ErrUnterminatedGroup = errors.New("group is missing its closing ;")
+)
+
+// Mailbox is one entry of an address list
+type Mailbox struct {
+	DisplayName string
+	// The address as written, e.g. john@acme.com for "John <john@acme.com>".
+	// It isn't validated here
+	AddrSpec string
+	// Name of the group the mailbox was listed in
+	Group string
+}
+
+// ParseAddressList splits an RFC 5322 address-list, such as a To: header
+// or a CRM field, into its mailboxes. Groups are flattened, with each
+// member keeping the group's name. Display names are unquoted and RFC 2047
+// encoded words decoded. Only structural errors, such as an unterminated
+// quote, fail the whole list.
+func ParseAddressList(list string) ([]Mailbox, error) {
+	p := &addressParser{input: list}
+
+	var mailboxes []Mailbox
+	for {
+		if err := p.skipSpaceAndComments(); err != nil {
+			return nil, err
+		}
+		if p.done() {
+			return mailboxes, nil
+		}
+		// Empty list items are tolerated, as RFC 5322 obs-addr-list does
+		if p.peek() == ',' {
+			p.pos++
+			continue
+		}
+
+		entries, err := p.listEntry(false)
+		if err != nil {
+			return nil, err
+		}
+		mailboxes = append(mailboxes, entries...)
+
+		if err := p.skipSpaceAndComments(); err != nil {
+			return nil, err
+		}
+		if p.done() {
+			return mailboxes, nil
+		}
+		if p.peek() != ',' {
+			return nil, p.invalidCharacter("between addresses")
+		}
+		p.pos++
+	}
+}
+
+// listEntry parses a name-addr, a bare addr-spec or, outside groups, a
+// group
+func (p *addressParser) listEntry(inGroup bool) ([]Mailbox, error) {
+	start := p.pos
+	name, err := p.phrase()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.done() {
+		switch {
+		case p.peek() == '<':
+			addrSpec, err := p.angleAddr()
+			if err != nil {
+				return nil, err
+			}
+			return []Mailbox{{DisplayName: name, AddrSpec: addrSpec}}, nil
+		case p.peek() == ':' && !inGroup && name != "":
+			p.pos++
+			return p.group(name)
+		}
+	}
+
+	// Not a display name after all: read the addr-spec as written
+	p.pos = start
+	addrSpec, err := p.rawAddrSpec(inGroup)
+	if err != nil {
+		return nil, err
+	}
+	return []Mailbox{{AddrSpec: addrSpec}}, nil
+}
+
+// group parses the members of a group up to its closing semicolon
+func (p *addressParser) group(name string) ([]Mailbox, error) {
+	start := p.pos
+	var members []Mailbox
+	for {
+		if err := p.skipSpaceAndComments(); err != nil {
+			return nil, err
+		}
+		if p.done() {
+			return nil, &SyntaxError{Err: ErrUnterminatedGroup, Pos: start}
+		}
+		switch p.peek() {
+		case ';':
+			p.pos++
+			return members, nil
+		case ',':
+			p.pos++
+			continue
+		}
+
+		entries, err := p.listEntry(true)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			entry.Group = name
+			members = append(members, entry)
+		}
+	}
+}
+
+// phrase reads a display name made of atoms and quoted strings. Dots are
+// accepted in atoms, as in the common obs-phrase "John Q. Public".
+func (p *addressParser) phrase() (string, error) {
+	var words []string
+	for {
+		if err := p.skipSpaceAndComments(); err != nil {
+			return "", err
+		}
+		if p.done() {
+			break
+		}
+
+		c := p.peek()
+		if c == '"' {
+			word, err := p.quotedString()
+			if err != nil {
+				return "", err
+			}
+			words = append(words, word)
+			continue
+		}
+		if !isAtext(c) && c != '.' {
+			break
+		}
+		start := p.pos
+		for !p.done() && (isAtext(p.peek()) || p.peek() == '.') {
+			p.pos++
+		}
+		words = append(words, decodeWords(p.input[start:p.pos]))
+	}
+	return strings.Join(words, " "), nil
+}
+
+// angleAddr reads <addr-spec> and returns what's between the brackets
+func (p *addressParser) angleAddr() (string, error) {
+	start := p.pos
+	p.pos++
+	for !p.done() {
+		switch p.peek() {
+		case '"':
+			if _, err := p.quotedString(); err != nil {
+				return "", err
+			}
+			continue
+		case '>':
+			addrSpec := strings.TrimSpace(p.input[start+1 : p.pos])
+			p.pos++
+			return addrSpec, nil
+		}
+		p.pos++
+	}
+	return "", &SyntaxError{Err: ErrUnterminatedAngle, Pos: start}
+}
+
+// rawAddrSpec reads up to the next list separator outside quotes,
+// comments and domain literals
+func (p *addressParser) rawAddrSpec(inGroup bool) (string, error) {
+	start := p.pos
+	for !p.done() {
+		c := p.peek()
+		switch {
+		case c == ',' || c == ';' && inGroup:
+			return strings.TrimSpace(p.input[start:p.pos]), nil
+		case c == '"':
+			if _, err := p.quotedString(); err != nil {
+				return "", err
+			}
+			continue
+		case c == '(':
+			var ignored Address
+			if err := p.skipComments(&ignored); err != nil {
+				return "", err
+			}
+			continue
+		case c == '[':
+			end := strings.IndexByte(p.input[p.pos:], ']')
+			if end < 0 {
+				return "", &SyntaxError{Err: ErrUnterminatedLiteral, Pos: p.pos}
+			}
+			p.pos += end
+		}
+		p.pos++
+	}
+	return strings.TrimSpace(p.input[start:p.pos]), nil
+}
+
+func (p *addressParser) skipSpaceAndComments() error {
+	var ignored Address
+	for !p.done() {
+		switch p.peek() {
+		case ' ', '\t', '\r', '\n':
+			p.pos++
+		case '(':
+			if err := p.skipComments(&ignored); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// decodeWords decodes RFC 2047 encoded words such as =?UTF-8?Q?Jos=C3=A9?=
+func decodeWords(word string) string {
+	if !strings.Contains(word, "=?") {
+		return word
+	}
+	decoded, err := new(mime.WordDecoder).DecodeHeader(word)
+	if err != nil {
+		return word
+	}
+	return decoded
+}
@@ -0,0 +1,112 @@
+package syntax_test
+
+import (
+	"testing"
+
+	"github.com/customeros/mailsherpa/internal/syntax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddressList(t *testing.T) {
+	tests := []struct {
+		name     string
+		list     string
+		expected []syntax.Mailbox
+	}{
+		{
+			name:     "Bare address",
+			list:     "jane@acme.com",
+			expected: []syntax.Mailbox{{AddrSpec: "jane@acme.com"}},
+		},
+		{
+			name: "Quoted display name containing a comma",
+			list: `"Smith, John" <john@acme.com>, jane@acme.com`,
+			expected: []syntax.Mailbox{
+				{DisplayName: "Smith, John", AddrSpec: "john@acme.com"},
+				{AddrSpec: "jane@acme.com"},
+			},
+		},
+		{
+			name:     "Unquoted display name with initials",
+			list:     "John Q. Public <john.q.public@example.com>",
+			expected: []syntax.Mailbox{{DisplayName: "John Q. Public", AddrSpec: "john.q.public@example.com"}},
+		},
+		{
+			name:     "Angle address without a display name",
+			list:     "<john@acme.com>",
+			expected: []syntax.Mailbox{{AddrSpec: "john@acme.com"}},
+		},
+		{
+			name:     "Comments are dropped",
+			list:     "John (Sales) <john@acme.com> (work)",
+			expected: []syntax.Mailbox{{DisplayName: "John", AddrSpec: "john@acme.com"}},
+		},
+		{
+			name:     "Encoded word",
+			list:     "=?UTF-8?Q?Jos=C3=A9_Garc=C3=ADa?= <jose@acme.com>",
+			expected: []syntax.Mailbox{{DisplayName: "José García", AddrSpec: "jose@acme.com"}},
+		},
+		{
+			name: "Group",
+			list: "Sales: alice@acme.com, Bob <bob@acme.com>;, carol@acme.com",
+			expected: []syntax.Mailbox{
+				{AddrSpec: "alice@acme.com", Group: "Sales"},
+				{DisplayName: "Bob", AddrSpec: "bob@acme.com", Group: "Sales"},
+				{AddrSpec: "carol@acme.com"},
+			},
+		},
+		{
+			name:     "Empty group",
+			list:     "undisclosed-recipients:;",
+			expected: nil,
+		},
+		{
+			name: "Empty items and folding whitespace",
+			list: "a@acme.com,,\r\n\t b@acme.com ,",
+			expected: []syntax.Mailbox{
+				{AddrSpec: "a@acme.com"},
+				{AddrSpec: "b@acme.com"},
+			},
+		},
+		{
+			name:     "Quoted local part with a comma",
+			list:     `"doe, jane"@acme.com`,
+			expected: []syntax.Mailbox{{AddrSpec: `"doe, jane"@acme.com`}},
+		},
+		{
+			name:     "Invalid address is returned as written",
+			list:     "not an address",
+			expected: []syntax.Mailbox{{AddrSpec: "not an address"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mailboxes, err := syntax.ParseAddressList(tt.list)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, mailboxes)
+		})
+	}
+}
+
+func TestParseAddressListErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		list     string
+		expected error
+	}{
+		{"Unterminated display name", `"Smith, John <john@acme.com>`, syntax.ErrUnterminatedQuote},
+		{"Missing closing bracket", "John <john@acme.com, jane@acme.com", syntax.ErrUnterminatedAngle},
+		{"Unterminated group", "Sales: alice@acme.com, bob@acme.com", syntax.ErrUnterminatedGroup},
+		{"Unterminated comment", "John (Sales <john@acme.com>", syntax.ErrUnterminatedComment},
+		{"Text after an address", "<john@acme.com> jane@acme.com", syntax.ErrInvalidCharacter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := syntax.ParseAddressList(tt.list)
+			assert.ErrorIs(t, err, tt.expected)
+		})
+	}
+}
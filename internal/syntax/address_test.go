@@ -0,0 +1,184 @@
+package syntax_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/customeros/mailsherpa/internal/syntax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		address  string
+		expected syntax.Address
+	}{
+		{
+			name:     "Dot-atom",
+			address:  "john.smith@example.com",
+			expected: syntax.Address{LocalPart: "john.smith", Domain: "example.com"},
+		},
+		{
+			name:     "Quoted local part with a space",
+			address:  `"john smith"@example.com`,
+			expected: syntax.Address{LocalPart: `"john smith"`, Domain: "example.com", Quoted: true},
+		},
+		{
+			name:     "Quoted @",
+			address:  `"a@b"@example.com`,
+			expected: syntax.Address{LocalPart: `"a@b"`, Domain: "example.com", Quoted: true},
+		},
+		{
+			name:     "Quoted pair",
+			address:  `"john\"smith"@example.com`,
+			expected: syntax.Address{LocalPart: `"john\"smith"`, Domain: "example.com", Quoted: true},
+		},
+		{
+			name:     "Unnecessary quotes are dropped",
+			address:  `"john.smith"@example.com`,
+			expected: syntax.Address{LocalPart: "john.smith", Domain: "example.com"},
+		},
+		{
+			name:     "IPv4 literal",
+			address:  "user@[192.0.2.1]",
+			expected: syntax.Address{LocalPart: "user", Domain: "[192.0.2.1]", DomainLiteral: true},
+		},
+		{
+			name:     "IPv6 literal",
+			address:  "user@[IPv6:2001:DB8::1]",
+			expected: syntax.Address{LocalPart: "user", Domain: "[IPv6:2001:db8::1]", DomainLiteral: true},
+		},
+		{
+			name:    "Comments",
+			address: "(work)john(nested (comment))@example.com(primary)",
+			expected: syntax.Address{
+				LocalPart: "john",
+				Domain:    "example.com",
+				Comments:  []string{"work", "nested (comment)", "primary"},
+			},
+		},
+		{
+			name:     "Special characters",
+			address:  "user!#$%&'*+/=?^_`{|}~-@example.com",
+			expected: syntax.Address{LocalPart: "user!#$%&'*+/=?^_`{|}~-", Domain: "example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := syntax.ParseAddress(tt.address)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, addr)
+		})
+	}
+}
+
+func TestParseAddressErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		address  string
+		expected error
+		message  string
+	}{
+		{"Empty", "", syntax.ErrEmptyAddress, "address is empty"},
+		{"No @", "john.example.com", syntax.ErrMissingAt, "missing @ between local part and domain"},
+		{"Empty local part", "@example.com", syntax.ErrEmptyLocalPart, "local part is empty at position 1"},
+		{"Empty domain", "john@", syntax.ErrEmptyDomain, "domain is empty at position 6"},
+		{"Double @", "john@@example.com", syntax.ErrInvalidCharacter, `invalid character '@' in domain at position 6`},
+		{"Unquoted @", "a@b@example.com", syntax.ErrInvalidCharacter, `invalid character '@' after domain at position 4`},
+		{"Consecutive dots", "john..smith@example.com", syntax.ErrConsecutiveDots, "consecutive dots at position 6"},
+		{"Leading dot", ".john@example.com", syntax.ErrLeadingDot, "leading dot at position 1"},
+		{"Trailing dot", "john.@example.com", syntax.ErrTrailingDot, "trailing dot in local part at position 5"},
+		{"Space", "john smith@example.com", syntax.ErrInvalidCharacter, `invalid character ' ' in local part at position 5`},
+		{"Unterminated quote", `"john@example.com`, syntax.ErrUnterminatedQuote, "unterminated quoted string at position 1"},
+		{"Unterminated comment", "john(work@example.com", syntax.ErrUnterminatedComment, "unterminated comment at position 5"},
+		{"Bad literal", "john@[300.1.1.1]", syntax.ErrInvalidLiteral, "domain literal is not a valid IP address at position 6"},
+		{"Unterminated literal", "john@[192.0.2.1", syntax.ErrUnterminatedLiteral, "unterminated domain literal at position 6"},
+		{"Hyphenated label", "john@-example.com", syntax.ErrInvalidLabel, "domain label starts or ends with a hyphen at position 6"},
+		{"Underscore in domain", "john@ex_ample.com", syntax.ErrInvalidCharacter, `invalid character '_' in domain at position 8`},
+		{"Long local part", strings.Repeat("a", 65) + "@example.com", syntax.ErrLocalPartTooLong, "local part exceeds 64 characters"},
+		{"Long label", "john@" + strings.Repeat("a", 64) + ".com", syntax.ErrLabelTooLong, "domain label exceeds 63 characters at position 6"},
+		{"Long address", strings.Repeat("a", 64) + "@" + strings.Repeat(strings.Repeat("b", 60)+".", 3) + strings.Repeat("c", 10) + ".com", syntax.ErrAddressTooLong, "address exceeds 254 characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := syntax.ParseAddress(tt.address)
+			require.Error(t, err)
+			assert.ErrorIs(t, err, tt.expected)
+			assert.Equal(t, tt.message, err.Error())
+		})
+	}
+}
+
+func TestCheckEmailAddress(t *testing.T) {
+	email, user, domain, err := syntax.CheckEmailAddress(`"John Smith"@Example.com`)
+	require.NoError(t, err)
+	assert.Equal(t, `"john smith"@example.com`, email)
+	assert.Equal(t, `"john smith"`, user)
+	assert.Equal(t, "example.com", domain)
+
+	_, _, _, err = syntax.CheckEmailAddress("user@[192.0.2.1]")
+	assert.NoError(t, err, "literals skip the TLD check")
+
+	email, _, _, err = syntax.CheckEmailAddress("user@domain")
+	assert.ErrorIs(t, err, syntax.ErrUnknownTLD)
+	assert.Equal(t, "user@domain", email, "parsed parts are kept when only the domain is wrong")
+
+	_, _, _, err = syntax.CheckEmailAddress(" user@example.com")
+	assert.ErrorIs(t, err, syntax.ErrSurroundingSpace)
+}
+
+func TestUnquoteLocalPart(t *testing.T) {
+	assert.Equal(t, "john smith", syntax.UnquoteLocalPart(`"john smith"`))
+	assert.Equal(t, `say "hi"`, syntax.UnquoteLocalPart(`"say \"hi\""`))
+	assert.Equal(t, "john.doe", syntax.UnquoteLocalPart("john.doe"))
+}
+
+func TestDomainLiteralIP(t *testing.T) {
+	ip, ok := syntax.DomainLiteralIP("[192.0.2.1]")
+	assert.True(t, ok)
+	assert.Equal(t, "192.0.2.1", ip)
+
+	ip, ok = syntax.DomainLiteralIP("[IPv6:2001:db8::1]")
+	assert.True(t, ok)
+	assert.Equal(t, "2001:db8::1", ip)
+
+	_, ok = syntax.DomainLiteralIP("example.com")
+	assert.False(t, ok)
+}
+
+func TestParseInternationalAddress(t *testing.T) {
+	addr, err := syntax.ParseAddress("josé@bücher.de")
+	require.NoError(t, err)
+	assert.Equal(t, syntax.Address{LocalPart: "josé", Domain: "xn--bcher-kva.de"}, addr)
+
+	addr, err = syntax.ParseAddress(`"josé smith"@例え.jp`)
+	require.NoError(t, err)
+	assert.Equal(t, syntax.Address{LocalPart: `"josé smith"`, Domain: "xn--r8jz45g.jp", Quoted: true}, addr)
+
+	_, err = syntax.ParseAddress("user@ex\u200dample.com")
+	assert.ErrorIs(t, err, syntax.ErrInvalidIDN)
+}
+
+func TestCheckInternationalEmailAddress(t *testing.T) {
+	email, user, domain, err := syntax.CheckEmailAddress("José@Bücher.de")
+	require.NoError(t, err)
+	assert.Equal(t, "josé@xn--bcher-kva.de", email)
+	assert.Equal(t, "josé", user)
+	assert.Equal(t, "xn--bcher-kva.de", domain)
+	assert.Equal(t, "bücher.de", syntax.UnicodeDomain(domain))
+
+	// NFD input is composed, so both spellings are the same address
+	email, _, _, err = syntax.CheckEmailAddress("jose\u0301@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "josé@example.com", email)
+
+	_, _, _, err = syntax.CheckEmailAddress("jos\xe9@example.com")
+	assert.ErrorIs(t, err, syntax.ErrInvalidUTF8)
+
+	assert.True(t, syntax.RequiresSMTPUTF8("josé@example.com"))
+	assert.False(t, syntax.RequiresSMTPUTF8("jose@bücher.de"), "only the local part needs SMTPUTF8")
+}
@@ -70,40 +70,30 @@ func ParseRootAndSubdomain(input string) (string, string, error) {
 	return domain, subdomain, nil
 }
 
-func isValidDomain(domain string) bool {
-	// Check if domain starts or ends with a dot
-	if strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
-		return false
-	}
-
-	// Split the domain into its parts
+// checkDomain rejects hostnames that can't receive mail on the public
+// internet. The grammar has already been checked by ParseAddress.
+func checkDomain(domain string) error {
 	domainParts := strings.Split(domain, ".")
 
 	// A valid domain must have at least 2 parts
-	if len(domainParts) < 2 || len(domainParts) > 5 {
-		return false
+	if len(domainParts) < 2 {
+		return ErrUnknownTLD
 	}
-
-	// Check each part of the domain
-	for _, part := range domainParts {
-		if len(part) == 0 || len(part) > 63 {
-			return false
-		}
+	if len(domainParts) > 5 {
+		return ErrTooManyLabels
 	}
 
 	// Extract the TLD using the public suffix list
 	tld, _ := publicsuffix.PublicSuffix(domain)
-	if tld == "" {
-		return false
-	}
-
-	// Check if the extracted TLD is valid
-	if !isValidTLD(tld) {
-		return false
+	if tld == "" || !isValidTLD(tld) {
+		return ErrUnknownTLD
 	}
 
 	// Ensure the domain ends with the extracted TLD
-	return strings.HasSuffix(domain, "."+tld)
+	if !strings.HasSuffix(domain, "."+tld) {
+		return ErrUnknownTLD
+	}
+	return nil
 }
 
 func isValidTLD(tld string) bool {
@@ -3,59 +3,77 @@ package syntax
 import (
 	"fmt"
 	"strings"
-	"unicode"
+	"unicode/utf8"
 
-	"golang.org/x/text/runes"
-	"golang.org/x/text/transform"
+	"golang.org/x/net/idna"
 	"golang.org/x/text/unicode/norm"
 )
 
+// NormalizeEmailAddress validates email and returns its clean form. The
+// whole address is lowercased, local part included, as nearly all mailboxes
+// are case-insensitive. Servers may treat the local part as case-sensitive,
+// RFC 5321 section 2.4, so send to the address as given when that matters.
 func NormalizeEmailAddress(email string) (ok bool, cleanEmail, cleanUser, cleanDomain string) {
-	normalizedEmail := convertToAscii(email)
-
-	if !isValidEmailFormat(normalizedEmail) {
+	cleanEmail, cleanUser, cleanDomain, err := CheckEmailAddress(email)
+	if cleanEmail == "" {
 		return false, "", "", ""
 	}
+	return err == nil, cleanEmail, cleanUser, cleanDomain
+}
 
-	username, domain, ok := parseUserAndDomain(normalizedEmail)
-	if !ok {
-		return false, "", "", ""
+// CheckEmailAddress is NormalizeEmailAddress with the reason an address is
+// invalid. The clean parts are still returned when the address parses but
+// its domain isn't valid. UTF-8 local parts are kept, lowercased and
+// NFC-normalized, and internationalized domains are returned in their
+// ASCII form for DNS.
+func CheckEmailAddress(email string) (cleanEmail, cleanUser, cleanDomain string, err error) {
+	if strings.TrimSpace(email) != email {
+		return "", "", "", &SyntaxError{Err: ErrSurroundingSpace, Pos: -1}
+	}
+	if !utf8.ValidString(email) {
+		return "", "", "", &SyntaxError{Err: ErrInvalidUTF8, Pos: -1}
 	}
 
-	isValid := isValidUsername(username) && isValidDomain(domain)
-
-	if domain == "gmail.com" || domain == "googlemail.com" {
-		username = strings.ReplaceAll(username, ".", "")
-		domain = "gmail.com"
-		normalizedEmail = fmt.Sprintf("%s@%s", username, domain)
+	addr, err := ParseAddress(strings.ToLower(norm.NFC.String(email)))
+	if err != nil {
+		return "", "", "", err
 	}
 
-	return isValid, normalizedEmail, username, domain
-}
+	// Provider rules such as Gmail ignoring dots are left to the
+	// canonical package
+	username, domain := addr.LocalPart, addr.Domain
+	cleanEmail = fmt.Sprintf("%s@%s", username, domain)
 
-func isValidEmailFormat(email string) bool {
-	return strings.TrimSpace(email) == email && email != ""
+	if !addr.DomainLiteral {
+		err = checkDomain(domain)
+	}
+	return cleanEmail, username, domain, err
 }
 
-func parseUserAndDomain(email string) (string, string, bool) {
-	parts := strings.Split(email, "@")
-	if len(parts) != 2 {
-		return "", "", false
+// UnicodeDomain returns domain with its A-labels (xn--) decoded
+func UnicodeDomain(domain string) string {
+	unicode, err := idna.Lookup.ToUnicode(domain)
+	if err != nil {
+		return domain
 	}
-	return parts[0], parts[1], true
+	return unicode
 }
 
-func convertToAscii(input string) string {
-	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
-	result, _, _ := transform.String(t, input)
+// RequiresSMTPUTF8 reports whether the address has a non-ASCII local part,
+// which servers only accept with the SMTPUTF8 extension, RFC 6531
+func RequiresSMTPUTF8(email string) bool {
+	local := email
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		local = email[:at]
+	}
+	return !isASCII(local)
+}
 
-	// Step 2: Remove any remaining non-ASCII characters
-	ascii := make([]rune, 0, len(result))
-	for _, r := range result {
-		if r <= unicode.MaxASCII {
-			ascii = append(ascii, r)
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
 		}
 	}
-
-	return strings.ToLower(string(ascii))
+	return true
 }
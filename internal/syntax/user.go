@@ -3,12 +3,23 @@ package syntax
 import (
 	"regexp"
 	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
 
 	"github.com/customeros/mailsherpa/internal/util"
 )
 
 // IsSystemGeneratedUser checks if a given username is system-generated
 func IsSystemGeneratedUser(username string) bool {
+	// The heuristics are tuned for ASCII, so diacritics and symbols such
+	// as emoji are folded away first
+	username = foldToASCII(username)
+	// Spaces and commas only appear in quoted local parts, such as
+	// "john smith", where they separate words like dots do
+	username = strings.NewReplacer(" ", ".", ",", ".").Replace(username)
 	if username == "" {
 		return false
 	}
@@ -141,12 +152,16 @@ func isHyphenatedName(username string) bool {
 	return namePattern.MatchString(strings.ToLower(username))
 }
 
-// isValidUsername checks basic validation for allowed characters in email usernames
-func isValidUsername(username string) bool {
-	if len(username) > 64 {
-		return false
-	}
+// foldToASCII strips diacritics and drops any remaining non-ASCII runes
+func foldToASCII(input string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	result, _, _ := transform.String(t, input)
 
-	allowedChars := regexp.MustCompile(`^[a-zA-Z0-9.=_+!#$%&'*+/=?^_{|}~-]+$`)
-	return allowedChars.MatchString(username)
+	ascii := make([]rune, 0, len(result))
+	for _, r := range result {
+		if r <= unicode.MaxASCII {
+			ascii = append(ascii, r)
+		}
+	}
+	return string(ascii)
 }
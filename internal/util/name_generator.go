@@ -74,6 +74,18 @@ func GenerateCatchAllUsername() string {
 	return strings.ReplaceAll(name, "-", "")
 }
 
+// GenerateRandomString returns a random string of lowercase letters and digits
+func GenerateRandomString(length int) string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = alphabet[rng.Intn(len(alphabet))]
+	}
+	return string(b)
+}
+
 func GenerateSenderEmail() (string, string) {
 	firstname, lastname := GenerateNames()
 	fromDomain, exists := os.LookupEnv("MAIL_SERVER_DOMAIN")
@@ -0,0 +1,38 @@
+package mailvalidate
+
+import (
+	"github.com/customeros/mailsherpa/internal/syntax"
+)
+
+// ListedAddress is one mailbox of an address list, with the syntax check
+// of its address
+type ListedAddress struct {
+	DisplayName string
+	// Group the mailbox was listed in, e.g. "Sales" in "Sales: a@b.com;"
+	Group string `json:",omitempty"`
+	// The address as written in the list
+	Address string
+	Syntax  SyntaxValidation
+}
+
+// ParseAddressList splits an RFC 5322 address list, as found in mail
+// headers and CRM fields, and validates the syntax of each address. Use
+// Syntax.CleanEmail for the normalized address. Only a list that can't be
+// split, e.g. with an unterminated quote, returns an error.
+func ParseAddressList(list string) ([]ListedAddress, error) {
+	mailboxes, err := syntax.ParseAddressList(list)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]ListedAddress, 0, len(mailboxes))
+	for _, mailbox := range mailboxes {
+		addresses = append(addresses, ListedAddress{
+			DisplayName: mailbox.DisplayName,
+			Group:       mailbox.Group,
+			Address:     mailbox.AddrSpec,
+			Syntax:      ValidateEmailSyntax(mailbox.AddrSpec),
+		})
+	}
+	return addresses, nil
+}
@@ -0,0 +1,31 @@
+package mailvalidate_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/customeros/mailsherpa/mailvalidate"
+)
+
+func TestParseAddressList(t *testing.T) {
+	addresses, err := mailvalidate.ParseAddressList(`"Smith, John" <John.Smith@Acme.com>, Team: jane@acme.com, bad@;`)
+	require.NoError(t, err)
+	require.Len(t, addresses, 3)
+
+	assert.Equal(t, "Smith, John", addresses[0].DisplayName)
+	assert.Equal(t, "John.Smith@Acme.com", addresses[0].Address)
+	assert.True(t, addresses[0].Syntax.IsValid)
+	assert.Equal(t, "john.smith@acme.com", addresses[0].Syntax.CleanEmail)
+
+	assert.Equal(t, "Team", addresses[1].Group)
+	assert.True(t, addresses[1].Syntax.IsValid)
+
+	assert.Equal(t, "Team", addresses[2].Group)
+	assert.False(t, addresses[2].Syntax.IsValid)
+	assert.NotEmpty(t, addresses[2].Syntax.Error)
+
+	_, err = mailvalidate.ParseAddressList(`"Smith, John <john@acme.com>`)
+	assert.Error(t, err)
+}
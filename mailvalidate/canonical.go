@@ -0,0 +1,23 @@
+package mailvalidate
+
+import (
+	"github.com/customeros/mailsherpa/domaincheck"
+	"github.com/customeros/mailsherpa/internal/canonical"
+)
+
+// CanonicalAddress is an address in its canonical form, for deduplication,
+// and its deliverable form, for sending
+type CanonicalAddress = canonical.Address
+
+// CanonicalizeEmail applies the provider's addressing rules, such as
+// Gmail ignoring dots and dropping +tags. The provider is detected from
+// the MX records in dns, so custom domains hosted on Google Workspace or
+// Fastmail get their rules too. Without dns only the literal domain is
+// recognised.
+func CanonicalizeEmail(email string, dns *domaincheck.DNS) (CanonicalAddress, error) {
+	var provider string
+	if dns != nil {
+		provider = providerFromMx(*dns)
+	}
+	return canonical.Canonicalize(email, provider)
+}
@@ -0,0 +1,32 @@
+package mailvalidate_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/customeros/mailsherpa/domaincheck"
+	"github.com/customeros/mailsherpa/mailvalidate"
+)
+
+func TestCanonicalizeEmail(t *testing.T) {
+	// A custom domain on Google Workspace gets Gmail's rules
+	dns := domaincheck.DNS{MX: []string{"aspmx.l.google.com", "alt1.aspmx.l.google.com"}}
+	address, err := mailvalidate.CanonicalizeEmail("Jane.Smith+crm@acme.com", &dns)
+	require.NoError(t, err)
+	assert.Equal(t, "janesmith@acme.com", address.Canonical)
+	assert.Equal(t, "jane.smith+crm@acme.com", address.Deliverable)
+	assert.Equal(t, "google workspace", address.Provider)
+
+	// Without MX only the literal domain is recognised
+	address, err = mailvalidate.CanonicalizeEmail("Jane.Smith+crm@acme.com", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "jane.smith+crm@acme.com", address.Canonical)
+	assert.Equal(t, "Jane.Smith+crm@acme.com", address.Deliverable)
+	assert.Empty(t, address.Provider)
+
+	address, err = mailvalidate.CanonicalizeEmail("john.doe+news@gmail.com", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "johndoe@gmail.com", address.Canonical)
+}
@@ -0,0 +1,175 @@
+package mailvalidate
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/customeros/mailsherpa/internal/mailserver"
+	"github.com/customeros/mailsherpa/internal/util"
+)
+
+// Local parts at the RFC 5321 limit. Nobody picks a mailbox name that
+// long, so a server accepting one isn't looking recipients up at all
+const longDecoyLength = 64
+
+type CatchAllConfig struct {
+	// Decoys of each kind sent after the CatchAllTestUser codename, which
+	// is always sent, in the same session
+	RandomProbes int
+	NameProbes   int
+	LongProbes   int
+	// Confidence at or above which the domain is reported as catch-all
+	Threshold float64
+}
+
+func DefaultCatchAllConfig() CatchAllConfig {
+	return CatchAllConfig{
+		RandomProbes: 1,
+		NameProbes:   1,
+		LongProbes:   1,
+		Threshold:    0.6,
+	}
+}
+
+// CatchAllProbe is a decoy recipient and the server's reply to it
+type CatchAllProbe struct {
+	Email string
+	// codename, random, name or long
+	Kind         string
+	ResponseCode string
+	Description  string
+	LatencyMs    int64
+	// Same reply code, text and similar latency as the target, or as the
+	// codename when the target isn't probed
+	MatchesTarget bool
+}
+
+// CatchAll is how sure we are the domain accepts mail for any address
+type CatchAll struct {
+	IsCatchAll bool
+	// Between 0 (validates recipients) and 1 (accepts anything)
+	Confidence float64
+	// Everything was accepted without a real lookup, so mail to unknown
+	// addresses is likely to bounce after the fact
+	AcceptThenBounceSuspected bool
+	Probes                    []CatchAllProbe
+	// Whether the target likely exists despite the catch-all, when
+	// TimingInference is enabled
+	Timing *TimingSignal `json:",omitempty"`
+}
+
+type catchAllDecoy struct {
+	email string
+	kind  string
+}
+
+func catchAllDecoys(req *EmailValidationRequest, domain string) []catchAllDecoy {
+	config := catchAllConfig(req)
+
+	decoys := []catchAllDecoy{{fmt.Sprintf("%s@%s", req.CatchAllTestUser, domain), "codename"}}
+	for i := 0; i < config.RandomProbes; i++ {
+		decoys = append(decoys, catchAllDecoy{fmt.Sprintf("%s@%s", util.GenerateRandomString(12), domain), "random"})
+	}
+	for i := 0; i < config.NameProbes; i++ {
+		firstName, lastName := util.GenerateNames()
+		localPart := fmt.Sprintf("%s.%s%s", firstName, lastName, util.GenerateRandomString(3))
+		decoys = append(decoys, catchAllDecoy{fmt.Sprintf("%s@%s", localPart, domain), "name"})
+	}
+	for i := 0; i < config.LongProbes; i++ {
+		decoys = append(decoys, catchAllDecoy{fmt.Sprintf("%s@%s", util.GenerateRandomString(longDecoyLength), domain), "long"})
+	}
+	return decoys
+}
+
+func catchAllConfig(req *EmailValidationRequest) CatchAllConfig {
+	if req.CatchAll != nil {
+		return *req.CatchAll
+	}
+	return DefaultCatchAllConfig()
+}
+
+// analyzeCatchAll compares the decoys' replies with the target's, or with
+// the codename's when the target isn't probed. Accepted decoys that the
+// server answers exactly like the target make a catch-all more likely.
+// Temporary failures and rejected long decoys say nothing either way. A
+// rejected target rules a catch-all out, as the server tells addresses
+// apart whatever the decoys got.
+func analyzeCatchAll(target mailserver.RcptReply, probes []CatchAllProbe, fingerprint *MtaFingerprint, threshold float64) CatchAll {
+	result := CatchAll{Probes: probes}
+
+	conclusive, accepted, matching := 0, 0, 0
+	longAccepted := false
+	for i := range probes {
+		probe := &probes[i]
+		probe.MatchesTarget = repliesMatch(target, *probe)
+
+		isAccepted := strings.HasPrefix(probe.ResponseCode, "2")
+		isRejected := strings.HasPrefix(probe.ResponseCode, "5")
+		if !isAccepted && (!isRejected || probe.Kind == "long") {
+			continue
+		}
+
+		conclusive++
+		if isAccepted {
+			accepted++
+			longAccepted = longAccepted || probe.Kind == "long"
+			if probe.MatchesTarget {
+				matching++
+			}
+		}
+	}
+	if conclusive == 0 || strings.HasPrefix(target.ResponseCode, "5") {
+		return result
+	}
+
+	// Accepted decoys answered differently from the target hint that the
+	// server can tell addresses apart after all
+	acceptRatio := float64(accepted) / float64(conclusive)
+	similarity := 0.0
+	if accepted > 0 {
+		similarity = float64(matching) / float64(accepted)
+	}
+	result.Confidence = math.Round(acceptRatio*(0.7+0.3*similarity)*100) / 100
+	result.IsCatchAll = result.Confidence >= threshold
+
+	if accepted == conclusive {
+		lowReliability := fingerprint != nil && fingerprint.Reliability == "low"
+		result.AcceptThenBounceSuspected = longAccepted || lowReliability
+	}
+	return result
+}
+
+// repliesMatch reports whether the server answered probe the way it
+// answered the target: same code, same text once addresses and queue IDs
+// are masked, and a latency within a factor of two
+func repliesMatch(target mailserver.RcptReply, probe CatchAllProbe) bool {
+	if target.ResponseCode != probe.ResponseCode {
+		return false
+	}
+	if normalizeReply(target.Description, target.Email) != normalizeReply(probe.Description, probe.Email) {
+		return false
+	}
+
+	slower, faster := target.LatencyMs, probe.LatencyMs
+	if faster > slower {
+		slower, faster = faster, slower
+	}
+	// Differences under 100ms are network noise
+	return slower-faster < 100 || slower <= 2*faster
+}
+
+var replyIDPattern = regexp.MustCompile(`[0-9a-f]{8,}|\d+`)
+
+func normalizeReply(description, email string) string {
+	reply := strings.ToLower(description)
+	if email != "" {
+		email = strings.ToLower(email)
+		reply = strings.ReplaceAll(reply, email, "<rcpt>")
+		if at := strings.LastIndex(email, "@"); at > 0 {
+			reply = strings.ReplaceAll(reply, email[:at], "<user>")
+		}
+	}
+	return replyIDPattern.ReplaceAllString(reply, "#")
+}
@@ -0,0 +1,219 @@
+package mailvalidate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/customeros/mailsherpa/domaincheck"
+	"github.com/customeros/mailsherpa/internal/mailserver"
+)
+
+func TestAnalyzeCatchAll(t *testing.T) {
+	target := mailserver.RcptReply{Email: "john@acme.com", ResponseCode: "250", Description: "Ok", LatencyMs: 40}
+	accepted := func(email, kind string) CatchAllProbe {
+		return CatchAllProbe{Email: email, Kind: kind, ResponseCode: "250", Description: "Ok", LatencyMs: 45}
+	}
+	rejected := func(email, kind string) CatchAllProbe {
+		return CatchAllProbe{Email: email, Kind: kind, ResponseCode: "550", Description: "5.1.1 <" + email + ">: User unknown", LatencyMs: 45}
+	}
+
+	tests := []struct {
+		name             string
+		target           mailserver.RcptReply
+		probes           []CatchAllProbe
+		fingerprint      *MtaFingerprint
+		isCatchAll       bool
+		confidence       float64
+		acceptThenBounce bool
+	}{
+		{
+			name:   "should detect accept-all with matching replies",
+			target: target,
+			probes: []CatchAllProbe{
+				accepted("bravehawk@acme.com", "codename"),
+				accepted("k3j9x0aq2m1z@acme.com", "random"),
+				accepted("olivia.rossx7q@acme.com", "name"),
+			},
+			isCatchAll: true,
+			confidence: 1,
+		},
+		{
+			name:   "should detect a validating server",
+			target: target,
+			probes: []CatchAllProbe{
+				rejected("bravehawk@acme.com", "codename"),
+				rejected("k3j9x0aq2m1z@acme.com", "random"),
+				rejected(strings.Repeat("a", 72)+"@acme.com", "long"),
+			},
+			confidence: 0,
+		},
+		{
+			name:   "should lower confidence for mixed answers",
+			target: target,
+			probes: []CatchAllProbe{
+				accepted("bravehawk@acme.com", "codename"),
+				rejected("k3j9x0aq2m1z@acme.com", "random"),
+				{Email: "olivia.rossx7q@acme.com", Kind: "name", ResponseCode: "451", Description: "Try again later"},
+			},
+			confidence: 0.5,
+		},
+		{
+			name:   "should suspect accept-then-bounce when an over-long address is accepted",
+			target: target,
+			probes: []CatchAllProbe{
+				accepted("bravehawk@acme.com", "codename"),
+				accepted(strings.Repeat("a", 72)+"@acme.com", "long"),
+			},
+			isCatchAll:       true,
+			confidence:       1,
+			acceptThenBounce: true,
+		},
+		{
+			name:   "should suspect accept-then-bounce behind a low reliability MTA",
+			target: mailserver.RcptReply{Email: "john@acme.com", ResponseCode: "250", Description: "Recipient OK", LatencyMs: 900},
+			probes: []CatchAllProbe{
+				accepted("bravehawk@acme.com", "codename"),
+			},
+			fingerprint:      &MtaFingerprint{Software: "Microsoft Exchange", Reliability: "low"},
+			isCatchAll:       true,
+			confidence:       0.7,
+			acceptThenBounce: true,
+		},
+		{
+			name:   "should not flag a server that rejects the target but accepts decoys",
+			target: mailserver.RcptReply{Email: "john@acme.com", ResponseCode: "550", Description: "5.1.1 User unknown", LatencyMs: 40},
+			probes: []CatchAllProbe{
+				accepted("bravehawk@acme.com", "codename"),
+				accepted("k3j9x0aq2m1z@acme.com", "random"),
+				accepted("olivia.rossx7q@acme.com", "name"),
+			},
+			confidence: 0,
+		},
+		{
+			name:   "should stay inconclusive without answers",
+			target: target,
+			probes: []CatchAllProbe{
+				{Email: "bravehawk@acme.com", Kind: "codename", ResponseCode: "450", Description: "Greylisted"},
+			},
+			confidence: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := analyzeCatchAll(tt.target, tt.probes, tt.fingerprint, 0.6)
+
+			assert.Equal(t, tt.isCatchAll, result.IsCatchAll, "IsCatchAll mismatch")
+			assert.Equal(t, tt.confidence, result.Confidence, "Confidence mismatch")
+			assert.Equal(t, tt.acceptThenBounce, result.AcceptThenBounceSuspected, "AcceptThenBounceSuspected mismatch")
+		})
+	}
+}
+
+func TestRepliesMatchMasksAddressesAndIDs(t *testing.T) {
+	target := mailserver.RcptReply{Email: "john@acme.com", ResponseCode: "550", Description: "5.1.1 <john@acme.com>: Recipient rejected [id 8f3a2b1c9d]", LatencyMs: 300}
+	probe := CatchAllProbe{Email: "zqx81k@acme.com", ResponseCode: "550", Description: "5.1.1 <zqx81k@acme.com>: Recipient rejected [id 0a9b8c7d6e]", LatencyMs: 500}
+	assert.True(t, repliesMatch(target, probe))
+
+	probe.LatencyMs = 3000
+	assert.False(t, repliesMatch(target, probe), "a much slower reply suggests a different code path")
+}
+
+func TestCatchAllDecoys(t *testing.T) {
+	req := &EmailValidationRequest{CatchAllTestUser: "bravehawk", CatchAll: &CatchAllConfig{RandomProbes: 2, NameProbes: 1, LongProbes: 1}}
+	decoys := catchAllDecoys(req, "acme.com")
+
+	require.Len(t, decoys, 5)
+	assert.Equal(t, "bravehawk@acme.com", decoys[0].email)
+	kinds := make([]string, len(decoys))
+	for i, decoy := range decoys {
+		kinds[i] = decoy.kind
+		assert.True(t, strings.HasSuffix(decoy.email, "@acme.com"))
+	}
+	assert.Equal(t, []string{"codename", "random", "random", "name", "long"}, kinds)
+	assert.Len(t, strings.TrimSuffix(decoys[4].email, "@acme.com"), longDecoyLength)
+}
+
+func TestCatchAllTestProbesOnlyDecoys(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", nil)
+	dns := server.DNS()
+
+	_, targetResponse, catchAll := catchAllTest(&EmailValidationRequest{
+		Email:            "john@acme.com",
+		FromDomain:       "probe.example",
+		ServerIP:         "203.0.113.7",
+		CatchAllTestUser: "bravehawk",
+		Dns:              &dns,
+	})
+
+	for _, cmd := range server.Received() {
+		assert.NotContains(t, cmd, "john@acme.com", "the real mailbox is left alone")
+	}
+	require.NotEmpty(t, catchAll.Probes)
+	assert.Equal(t, "codename", catchAll.Probes[0].Kind)
+	assert.Equal(t, "bravehawk@acme.com", catchAll.Probes[0].Email)
+	assert.True(t, catchAll.IsCatchAll)
+	assert.Empty(t, targetResponse.ResponseCode)
+}
+
+func TestCatchAllTestProbesTargetForTiming(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", nil)
+	dns := server.DNS()
+	config := TimingInferenceConfig{Rounds: 1}
+
+	_, targetResponse, _ := catchAllTest(&EmailValidationRequest{
+		Email:            "john@acme.com",
+		FromDomain:       "probe.example",
+		ServerIP:         "203.0.113.7",
+		CatchAllTestUser: "bravehawk",
+		TimingInference:  &config,
+		Dns:              &dns,
+	})
+
+	assert.Contains(t, server.Received(), "RCPT TO:<john@acme.com>")
+	assert.Equal(t, "250", targetResponse.ResponseCode)
+}
+
+func TestCatchAllTestRecordsTranscript(t *testing.T) {
+	server := startFakeServer(t, "220 mx.acme.com ESMTP", nil)
+	dns := server.DNS()
+
+	results, _, _ := catchAllTest(&EmailValidationRequest{
+		Email:            "john@acme.com",
+		FromDomain:       "probe.example",
+		ServerIP:         "203.0.113.7",
+		CatchAllTestUser: "bravehawk",
+		Transcript:       TranscriptOptions{Enabled: true},
+		Dns:              &dns,
+	})
+
+	require.NotNil(t, results.Transcript)
+	var rcpts []string
+	for _, entry := range results.Transcript.Entries {
+		if strings.HasPrefix(entry.Command, "RCPT TO") {
+			rcpts = append(rcpts, entry.Command)
+		}
+	}
+	assert.NotEmpty(t, rcpts)
+}
+
+func TestCatchAllTestLearnsAcceptAllBackup(t *testing.T) {
+	backup := startFakeServer(t, "220 mx2.acme.com ESMTP", nil)
+	failover := &FailoverOptions{Learned: NewAcceptAllBackups()}
+
+	catchAllTest(&EmailValidationRequest{
+		Email:            "john@acme.com",
+		FromDomain:       "probe.example",
+		ServerIP:         "203.0.113.7",
+		CatchAllTestUser: "bravehawk",
+		Failover:         failover,
+		// Nothing listens on 127.0.0.3, so the backup answers
+		Dns: &domaincheck.DNS{MX: []string{"127.0.0.3", backup.IP}},
+	})
+
+	assert.Equal(t, "accepted the catch-all decoys", failover.Learned.Reason(backup.IP))
+	assert.Empty(t, failover.Learned.Reason("127.0.0.3"), "the primary is always probed")
+}
@@ -2,8 +2,10 @@ package mailvalidate
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/customeros/mailsherpa/domaincheck"
+	"github.com/customeros/mailsherpa/internal/disposable"
 	"github.com/customeros/mailsherpa/internal/email_providers"
 	"github.com/customeros/mailsherpa/internal/free_emails"
 	"github.com/customeros/mailsherpa/internal/syntax"
@@ -22,18 +24,39 @@ type DomainValidation struct {
 	AuthorizedSenders     emailproviders.AuthorizedSenders
 
 	// Domain flags
-	IsFirewalled    bool
+	IsFirewalled bool
+	// Set when CatchAll.Confidence reaches the configured threshold, not
+	// merely when the CatchAllTestUser codename gets a 250. Only decoys are
+	// probed, unless TimingInference asks for RCPT TO of Email too
 	IsCatchAll      bool
 	IsPrimaryDomain bool
 	HasMXRecord     bool
 	HasSPFRecord    bool
+	// The domain is a throwaway mailbox service, for the reasons in
+	// DisposableReasons. Reasons are only given with the verdict
+	IsDisposable      bool
+	DisposableReasons []string `json:",omitempty"`
 
 	// Domain details
 	PrimaryDomain string
 
-	// Server responses
-	SmtpResponse     SmtpResponse
-	MailServerHealth MailServerHealth
+	// Server responses. SmtpResponse is the reply to the CatchAllTestUser
+	// decoy, TargetSmtpResponse the reply to the validated address when
+	// TimingInference has it probed in the same session
+	SmtpResponse       SmtpResponse
+	TargetSmtpResponse SmtpResponse
+	MailServerHealth   MailServerHealth
+	// How the domain answered decoy recipients
+	CatchAll CatchAll
+	// MTA software of the primary MX. Verdicts from MTAs with low
+	// reliability, e.g. Exchange without recipient filtering, are weaker.
+	// Taken from the catch-all probe, so nil for free email domains, which
+	// aren't probed. EmailValidation.MtaFingerprint has it for those
+	MtaFingerprint *MtaFingerprint
+	// MTA-STS and TLS-RPT records, when CheckMailSecurity is requested
+	MailSecurity domaincheck.MailSecurity
+	// SMTP conversation of the catch-all probe, when Transcript is enabled
+	Transcript *Transcript `json:",omitempty"`
 
 	// Error information
 	Error string
@@ -72,8 +95,17 @@ func validateDomainWithKnownProviders(validationRequest EmailValidationRequest,
 		validationRequest.Dns = &dns
 	}
 
+	// Pick the sender identity to probe from
+	if err := assignSenderIdentity(&validationRequest); err != nil {
+		results.Error = err.Error()
+		return results
+	}
+
 	// Evaluate DNS records and get provider information
 	evaluateDnsRecords(&validationRequest, &knownProviders, &results)
+	if results.HasMXRecord && validationRequest.CheckMailSecurity {
+		results.MailSecurity = domaincheck.CheckMailSecurity(domain, validationRequest.Dns.MX)
+	}
 
 	// Check if it's a primary domain
 	results.IsPrimaryDomain, results.PrimaryDomain = domaincheck.PrimaryDomainCheck(domain)
@@ -85,12 +117,23 @@ func validateDomainWithKnownProviders(validationRequest EmailValidationRequest,
 		return results
 	}
 
-	// Only perform catch-all test for non-free email domains
+	if err := checkDisposable(&validationRequest, domain, &results); err != nil {
+		results.Error = fmt.Sprintf("Error running disposable email check: %v", err)
+		return results
+	}
+
+	// Only perform catch-all test for non-free email domains. Free email
+	// domains get no MtaFingerprint either, as nothing else connects
 	if !isFreeEmail {
-		if catchAllResults := catchAllTest(&validationRequest); catchAllResults.IsDeliverable == "true" {
+		catchAllResults, targetResponse, catchAll := catchAllTest(&validationRequest)
+		results.MtaFingerprint = catchAllResults.MtaFingerprint
+		results.CatchAll = catchAll
+		results.Transcript = catchAllResults.Transcript
+		if catchAll.IsCatchAll {
 			results.IsCatchAll = true
 			results.MailServerHealth = catchAllResults.MailServerHealth
 			results.SmtpResponse = catchAllResults.SmtpResponse
+			results.TargetSmtpResponse = targetResponse
 		}
 	}
 
@@ -129,6 +172,33 @@ func evaluateDnsRecords(validationRequest *EmailValidationRequest, knownProvider
 	}
 }
 
+// checkDisposable gathers the signals that the domain is a throwaway
+// mailbox service
+func checkDisposable(validationRequest *EmailValidationRequest, domain string, results *DomainValidation) error {
+	var signals disposable.Signals
+	var err error
+
+	if signals.Listed, err = disposable.IsDisposableDomain(domain); err != nil {
+		return err
+	}
+	if signals.DisposableMX, err = disposable.IsDisposableMX(validationRequest.Dns.MX); err != nil {
+		return err
+	}
+	// Wildcard MX only counts on a new domain, so only probe for it then
+	if validationRequest.NewDomainAge > 0 {
+		if registered, err := domaincheck.DomainRegistrationDate(domain); err == nil {
+			signals.NewDomain = time.Since(registered) < validationRequest.NewDomainAge
+		}
+	}
+	if signals.NewDomain && results.HasMXRecord {
+		signals.WildcardMX = domaincheck.HasWildcardMX(domain)
+	}
+
+	results.IsDisposable = signals.IsDisposable()
+	results.DisposableReasons = signals.Reasons()
+	return nil
+}
+
 // determineProvider selects the most appropriate provider from authorized senders
 func determineProvider(senders emailproviders.AuthorizedSenders) string {
 	if len(senders.Enterprise) > 0 {
@@ -1,20 +1,28 @@
 package mailvalidate
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/rdegges/go-ipify"
-
 	"github.com/customeros/mailsherpa/domaincheck"
+	"github.com/customeros/mailsherpa/internal/breaker"
+	"github.com/customeros/mailsherpa/internal/email_providers"
 	"github.com/customeros/mailsherpa/internal/free_emails"
 	"github.com/customeros/mailsherpa/internal/mailserver"
+	"github.com/customeros/mailsherpa/internal/publicip"
 	"github.com/customeros/mailsherpa/internal/role_accounts"
+	"github.com/customeros/mailsherpa/internal/scheduler"
 	"github.com/customeros/mailsherpa/internal/syntax"
 )
 
+// Port MX hosts are probed on, the default when empty. Overridden in tests
+var smtpPort string
+
 // Explicitly handled SMTP response codes
 const (
 	deliverableEmailCodes = "250, 251"
@@ -35,15 +43,61 @@ type EmailValidation struct {
 	SmtpResponse     SmtpResponse
 	MailServerHealth MailServerHealth
 	AlternateEmail   AlternateEmail
-	Error            string
+	// The address under its provider's rules, for deduplication and sending
+	Canonical *CanonicalAddress `json:",omitempty"`
+	// Set when IsDeliverable was decided by something other than the
+	// RCPT TO reply, e.g. ReasonVrfyExists
+	ReasonCode     string          `json:",omitempty"`
+	Vrfy           *VrfyResult     `json:",omitempty"`
+	MtaFingerprint *MtaFingerprint `json:",omitempty"`
+	// DANE check of the MX host, when Dane is set on the request
+	Dane       *DaneResult `json:",omitempty"`
+	Transcript *Transcript `json:",omitempty"`
+	Error      string
 }
 
+// MtaFingerprint identifies the MTA software behind the MX host
+type MtaFingerprint = mailserver.MtaFingerprint
+
+// DaneResult is how the MX host's certificate fared against its TLSA records
+type DaneResult = mailserver.DaneResult
+
+// VrfyResult is the server's answer to VRFY or EXPN
+type VrfyResult = mailserver.VrfyResult
+
+// Values of EmailValidation.ReasonCode
+const (
+	ReasonVrfyExists    = "vrfy_exists"
+	ReasonVrfyNotExists = "vrfy_not_exists"
+	ReasonExpnExists    = "expn_exists"
+	ReasonExpnNotExists = "expn_not_exists"
+	// IsDeliverable is unknown because the server tarpitted the probe
+	ReasonTarpit = "tarpit"
+	// IsDeliverable is unknown because the address has a UTF-8 local part
+	// and the server doesn't support SMTPUTF8
+	ReasonSmtpUtf8Unsupported = "smtputf8_unsupported"
+	// IsDeliverable is unknown because the MX host's TLSA lookup failed, so
+	// it wasn't probed rather than risk a downgrade to plaintext
+	ReasonDaneIndeterminate = "dane_indeterminate"
+)
+
+// Transcript is the recorded SMTP conversation of a validation
+type Transcript = mailserver.Transcript
+
+// TranscriptOptions enables transcripts and controls their redaction
+type TranscriptOptions = mailserver.TranscriptOptions
+
 type MailServerHealth struct {
-	IsGreylisted  bool
-	IsBlacklisted bool
-	ServerIP      string
-	FromEmail     string
-	RetryAfter    int
+	IsGreylisted   bool
+	IsBlacklisted  bool
+	ServerIP       string
+	FromEmail      string
+	SenderIdentity string
+	RetryAfter     int
+	CircuitState   string
+	// The server deliberately slowed the probe down, and how
+	IsTarpitted  bool
+	TarpitReason string
 }
 
 type SmtpResponse struct {
@@ -52,6 +106,27 @@ type SmtpResponse struct {
 	ResponseCode   string
 	ErrorCode      string
 	Description    string
+	MxHost         string
+	MxAttempts     []MxAttempt
+	// Reverse-path mode of the MAIL FROM that produced the verdict
+	SenderMode SenderMode
+	Latency    PhaseLatency
+}
+
+// PhaseLatency is how long each step of the SMTP conversation took
+type PhaseLatency = mailserver.PhaseLatency
+
+// MxAttempt is the outcome of probing one MX host
+type MxAttempt = mailserver.MxAttempt
+
+// FailoverOptions controls when lower-preference MX hosts are tried
+type FailoverOptions = mailserver.FailoverOptions
+
+// AcceptAllBackups collects backup MX hosts found to accept all mail
+type AcceptAllBackups = mailserver.AcceptAllBackups
+
+func NewAcceptAllBackups() *AcceptAllBackups {
+	return mailserver.NewAcceptAllBackups()
 }
 
 // ValidateEmail performs the main email validation
@@ -70,6 +145,12 @@ func ValidateEmail(validationRequest EmailValidationRequest) EmailValidation {
 		return results
 	}
 
+	// Pick the sender identity to probe from
+	if err := assignSenderIdentity(&validationRequest); err != nil {
+		results.Error = err.Error()
+		return results
+	}
+
 	// Perform email checks
 	if err := performEmailChecks(&validationRequest, &results); err != nil {
 		results.Error = err.Error()
@@ -81,6 +162,13 @@ func ValidateEmail(validationRequest EmailValidationRequest) EmailValidation {
 		handleAlternateEmail(&validationRequest, &results)
 	}
 
+	// Queue greylisted emails for a retry once the greylisting window passes
+	if validationRequest.RetryQueue != nil {
+		if _, err := validationRequest.RetryQueue.Enqueue(validationRequest, results); err != nil {
+			log.Printf("Error queueing greylist retry: %v", err)
+		}
+	}
+
 	return results
 }
 
@@ -117,32 +205,317 @@ func performEmailChecks(req *EmailValidationRequest, results *EmailValidation) e
 		results.IsRoleAccount = isRole
 	}
 
+	if address, err := CanonicalizeEmail(req.Email, req.Dns); err == nil {
+		results.Canonical = &address
+	}
+
 	// Perform SMTP validation
-	smtpValidation := performSMTPValidation(req)
+	smtpValidation, err := performSMTPValidation(req)
+	if err != nil {
+		results.RetryValidation = true
+		results.SmtpResponse.Description = err.Error()
+		results.MailServerHealth.CircuitState = circuitState(req, "")
+		return nil
+	}
 	updateSMTPResults(results, smtpValidation)
+	results.MailServerHealth.ServerIP = resolveServerIP(req, smtpValidation.LocalIP)
+	results.MailServerHealth.SenderIdentity = senderIdentityName(req)
 
 	handleSmtpResponses(req, results)
+	handleVrfyResult(results, smtpValidation.Vrfy)
+	handleConnectionDropped(results, smtpValidation.ConnectionDropped)
+	handleTarpit(results)
+	handleSmtpUtf8(results, smtpValidation.SmtpUtf8Unsupported)
+	handleDaneIndeterminate(results)
+	results.MailServerHealth.CircuitState = circuitState(req, smtpValidation.MxHost)
 
 	return nil
 }
 
-func performSMTPValidation(req *EmailValidationRequest) mailserver.SMPTValidation {
-	return mailserver.VerifyEmailAddress(
-		req.Email,
-		req.FromDomain,
-		req.FromEmail,
-		*req.Dns,
-	)
+func performSMTPValidation(req *EmailValidationRequest) (mailserver.SMPTValidation, error) {
+	var probed bool
+	var refused error
+
+	// Each MX host sticks to its own identity from the pool
+	identities := make(map[string]SenderIdentity)
+	senders := make(map[string]mailserver.Sender)
+	var senderFor func(string) (mailserver.Sender, error)
+	if req.senderPerHost && req.SenderPool != nil {
+		senderFor = func(host string) (mailserver.Sender, error) {
+			identity, err := req.SenderPool.Pick(host)
+			if err != nil {
+				refused = err
+				return mailserver.Sender{}, err
+			}
+			identities[host] = identity
+			senders[host] = hostSender(req, identity)
+			return senders[host], nil
+		}
+	}
+
+	admit := func(host string) (func(mailserver.SMPTValidation), error) {
+		sender := senderIdentityName(req)
+		if identity, ok := identities[host]; ok {
+			sender = identity.String()
+		}
+		done, err := admitHost(req, host, sender)
+		if err != nil {
+			refused = err
+			return nil, err
+		}
+		probed = true
+		return done, nil
+	}
+
+	smtpValidation := mailserver.Verify(mailserver.VerifyRequest{
+		Email:                 req.Email,
+		FromDomain:            req.FromDomain,
+		FromEmail:             req.FromEmail,
+		BindIP:                req.SenderIdentity.BindIP,
+		Port:                  smtpPort,
+		HeloName:              req.SenderIdentity.HeloName,
+		SenderMode:            senderMode(req),
+		DisableSenderFallback: req.DisableSenderFallback,
+		TryVrfy:               req.TryVrfy,
+		TryUnadvertisedVrfy:   req.TryUnadvertisedVrfy,
+		Fingerprint:           req.Fingerprint,
+		Transcript:            req.Transcript,
+		Latency:               req.LatencyTracker,
+		Failover:              req.Failover,
+		SenderFor:             senderFor,
+		Admit:                 admit,
+		Decoys:                req.decoys,
+		TimingRounds:          req.timingRounds,
+		Dane:                  req.Dane,
+		Dns:                   *req.Dns,
+	})
+
+	// Every host was rate limited or had its circuit open
+	if !probed && refused != nil {
+		return mailserver.SMPTValidation{}, refused
+	}
+
+	// Report, blacklist and retry the identity the answer was given to
+	if identity, ok := identities[smtpValidation.MxHost]; ok {
+		req.SenderIdentity = identity
+		req.FromDomain = identity.FromDomain
+		req.FromEmail = senders[smtpValidation.MxHost].FromEmail
+	}
+	return smtpValidation, nil
+}
+
+// admitHost checks the circuit breaker and waits for the scheduler before
+// an MX host is dialled from sender. The returned func releases the
+// scheduler ticket and records the host's result in its circuit.
+func admitHost(req *EmailValidationRequest, host, sender string) (func(mailserver.SMPTValidation), error) {
+	var probe *breaker.Probe
+	if req.CircuitBreaker != nil {
+		var err error
+		if probe, err = req.CircuitBreaker.Admit(circuitKey(req, host)); err != nil {
+			return nil, err
+		}
+	}
+
+	ticket, err := acquireSchedulerTicket(req, host, sender)
+	if err != nil {
+		if probe != nil {
+			probe.Cancel()
+		}
+		return nil, err
+	}
+
+	return func(result mailserver.SMPTValidation) {
+		if ticket != nil {
+			ticket.Release(result.ResponseCode, result.ErrorCode)
+		}
+		recordCircuitOutcome(probe, result)
+	}, nil
+}
+
+// Longest a validation waits for the scheduler, whatever its MaxWait
+const maxSchedulerWait = time.Minute
+
+// acquireSchedulerTicket waits for the scheduler to allow a probe against
+// an MX host from sender
+func acquireSchedulerTicket(req *EmailValidationRequest, host, sender string) (*scheduler.Ticket, error) {
+	if req.Scheduler == nil {
+		return nil, nil
+	}
+
+	keys := scheduler.Keys{
+		MXHost:   host,
+		Provider: providerFromMx(*req.Dns),
+		Sender:   sender,
+	}
+	if keys.Sender == "" {
+		keys.Sender = req.FromDomain
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), maxSchedulerWait)
+	defer cancel()
+	ticket, err := req.Scheduler.Acquire(ctx, keys)
+	if err != nil {
+		return nil, fmt.Errorf("Rate limited: %v", err)
+	}
+	return ticket, nil
+}
+
+func circuitKey(req *EmailValidationRequest, host string) string {
+	return req.CircuitBreaker.Key(host, providerFromMx(*req.Dns))
+}
+
+// circuitState reports the circuit of the host that answered, or of the
+// primary MX when no host did
+func circuitState(req *EmailValidationRequest, host string) string {
+	if req.CircuitBreaker == nil || len(req.Dns.MX) == 0 {
+		return ""
+	}
+	if host == "" {
+		host = req.Dns.MX[0]
+	}
+	return string(req.CircuitBreaker.State(circuitKey(req, host)))
+}
+
+// recordCircuitOutcome feeds a host's probe result into the circuit
+// breaker. Blacklisting, 421s, timeouts and connections that fail count
+// against the host. Answers that say nothing about its health, e.g. a
+// refused sender, a DANE failure or a missing SMTPUTF8, leave the circuit
+// as it is, and any other answer closes it.
+func recordCircuitOutcome(probe *breaker.Probe, result mailserver.SMPTValidation) {
+	if probe == nil {
+		return
+	}
+
+	switch {
+	case isBlacklistReply(result.ResponseCode, result.Description):
+		probe.Failure(fmt.Sprintf("blacklisted: %s", result.Description))
+	case result.ResponseCode == "421":
+		probe.Failure(result.Description)
+	case isDaneFailure(result.Dane), result.SmtpUtf8Unsupported:
+		probe.Cancel()
+	case !result.CanConnectSmtp && result.ResponseCode == "":
+		reason := result.Description
+		if reason == "" {
+			reason = "connection failed"
+		}
+		probe.Failure(reason)
+	case !result.CanConnectSmtp:
+		// HELO, MAIL FROM or the greeting refused us with a reply
+		probe.Cancel()
+	default:
+		probe.Success()
+	}
+}
+
+// isDaneFailure reports a certificate that didn't match, or couldn't be
+// checked, which is no sign of an unhealthy host
+func isDaneFailure(dane *DaneResult) bool {
+	if dane == nil {
+		return false
+	}
+	return dane.Status == mailserver.DaneMismatch || dane.Status == mailserver.DaneIndeterminate
+}
+
+// isBlacklistReply tells blacklisting apart the way handleSmtpResponses does
+func isBlacklistReply(code, description string) bool {
+	switch {
+	case code == "":
+		return false
+	case isTemporaryFailure(code):
+		return isBlacklistError(description)
+	case isPermanentFailure(code):
+		return isPermanentBlacklistError(description)
+	}
+	return false
+}
+
+func providerFromMx(dns domaincheck.DNS) string {
+	knownProviders, err := emailproviders.GetKnownProviders()
+	if err != nil {
+		return ""
+	}
+	provider, _ := emailproviders.GetEmailProviderFromMx(dns, *knownProviders)
+	return provider
 }
 
 func updateSMTPResults(results *EmailValidation, smtpValidation mailserver.SMPTValidation) {
 	results.IsMailboxFull = smtpValidation.InboxFull
+	results.MtaFingerprint = smtpValidation.MtaFingerprint
+	results.Dane = smtpValidation.Dane
+	results.Transcript = smtpValidation.Transcript
 	results.SmtpResponse = SmtpResponse{
 		ResponseCode:   smtpValidation.ResponseCode,
 		ErrorCode:      smtpValidation.ErrorCode,
 		Description:    smtpValidation.Description,
 		CanConnectSMTP: smtpValidation.CanConnectSmtp,
+		MxHost:         smtpValidation.MxHost,
+		MxAttempts:     smtpValidation.MxAttempts,
+		SenderMode:     smtpValidation.SenderMode,
+		Latency:        smtpValidation.Latency,
+	}
+	results.MailServerHealth.IsTarpitted = smtpValidation.Tarpitted
+	results.MailServerHealth.TarpitReason = smtpValidation.TarpitReason
+}
+
+// handleVrfyResult takes the verdict from a definitive VRFY or EXPN reply,
+// which the prober returns instead of a RCPT TO reply
+func handleVrfyResult(resp *EmailValidation, vrfy *VrfyResult) {
+	resp.Vrfy = vrfy
+	if !vrfy.Definitive() {
+		return
+	}
+
+	exists := vrfy.Status == mailserver.VrfyExists
+	resp.IsDeliverable = strconv.FormatBool(exists)
+	resp.RetryValidation = false
+	switch {
+	case vrfy.Command == "EXPN" && exists:
+		resp.ReasonCode = ReasonExpnExists
+	case vrfy.Command == "EXPN":
+		resp.ReasonCode = ReasonExpnNotExists
+	case exists:
+		resp.ReasonCode = ReasonVrfyExists
+	default:
+		resp.ReasonCode = ReasonVrfyNotExists
+	}
+}
+
+// handleConnectionDropped retries a probe whose session ended before the
+// server answered, as that says nothing about the mailbox
+func handleConnectionDropped(resp *EmailValidation, dropped bool) {
+	if !dropped || resp.IsDeliverable != "unknown" {
+		return
+	}
+	resp.RetryValidation = true
+}
+
+// handleTarpit explains an unknown verdict caused by a tarpitting server.
+// The host's latency history lets a retry wait long enough for an answer.
+func handleTarpit(resp *EmailValidation) {
+	if !resp.MailServerHealth.IsTarpitted || resp.IsDeliverable != "unknown" {
+		return
+	}
+	resp.ReasonCode = ReasonTarpit
+	resp.RetryValidation = true
+}
+
+// handleSmtpUtf8 explains an unknown verdict for a UTF-8 address the
+// server can't receive. Retrying won't help until the server supports it.
+func handleSmtpUtf8(resp *EmailValidation, unsupported bool) {
+	if !unsupported || resp.IsDeliverable != "unknown" {
+		return
 	}
+	resp.ReasonCode = ReasonSmtpUtf8Unsupported
+}
+
+// handleDaneIndeterminate explains an unknown verdict when the TLSA lookup
+// failed. The lookup may well succeed on retry.
+func handleDaneIndeterminate(resp *EmailValidation) {
+	if resp.Dane == nil || resp.Dane.Status != mailserver.DaneIndeterminate || resp.IsDeliverable != "unknown" {
+		return
+	}
+	resp.ReasonCode = ReasonDaneIndeterminate
+	resp.RetryValidation = true
 }
 
 func handleAlternateEmail(req *EmailValidationRequest, results *EmailValidation) {
@@ -162,6 +535,9 @@ func handleSmtpResponses(req *EmailValidationRequest, resp *EmailValidation) {
 	}
 
 	switch {
+	case resp.SmtpResponse.ResponseCode == "":
+		// No reply to classify, e.g. the server timed out or hung up
+		return
 	case isDeliverableResponse(resp.SmtpResponse.ResponseCode):
 		handleDeliverableResponse(resp)
 	case isTemporaryFailure(resp.SmtpResponse.ResponseCode):
@@ -421,12 +797,15 @@ func handleTLSRequirement(resp *EmailValidation) {
 
 func blacklisted(req *EmailValidationRequest, resp *EmailValidation) {
 	resp.MailServerHealth.IsBlacklisted = true
-	if ip, err := ipify.GetIp(); err != nil {
-		log.Printf("Unable to obtain Mailserver IP: %v", err)
-	} else {
-		resp.MailServerHealth.ServerIP = ip
+	if resp.MailServerHealth.ServerIP == "" {
+		resp.MailServerHealth.ServerIP = resolveServerIP(req, "")
 	}
 	resp.MailServerHealth.FromEmail = req.FromEmail
+
+	// Take the identity out of rotation so the next probe uses another one
+	if req.SenderPool != nil && !req.SenderIdentity.IsZero() {
+		req.SenderPool.ReportBlacklisted(req.SenderIdentity)
+	}
 }
 
 func greylisted(req *EmailValidationRequest, resp *EmailValidation) {
@@ -435,16 +814,70 @@ func greylisted(req *EmailValidationRequest, resp *EmailValidation) {
 	resp.MailServerHealth.IsGreylisted = true
 	resp.IsDeliverable = "unknown"
 
-	if ip, err := ipify.GetIp(); err != nil {
-		log.Printf("Unable to obtain Mailserver IP: %v", err)
-	} else {
-		resp.MailServerHealth.ServerIP = ip
+	if resp.MailServerHealth.ServerIP == "" {
+		resp.MailServerHealth.ServerIP = resolveServerIP(req, "")
 	}
 
 	resp.MailServerHealth.FromEmail = req.FromEmail
 	resp.MailServerHealth.RetryAfter = getRetryTimestamp(minutes)
 }
 
+// resolveServerIP determines the public IP our SMTP traffic came from.
+// The local address of the SMTP connection wins if it is publicly
+// routable, as it is the one the server saw. Then the configured IP, then
+// the discovery backend.
+func resolveServerIP(req *EmailValidationRequest, localIP string) string {
+	if publicip.IsPublic(localIP) {
+		return localIP
+	}
+	if req.ServerIP != "" {
+		return req.ServerIP
+	}
+
+	resolver := req.IPResolver
+	if resolver == nil {
+		resolver = publicip.Default()
+	}
+	ip, err := resolver.PublicIP()
+	if err != nil {
+		if serverIPFailures.start() {
+			log.Printf("Unable to obtain Mailserver IP: %v", err)
+		}
+		return ""
+	}
+	serverIPFailures.reset()
+	return ip
+}
+
+// Failures to discover the server IP are logged once per window, rather
+// than on every probe while discovery is down
+const serverIPLogWindow = 10 * time.Minute
+
+var serverIPFailures failureWindow
+
+type failureWindow struct {
+	mu      sync.Mutex
+	started time.Time
+}
+
+// start reports whether a failure opens a new window and should be logged
+func (w *failureWindow) start() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	if !w.started.IsZero() && now.Sub(w.started) < serverIPLogWindow {
+		return false
+	}
+	w.started = now
+	return true
+}
+
+func (w *failureWindow) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.started = time.Time{}
+}
+
 func determineGreylistDelay(description string) int {
 	switch {
 	case strings.Contains(description, "4 minutes"),
@@ -465,22 +898,124 @@ func getRetryTimestamp(minutesDelay int) int {
 	return int(time.Now().Add(time.Duration(minutesDelay) * time.Minute).Unix())
 }
 
-// CatchAllTest performs catch-all testing for domains
-func catchAllTest(validationRequest *EmailValidationRequest) EmailValidation {
+// CatchAllTest probes decoy recipients in one session and reports how
+// likely the domain is to accept any address. The decoys are compared with
+// the CatchAllTestUser codename, or with the target when TimingInference
+// asks for it to be probed alongside them. The results describe the
+// codename, the target's own reply is returned separately.
+func catchAllTest(validationRequest *EmailValidationRequest) (EmailValidation, SmtpResponse, CatchAll) {
 	results := initializeValidationResults()
 
 	_, _, _, domain := syntax.NormalizeEmailAddress(validationRequest.Email)
-	catchAllEmail := fmt.Sprintf("%s@%s", validationRequest.CatchAllTestUser, domain)
+	decoys := catchAllDecoys(validationRequest, domain)
+	decoyEmails := make([]string, len(decoys))
+	for i, decoy := range decoys {
+		decoyEmails[i] = decoy.email
+	}
 
-	smtpValidation := performSMTPValidation(&EmailValidationRequest{
-		Email:      catchAllEmail,
-		FromDomain: validationRequest.FromDomain,
-		FromEmail:  validationRequest.FromEmail,
-		Dns:        validationRequest.Dns,
-	})
+	// Only timing needs the target itself, so the real mailbox isn't
+	// probed again by a ValidateEmail that follows
+	probeTarget := validationRequest.TimingInference != nil
+	email := validationRequest.Email
+	if !probeTarget {
+		email, decoys, decoyEmails = decoyEmails[0], decoys[1:], decoyEmails[1:]
+	}
+
+	probeRequest := &EmailValidationRequest{
+		Email:                 email,
+		FromDomain:            validationRequest.FromDomain,
+		FromEmail:             validationRequest.FromEmail,
+		SenderPool:            validationRequest.SenderPool,
+		SenderIdentity:        validationRequest.SenderIdentity,
+		Failover:              validationRequest.Failover,
+		SenderMode:            senderMode(validationRequest),
+		DisableSenderFallback: validationRequest.DisableSenderFallback,
+		Fingerprint:           true,
+		Scheduler:             validationRequest.Scheduler,
+		LatencyTracker:        validationRequest.LatencyTracker,
+		CircuitBreaker:        validationRequest.CircuitBreaker,
+		Dane:                  validationRequest.Dane,
+		Dns:                   validationRequest.Dns,
+		Transcript:            validationRequest.Transcript,
+		decoys:                decoyEmails,
+		timingRounds:          timingRounds(validationRequest),
+		senderPerHost:         validationRequest.senderPerHost,
+	}
+	smtpValidation, err := performSMTPValidation(probeRequest)
+	validationRequest.SenderIdentity = probeRequest.SenderIdentity
+	validationRequest.FromDomain = probeRequest.FromDomain
+	validationRequest.FromEmail = probeRequest.FromEmail
+	if err != nil {
+		results.RetryValidation = true
+		results.SmtpResponse.Description = err.Error()
+		results.MailServerHealth.CircuitState = circuitState(validationRequest, "")
+		return results, SmtpResponse{}, CatchAll{}
+	}
 
 	updateSMTPResults(&results, smtpValidation)
+	var targetResponse SmtpResponse
+	var probes []CatchAllProbe
+	reference := mailserver.RcptReply{
+		Email:        email,
+		ResponseCode: smtpValidation.ResponseCode,
+		Description:  smtpValidation.Description,
+		LatencyMs:    smtpValidation.Latency.RcptMs,
+	}
+	if probeTarget {
+		targetResponse = results.SmtpResponse
+		// The CatchAllTestUser codename is the first decoy
+		if len(smtpValidation.DecoyReplies) > 0 {
+			codename := smtpValidation.DecoyReplies[0]
+			results.SmtpResponse.ResponseCode = codename.ResponseCode
+			results.SmtpResponse.ErrorCode = codename.ErrorCode
+			results.SmtpResponse.Description = codename.Description
+		}
+	} else {
+		probes = append(probes, CatchAllProbe{
+			Email:        reference.Email,
+			Kind:         "codename",
+			ResponseCode: reference.ResponseCode,
+			Description:  reference.Description,
+			LatencyMs:    reference.LatencyMs,
+		})
+	}
+	results.MailServerHealth.ServerIP = resolveServerIP(validationRequest, smtpValidation.LocalIP)
+	results.MailServerHealth.SenderIdentity = senderIdentityName(validationRequest)
 	handleSmtpResponses(validationRequest, &results)
+	handleDaneIndeterminate(&results)
+	results.MailServerHealth.CircuitState = circuitState(validationRequest, smtpValidation.MxHost)
+
+	for i, reply := range smtpValidation.DecoyReplies {
+		probes = append(probes, CatchAllProbe{
+			Email:        reply.Email,
+			Kind:         decoys[i].kind,
+			ResponseCode: reply.ResponseCode,
+			Description:  reply.Description,
+			LatencyMs:    reply.LatencyMs,
+		})
+	}
+	catchAll := analyzeCatchAll(reference, probes, smtpValidation.MtaFingerprint, catchAllConfig(validationRequest).Threshold)
+	learnAcceptAllBackup(validationRequest, smtpValidation.MxHost, catchAll)
 
-	return results
+	if config := validationRequest.TimingInference; config != nil {
+		decoyKinds := make(map[string]string, len(decoys))
+		for _, decoy := range decoys {
+			decoyKinds[decoy.email] = decoy.kind
+		}
+		catchAll.Timing = analyzeTiming(validationRequest.Email, smtpValidation.TimingSamples, decoyKinds, config.Significance)
+	}
+
+	return results, targetResponse, catchAll
+}
+
+// learnAcceptAllBackup has later validations skip a backup MX that took
+// the catch-all decoys, as whatever it says of a mailbox is worthless
+func learnAcceptAllBackup(req *EmailValidationRequest, host string, catchAll CatchAll) {
+	if req.Failover == nil || req.Dns == nil || len(req.Dns.MX) == 0 || !catchAll.IsCatchAll {
+		return
+	}
+	if host == "" || host == req.Dns.MX[0] {
+		return
+	}
+	req.Failover.Learned.Add(host, "accepted the catch-all decoys")
 }
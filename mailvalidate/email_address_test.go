@@ -6,6 +6,9 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/customeros/mailsherpa/domaincheck"
+	"github.com/customeros/mailsherpa/internal/publicip"
 )
 
 func TestIsInvalidAddressError(t *testing.T) {
@@ -269,6 +272,20 @@ func TestHandleSmtpResponses(t *testing.T) {
 				RetryValidation: false,
 			},
 		},
+		{
+			name: "should leave a session dropped at MAIL FROM unknown",
+			req:  &EmailValidationRequest{},
+			resp: &EmailValidation{
+				IsDeliverable: "unknown",
+				SmtpResponse: SmtpResponse{
+					Description: "Connection dropped during MAIL FROM",
+				},
+			},
+			expected: EmailValidation{
+				IsDeliverable:   "unknown",
+				RetryValidation: false,
+			},
+		},
 		{
 			name: "should handle mailbox full",
 			req:  &EmailValidationRequest{},
@@ -317,6 +334,166 @@ func TestHandleSmtpResponses(t *testing.T) {
 	}
 }
 
+func TestHandleVrfyResult(t *testing.T) {
+	tests := []struct {
+		name          string
+		vrfy          *VrfyResult
+		isDeliverable string
+		reasonCode    string
+	}{
+		{
+			name:          "should accept VRFY exists",
+			vrfy:          &VrfyResult{Command: "VRFY", ResponseCode: "250", Status: "exists"},
+			isDeliverable: "true",
+			reasonCode:    ReasonVrfyExists,
+		},
+		{
+			name:          "should reject EXPN not exists",
+			vrfy:          &VrfyResult{Command: "EXPN", ResponseCode: "550", Status: "not_exists"},
+			isDeliverable: "false",
+			reasonCode:    ReasonExpnNotExists,
+		},
+		{
+			name:          "should keep the RCPT verdict when VRFY cannot verify",
+			vrfy:          &VrfyResult{Command: "VRFY", ResponseCode: "252", Status: "cannot_verify"},
+			isDeliverable: "unknown",
+		},
+		{
+			name:          "should ignore a missing VRFY phase",
+			isDeliverable: "unknown",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &EmailValidation{IsDeliverable: "unknown", RetryValidation: true}
+			handleVrfyResult(resp, tt.vrfy)
+
+			assert.Equal(t, tt.isDeliverable, resp.IsDeliverable)
+			assert.Equal(t, tt.reasonCode, resp.ReasonCode)
+			assert.Equal(t, tt.vrfy, resp.Vrfy)
+			assert.Equal(t, tt.reasonCode == "", resp.RetryValidation)
+		})
+	}
+}
+
+func TestHandleConnectionDropped(t *testing.T) {
+	resp := &EmailValidation{IsDeliverable: "unknown"}
+	handleConnectionDropped(resp, true)
+	assert.True(t, resp.RetryValidation)
+
+	answered := &EmailValidation{IsDeliverable: "false"}
+	handleConnectionDropped(answered, true)
+	assert.False(t, answered.RetryValidation, "a verdict reached before the drop stands")
+}
+
+func TestHandleTarpit(t *testing.T) {
+	resp := &EmailValidation{IsDeliverable: "unknown"}
+	resp.MailServerHealth.IsTarpitted = true
+	resp.MailServerHealth.TarpitReason = "No reply to RCPT TO within 30s"
+
+	handleSmtpResponses(&EmailValidationRequest{}, resp)
+	handleTarpit(resp)
+	assert.Equal(t, "unknown", resp.IsDeliverable, "a missing reply must not count as deliverable")
+	assert.Equal(t, ReasonTarpit, resp.ReasonCode)
+	assert.True(t, resp.RetryValidation)
+
+	answered := &EmailValidation{IsDeliverable: "true"}
+	answered.MailServerHealth.IsTarpitted = true
+	handleTarpit(answered)
+	assert.Empty(t, answered.ReasonCode, "a slow server that answered keeps its verdict")
+}
+
+func TestValidateEmailTarpitBeforeRcpt(t *testing.T) {
+	for _, command := range []string{"HELO", "MAIL FROM"} {
+		t.Run(command, func(t *testing.T) {
+			host := startFakeServer(t, "220 mx.acme.com ESMTP", map[string]string{command: ignoreCommand}).IP
+
+			// Size the command timeout down to 100ms for the fake server
+			latency := &LatencyTracker{Multiplier: 1, MinTimeout: 100 * time.Millisecond}
+			latency.Observe(host, 100*time.Millisecond)
+
+			results := ValidateEmail(EmailValidationRequest{
+				Email:          "john@acme.com",
+				FromDomain:     "probe.example",
+				ServerIP:       "203.0.113.7",
+				LatencyTracker: latency,
+				Dns:            &domaincheck.DNS{MX: []string{host}},
+			})
+
+			assert.Equal(t, "unknown", results.IsDeliverable, "a server that stops answering says nothing about the mailbox")
+			assert.Equal(t, ReasonTarpit, results.ReasonCode)
+			assert.True(t, results.RetryValidation)
+			assert.Equal(t, "No reply to "+command, results.SmtpResponse.Description)
+			assert.True(t, strings.HasPrefix(results.MailServerHealth.TarpitReason, "No reply to "+command))
+		})
+	}
+}
+
+func TestValidateEmailDomainLiteral(t *testing.T) {
+	startFakeServer(t, "220 mx.acme.com ESMTP", nil)
+
+	results := ValidateEmail(EmailValidationRequest{
+		Email:      "john@[127.0.0.1]",
+		FromDomain: "probe.example",
+		ServerIP:   "203.0.113.7",
+	})
+
+	assert.Equal(t, "127.0.0.1", results.SmtpResponse.MxHost, "the literal's address is probed directly")
+	assert.Equal(t, "250", results.SmtpResponse.ResponseCode)
+	assert.Equal(t, "true", results.IsDeliverable)
+}
+
+func TestValidateEmailSenderPerHost(t *testing.T) {
+	host := startFakeServer(t, "220 mx.acme.com ESMTP", nil).IP
+	probeOne := SenderIdentity{Name: "probe-1", HeloName: "mail.one.example", FromDomain: "one.example"}
+	probeTwo := SenderIdentity{Name: "probe-2", FromDomain: "two.example"}
+	pool := NewSenderPool([]SenderIdentity{probeOne, probeTwo})
+
+	results := ValidateEmail(EmailValidationRequest{
+		Email:      "john@acme.com",
+		SenderPool: pool,
+		ServerIP:   "203.0.113.7",
+		Transcript: TranscriptOptions{Enabled: true},
+		// Nothing listens on 127.0.0.3, so the backup answers
+		Dns: &domaincheck.DNS{MX: []string{"127.0.0.3", host}},
+	})
+
+	assert.Equal(t, "true", results.IsDeliverable)
+	assert.Equal(t, "probe-2", results.MailServerHealth.SenderIdentity, "the backup sticks to its own identity")
+	var helo string
+	for _, entry := range results.Transcript.Entries {
+		if strings.HasPrefix(entry.Command, "HELO") {
+			helo = entry.Command
+		}
+	}
+	assert.Equal(t, "HELO two.example", helo)
+
+	primary, _ := pool.Pick("127.0.0.3")
+	assert.Equal(t, probeOne, primary, "the primary keeps its identity")
+}
+
+func TestResolveServerIP(t *testing.T) {
+	req := &EmailValidationRequest{ServerIP: "203.0.113.10", IPResolver: publicip.Static("198.51.100.7")}
+	assert.Equal(t, "198.51.100.20", resolveServerIP(req, "198.51.100.20"), "a public local IP follows rotation")
+	assert.Equal(t, "203.0.113.10", resolveServerIP(req, "10.0.0.5"), "a private local IP falls back to ServerIP")
+
+	req.ServerIP = ""
+	assert.Equal(t, "198.51.100.7", resolveServerIP(req, ""))
+}
+
+func TestFailureWindow(t *testing.T) {
+	var w failureWindow
+	assert.True(t, w.start(), "the first failure is logged")
+	assert.False(t, w.start(), "repeats within the window are not")
+
+	w.started = time.Now().Add(-serverIPLogWindow)
+	assert.True(t, w.start(), "a failure after the window is logged again")
+
+	w.reset()
+	assert.True(t, w.start(), "a failure after a success is logged again")
+}
+
 func TestIsPermanentBlacklistError(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -600,6 +777,9 @@ func TestMailServerHealthIntegration(t *testing.T) {
 			// Setup initial time for comparison
 			startTime := time.Now().Unix()
 
+			// Keep blacklist reports offline
+			tt.req.IPResolver = publicip.Static("203.0.113.10")
+
 			// Process the response
 			handleSmtpResponses(tt.req, tt.resp)
 
@@ -712,3 +892,32 @@ func TestMaillServerHealthEdgeCases(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateEmailCircuitOutcomes(t *testing.T) {
+	cases := []struct {
+		name    string
+		replies map[string]string
+		state   string
+	}{
+		{"sender refused", map[string]string{"MAIL FROM": "553 5.1.8 Domain of sender address does not exist"}, "closed"},
+		{"service closing", map[string]string{"RCPT TO": "421 4.3.2 Service shutting down"}, "open"},
+		{"mailbox answered", nil, "closed"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			host := startFakeServer(t, "220 mx.acme.com ESMTP", tc.replies).IP
+			circuits := NewCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1, Cooldown: time.Minute})
+
+			ValidateEmail(EmailValidationRequest{
+				Email:                 "john@acme.com",
+				FromDomain:            "probe.example",
+				ServerIP:              "203.0.113.7",
+				CircuitBreaker:        circuits,
+				DisableSenderFallback: true,
+				Dns:                   &domaincheck.DNS{MX: []string{host}},
+			})
+
+			assert.Equal(t, tc.state, string(circuits.State(host)))
+		})
+	}
+}
@@ -1,38 +1,76 @@
 package mailvalidate
 
 import (
+	"errors"
 	"fmt"
 
+	"github.com/customeros/mailsherpa/domaincheck"
+	"github.com/customeros/mailsherpa/internal/disposable"
 	"github.com/customeros/mailsherpa/internal/free_emails"
 	"github.com/customeros/mailsherpa/internal/role_accounts"
+	"github.com/customeros/mailsherpa/internal/suggest"
 	"github.com/customeros/mailsherpa/internal/syntax"
 )
 
 type SyntaxValidation struct {
-	Error             string
-	IsValid           bool
-	User              string
-	Domain            string
-	CleanEmail        string
+	Error      string
+	IsValid    bool
+	User       string
+	Domain     string
+	CleanEmail string
+	// The address with its domain as Unicode (U-labels) and as ASCII
+	// (A-labels). CleanEmail is the ASCII form
+	UnicodeEmail string
+	AsciiEmail   string
+	// The local part is non-ASCII, so only servers supporting SMTPUTF8
+	// can be probed
+	RequiresSmtpUtf8  bool
 	IsRoleAccount     bool
 	IsFreeAccount     bool
 	IsSystemGenerated bool
+	// The domain is a known throwaway mailbox service
+	IsDisposable bool
+	// "Did you mean" corrections of a mistyped domain, most likely first
+	Suggestions []DomainSuggestion `json:",omitempty"`
+}
+
+// DomainSuggestion is a correction of the address' domain, such as
+// gmail.com for gmial.com
+type DomainSuggestion struct {
+	Email  string
+	Domain string
+	// Between 0 and 1, higher is more likely
+	Confidence float64
 }
 
 func ValidateEmailSyntax(email string) SyntaxValidation {
 	// Initial syntax validation
-	isValid, cleanEmail, user, domain := syntax.NormalizeEmailAddress(email)
-	if !isValid {
-		return SyntaxValidation{}
+	cleanEmail, user, domain, err := syntax.CheckEmailAddress(email)
+	if err != nil {
+		validation := SyntaxValidation{Error: err.Error()}
+		// john@gmail.con parses, but .con is no TLD
+		if errors.Is(err, syntax.ErrUnknownTLD) {
+			validation.Suggestions = suggestDomains(user, domain, suggest.Domain)
+		}
+		return validation
 	}
 
+	// The heuristics look at what the mailbox is called, not at how it's
+	// quoted, so "john smith" is judged as john smith
+	mailbox := syntax.UnquoteLocalPart(user)
+
 	// Create validation result with basic checks
 	validation := SyntaxValidation{
 		IsValid:           true,
 		User:              user,
 		Domain:            domain,
 		CleanEmail:        cleanEmail,
-		IsSystemGenerated: syntax.IsSystemGeneratedUser(user),
+		UnicodeEmail:      fmt.Sprintf("%s@%s", user, syntax.UnicodeDomain(domain)),
+		AsciiEmail:        cleanEmail,
+		RequiresSmtpUtf8:  syntax.RequiresSMTPUTF8(cleanEmail),
+		IsSystemGenerated: syntax.IsSystemGeneratedUser(mailbox),
+		// The domain may well be real, only offer confident corrections
+		Suggestions: suggestDomains(user, domain, suggest.Likely),
 	}
 
 	// Check if it's a free email provider
@@ -43,8 +81,16 @@ func ValidateEmailSyntax(email string) SyntaxValidation {
 		validation.IsFreeAccount = isFreeEmail
 	}
 
+	// Check if it's a throwaway mailbox service
+	if isDisposable, err := disposable.IsDisposableDomain(domain); err != nil {
+		validation.Error = fmt.Sprintf("Error running disposable email check: %s", err.Error())
+		return validation
+	} else {
+		validation.IsDisposable = isDisposable
+	}
+
 	// Check if it's a role account
-	if isRoleAccount, err := roleaccounts.IsRoleAccountCheck(user); err != nil {
+	if isRoleAccount, err := roleaccounts.IsRoleAccountCheck(mailbox); err != nil {
 		validation.Error = fmt.Sprintf("Error running role account check: %s", err.Error())
 		return validation
 	} else {
@@ -53,3 +99,42 @@ func ValidateEmailSyntax(email string) SyntaxValidation {
 
 	return validation
 }
+
+// VerifySuggestionsMX drops the suggestions whose domain has no MX
+// records, as a correction that can't receive mail isn't one
+func VerifySuggestionsMX(suggestions []DomainSuggestion) []DomainSuggestion {
+	var verified []DomainSuggestion
+	for _, suggestion := range suggestions {
+		if dns := domaincheck.CheckDNS(suggestion.Domain); len(dns.MX) > 0 {
+			verified = append(verified, suggestion)
+		}
+	}
+	return verified
+}
+
+// SuggestDomains returns every likely correction of the address' domain,
+// for when it turned out to have no MX records. ValidateEmailSyntax can't
+// tell, so it only suggests corrections of invalid TLDs and slips of the
+// finger away from a popular provider.
+func SuggestDomains(syntaxValidation SyntaxValidation) []DomainSuggestion {
+	return suggestDomains(syntaxValidation.User, syntaxValidation.Domain, suggest.Domain)
+}
+
+func suggestDomains(user, domain string, search func(string) ([]suggest.Suggestion, error)) []DomainSuggestion {
+	suggestions, err := search(domain)
+	if err != nil {
+		return nil
+	}
+
+	var results []DomainSuggestion
+	for _, suggestion := range suggestions {
+		// Normalized like CleanEmail
+		_, email, _, _ := syntax.NormalizeEmailAddress(fmt.Sprintf("%s@%s", user, suggestion.Domain))
+		results = append(results, DomainSuggestion{
+			Email:      email,
+			Domain:     suggestion.Domain,
+			Confidence: suggestion.Confidence,
+		})
+	}
+	return results
+}
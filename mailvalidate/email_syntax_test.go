@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/customeros/mailsherpa/mailvalidate"
 )
@@ -20,9 +21,9 @@ func TestValidateEmailSyntax(t *testing.T) {
 			email: "john.doe@gmail.com",
 			expected: mailvalidate.SyntaxValidation{
 				IsValid:           true,
-				User:              "johndoe",
+				User:              "john.doe",
 				Domain:            "gmail.com",
-				CleanEmail:        "johndoe@gmail.com",
+				CleanEmail:        "john.doe@gmail.com",
 				IsRoleAccount:     false,
 				IsFreeAccount:     true,
 				IsSystemGenerated: false,
@@ -101,15 +102,15 @@ func TestValidateEmailSyntax(t *testing.T) {
 			email: "Rob.Name😆@Gmail.com",
 			expected: mailvalidate.SyntaxValidation{
 				IsValid:           true,
-				User:              "robname",
+				User:              "rob.name😆",
 				Domain:            "gmail.com",
-				CleanEmail:        "robname@gmail.com",
+				CleanEmail:        "rob.name😆@gmail.com",
 				IsRoleAccount:     false,
 				IsFreeAccount:     true,
 				IsSystemGenerated: false,
 				Error:             "",
 			},
-			description: "Mixed case email should be normalized",
+			description: "Mixed case email should be normalized, keeping its UTF-8 local part",
 		},
 		{
 			name:  "Email with Plus Addressing",
@@ -193,15 +194,85 @@ func TestEdgeCases(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			result := mailvalidate.ValidateEmailSyntax(tc.email)
 
-			// For invalid cases, expect empty validation
+			// For invalid cases, expect only the reason
 			if !result.IsValid {
-				assert.Equal(t, mailvalidate.SyntaxValidation{}, result,
-					"Expected empty validation for invalid email: %s", tc.email)
+				assert.NotEmpty(t, result.Error, "Expected a syntax error for invalid email: %s", tc.email)
+				assert.Equal(t, mailvalidate.SyntaxValidation{Error: result.Error}, result,
+					"Expected only Error to be set for invalid email: %s", tc.email)
 			}
 		})
 	}
 }
 
+func TestQuotedLocalPartSyntax(t *testing.T) {
+	for _, email := range []string{`"john smith"@example.com`, `"j.doe,jr"@example.com`} {
+		t.Run(email, func(t *testing.T) {
+			result := mailvalidate.ValidateEmailSyntax(email)
+
+			assert.True(t, result.IsValid)
+			assert.Equal(t, email, result.CleanEmail)
+			assert.False(t, result.IsSystemGenerated, "quoted names are judged without their quotes")
+			assert.False(t, result.IsRoleAccount)
+		})
+	}
+}
+
+func TestInternationalEmailSyntax(t *testing.T) {
+	result := mailvalidate.ValidateEmailSyntax("José@Bücher.de")
+
+	assert.True(t, result.IsValid)
+	assert.Equal(t, "josé", result.User)
+	assert.Equal(t, "xn--bcher-kva.de", result.Domain)
+	assert.Equal(t, "josé@bücher.de", result.UnicodeEmail)
+	assert.Equal(t, "josé@xn--bcher-kva.de", result.AsciiEmail)
+	assert.Equal(t, result.AsciiEmail, result.CleanEmail)
+	assert.True(t, result.RequiresSmtpUtf8)
+
+	ascii := mailvalidate.ValidateEmailSyntax("jose@bücher.de")
+	assert.Equal(t, "jose@bücher.de", ascii.UnicodeEmail)
+	assert.False(t, ascii.RequiresSmtpUtf8)
+}
+
+func TestDomainSuggestions(t *testing.T) {
+	result := mailvalidate.ValidateEmailSyntax("john.doe@gmial.com")
+	assert.True(t, result.IsValid)
+	require.NotEmpty(t, result.Suggestions)
+	assert.Equal(t, "gmail.com", result.Suggestions[0].Domain)
+	assert.Equal(t, "john.doe@gmail.com", result.Suggestions[0].Email)
+	assert.Greater(t, result.Suggestions[0].Confidence, 0.5)
+
+	// .con is no TLD, so the address is invalid but still gets a suggestion
+	result = mailvalidate.ValidateEmailSyntax("jane@outlook.con")
+	assert.False(t, result.IsValid)
+	assert.NotEmpty(t, result.Error)
+	require.NotEmpty(t, result.Suggestions)
+	assert.Equal(t, "jane@outlook.com", result.Suggestions[0].Email)
+
+	// Real domains close to a provider get no suggestion without MX data
+	for _, email := range []string{"jane@acme.com", "jane@bp.com", "jane@web.dev"} {
+		result = mailvalidate.ValidateEmailSyntax(email)
+		assert.Empty(t, result.Suggestions, email)
+	}
+
+	// Unless the domain turns out to take no mail
+	result = mailvalidate.ValidateEmailSyntax("jane@gmall.com")
+	assert.Empty(t, result.Suggestions)
+	suggestions := mailvalidate.SuggestDomains(result)
+	require.NotEmpty(t, suggestions)
+	assert.Equal(t, "gmail.com", suggestions[0].Domain)
+}
+
+func TestDisposableEmailSyntax(t *testing.T) {
+	result := mailvalidate.ValidateEmailSyntax("signup@10minutemail.co.za")
+	assert.True(t, result.IsValid)
+	assert.True(t, result.IsDisposable)
+	assert.True(t, result.IsFreeAccount, "disposable domains stay on the free email list")
+
+	result = mailvalidate.ValidateEmailSyntax("john@gmail.com")
+	assert.True(t, result.IsFreeAccount)
+	assert.False(t, result.IsDisposable)
+}
+
 // TestFreeEmailProviders tests various free email providers
 func TestFreeEmailProviders(t *testing.T) {
 	freeProviders := []string{
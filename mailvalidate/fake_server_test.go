@@ -0,0 +1,21 @@
+package mailvalidate
+
+import (
+	"testing"
+
+	"github.com/customeros/mailsherpa/internal/smtptest"
+)
+
+const ignoreCommand = smtptest.IgnoreCommand
+
+// startFakeServer runs a scripted SMTP server on 127.0.0.1 and points
+// smtpPort at it
+func startFakeServer(t *testing.T, greeting string, replies map[string]string) *smtptest.Server {
+	t.Helper()
+
+	server := smtptest.Start(t, "127.0.0.1", "0", greeting, replies)
+	previousPort := smtpPort
+	smtpPort = server.Port
+	t.Cleanup(func() { smtpPort = previousPort })
+	return server
+}
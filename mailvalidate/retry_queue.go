@@ -0,0 +1,418 @@
+package mailvalidate
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts = 3
+	retryResultsBuffer      = 100
+)
+
+type RetryQueueConfig struct {
+	// Total validation attempts per email, including the first one
+	MaxAttempts int
+	// File pending retries are persisted to so they survive restarts.
+	// Empty keeps them in memory only
+	PersistPath string
+	// Called with the outcome of every retry. When nil, outcomes are
+	// delivered on the Results channel instead, and dropped while its
+	// buffer is full
+	OnResult func(RetryResult)
+	// Template for the shared parts of retried requests: IPResolver,
+	// SenderPool, Scheduler, CircuitBreaker and LatencyTracker
+	BaseRequest EmailValidationRequest
+}
+
+// RetryResult is the outcome of a retried validation
+type RetryResult struct {
+	Email    string
+	Attempts int
+	// The email was greylisted again and queued for another attempt
+	Requeued bool
+	Result   EmailValidation
+}
+
+// PendingRetry is a validation waiting for its greylisting window to pass
+type PendingRetry struct {
+	Email          string
+	FromDomain     string
+	FromEmail      string
+	SenderIdentity SenderIdentity
+	SenderMode     SenderMode
+	Attempts       int
+	RetryAfter     int
+}
+
+// RetryQueue re-runs greylisted validations once their RetryAfter has
+// passed. Greylisting keys on the (IP, sender, recipient) triplet, so
+// retries reuse the original sender identity and MAIL FROM.
+type RetryQueue struct {
+	config   RetryQueueConfig
+	results  chan RetryResult
+	validate func(EmailValidationRequest) EmailValidation
+	now      func() time.Time
+
+	mu   sync.Mutex
+	jobs retryJobs
+	// Jobs being retried. They stay persisted until the retry is done, so
+	// a restart mid-retry runs them again
+	inFlight map[*retryJob]bool
+	wake     chan struct{}
+}
+
+type retryJob struct {
+	Email                  string                  `json:"email"`
+	FromDomain             string                  `json:"fromDomain"`
+	FromEmail              string                  `json:"fromEmail"`
+	CatchAllTestUser       string                  `json:"catchAllTestUser,omitempty"`
+	ServerIP               string                  `json:"serverIP,omitempty"`
+	SenderIdentity         SenderIdentity          `json:"senderIdentity"`
+	SenderMode             SenderMode              `json:"senderMode,omitempty"`
+	DomainValidationParams *DomainValidationParams `json:"domainValidationParams,omitempty"`
+	Attempts               int                     `json:"attempts"`
+	RetryAfter             int                     `json:"retryAfter"`
+}
+
+// NewRetryQueue creates a queue and loads any retries persisted by a previous run
+func NewRetryQueue(config RetryQueueConfig) (*RetryQueue, error) {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultRetryMaxAttempts
+	}
+
+	queue := &RetryQueue{
+		config:   config,
+		validate: ValidateEmail,
+		inFlight: make(map[*retryJob]bool),
+		wake:     make(chan struct{}, 1),
+	}
+	if config.OnResult == nil {
+		queue.results = make(chan RetryResult, retryResultsBuffer)
+	}
+
+	if err := queue.load(); err != nil {
+		return nil, err
+	}
+	return queue, nil
+}
+
+// Results delivers retry outcomes when no OnResult callback is configured.
+// Outcomes that don't fit its buffer are logged and dropped, so the queue
+// keeps retrying when nobody reads them.
+func (q *RetryQueue) Results() <-chan RetryResult {
+	return q.results
+}
+
+// Enqueue schedules a retry for a greylisted result. It reports false when
+// the result doesn't need one, the attempt budget is used up or the same
+// email from the same sender is being retried already. A pending retry of
+// it is replaced, keeping its attempt count.
+func (q *RetryQueue) Enqueue(req EmailValidationRequest, result EmailValidation) (bool, error) {
+	return q.enqueue(req, result, 1)
+}
+
+func (q *RetryQueue) enqueue(req EmailValidationRequest, result EmailValidation, attempts int) (bool, error) {
+	job := q.newJob(req, result, attempts)
+	if job == nil {
+		return false, nil
+	}
+
+	q.mu.Lock()
+	for running := range q.inFlight {
+		if running.key() == job.key() {
+			q.mu.Unlock()
+			return false, nil
+		}
+	}
+	if i := q.jobs.find(job.key()); i >= 0 {
+		if previous := q.jobs[i]; previous.Attempts > job.Attempts {
+			job.Attempts = previous.Attempts
+		}
+		q.jobs[i] = job
+		heap.Fix(&q.jobs, i)
+	} else {
+		heap.Push(&q.jobs, job)
+	}
+	err := q.persist()
+	q.mu.Unlock()
+
+	q.signal()
+	return true, err
+}
+
+// newJob returns the retry for a result, or nil when it doesn't need one
+// or the attempt budget is used up
+func (q *RetryQueue) newJob(req EmailValidationRequest, result EmailValidation, attempts int) *retryJob {
+	if !result.MailServerHealth.IsGreylisted || result.MailServerHealth.RetryAfter == 0 {
+		return nil
+	}
+	if attempts >= q.config.MaxAttempts {
+		return nil
+	}
+
+	// Retry from exactly the sender the server saw the first time
+	fromEmail := req.FromEmail
+	if result.MailServerHealth.FromEmail != "" {
+		fromEmail = result.MailServerHealth.FromEmail
+	}
+	mode := req.SenderMode
+	if result.SmtpResponse.SenderMode != "" {
+		mode = result.SmtpResponse.SenderMode
+	}
+	identity := req.SenderIdentity
+	if identity.IsZero() && req.SenderPool != nil {
+		identity, _ = req.SenderPool.Find(result.MailServerHealth.SenderIdentity)
+	}
+
+	return &retryJob{
+		Email:                  req.Email,
+		FromDomain:             req.FromDomain,
+		FromEmail:              fromEmail,
+		CatchAllTestUser:       req.CatchAllTestUser,
+		ServerIP:               req.ServerIP,
+		SenderIdentity:         identity,
+		SenderMode:             mode,
+		DomainValidationParams: req.DomainValidationParams,
+		Attempts:               attempts,
+		RetryAfter:             result.MailServerHealth.RetryAfter,
+	}
+}
+
+// key identifies a retry by the email and the sender the server saw, which
+// is what greylisting keys on too
+func (j *retryJob) key() string {
+	return strings.ToLower(j.Email) + "|" + j.FromEmail + "|" + j.SenderIdentity.String()
+}
+
+func (q *RetryQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Pending returns the queued retries, soonest first
+func (q *RetryQueue) Pending() []PendingRetry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs := make(retryJobs, len(q.jobs))
+	copy(jobs, q.jobs)
+
+	pending := make([]PendingRetry, 0, len(jobs))
+	for jobs.Len() > 0 {
+		job := heap.Pop(&jobs).(*retryJob)
+		pending = append(pending, PendingRetry{
+			Email:          job.Email,
+			FromDomain:     job.FromDomain,
+			FromEmail:      job.FromEmail,
+			SenderIdentity: job.SenderIdentity,
+			SenderMode:     job.SenderMode,
+			Attempts:       job.Attempts,
+			RetryAfter:     job.RetryAfter,
+		})
+	}
+	return pending
+}
+
+// Run processes retries as they come due until ctx is cancelled
+func (q *RetryQueue) Run(ctx context.Context) error {
+	for {
+		job, wait := q.next()
+		if job != nil {
+			q.retry(job)
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-q.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// next pops the next due job, or returns how long to wait for one. The
+// job stays persisted until finish
+func (q *RetryQueue) next() (*retryJob, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.jobs.Len() == 0 {
+		return nil, time.Hour
+	}
+
+	now := q.clock().Unix()
+	if due := int64(q.jobs[0].RetryAfter); due > now {
+		return nil, time.Duration(due-now) * time.Second
+	}
+
+	job := heap.Pop(&q.jobs).(*retryJob)
+	q.inFlight[job] = true
+	return job, 0
+}
+
+// finish replaces a retried job with its follow-up, if any, and persists
+// the queue
+func (q *RetryQueue) finish(job, next *retryJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.inFlight, job)
+	if next != nil {
+		heap.Push(&q.jobs, next)
+	}
+	return q.persist()
+}
+
+func (q *RetryQueue) retry(job *retryJob) {
+	req := q.config.BaseRequest
+	req.Email = job.Email
+	req.FromDomain = job.FromDomain
+	req.FromEmail = job.FromEmail
+	req.CatchAllTestUser = job.CatchAllTestUser
+	req.SenderIdentity = job.SenderIdentity
+	// Stick to the mode the server answered, without provider overrides
+	req.SenderMode = job.SenderMode
+	req.SenderModes = nil
+	req.DomainValidationParams = job.DomainValidationParams
+	req.Dns = nil
+	// The worker requeues itself, so don't let ValidateEmail enqueue too
+	req.RetryQueue = nil
+	if job.ServerIP != "" {
+		req.ServerIP = job.ServerIP
+	}
+
+	attempts := job.Attempts + 1
+	result := q.validate(req)
+
+	next := q.newJob(req, result, attempts)
+	if err := q.finish(job, next); err != nil && result.Error == "" {
+		result.Error = fmt.Sprintf("Error persisting retry queue: %v", err)
+	}
+	if next != nil {
+		q.signal()
+	}
+
+	q.deliver(RetryResult{
+		Email:    job.Email,
+		Attempts: attempts,
+		Requeued: next != nil,
+		Result:   result,
+	})
+}
+
+func (q *RetryQueue) deliver(result RetryResult) {
+	if q.config.OnResult != nil {
+		q.config.OnResult(result)
+		return
+	}
+	select {
+	case q.results <- result:
+	default:
+		log.Printf("Retry results channel full, dropping result for %s", result.Email)
+	}
+}
+
+func (q *RetryQueue) load() error {
+	if q.config.PersistPath == "" {
+		return nil
+	}
+
+	fileData, err := os.ReadFile(q.config.PersistPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var jobs []*retryJob
+	if err := json.Unmarshal(fileData, &jobs); err != nil {
+		return fmt.Errorf("failed to decode retry queue: %w", err)
+	}
+
+	q.jobs = jobs
+	heap.Init(&q.jobs)
+	return nil
+}
+
+// persist writes the queue atomically. Callers must hold q.mu
+func (q *RetryQueue) persist() error {
+	if q.config.PersistPath == "" {
+		return nil
+	}
+
+	jobs := append(retryJobs{}, q.jobs...)
+	for job := range q.inFlight {
+		jobs = append(jobs, job)
+	}
+	fileData, err := json.Marshal(jobs)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(q.config.PersistPath), ".retry-queue-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(fileData); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), q.config.PersistPath)
+}
+
+func (q *RetryQueue) clock() time.Time {
+	if q.now != nil {
+		return q.now()
+	}
+	return time.Now()
+}
+
+// retryJobs is a min-heap ordered by RetryAfter
+type retryJobs []*retryJob
+
+func (j retryJobs) Len() int           { return len(j) }
+func (j retryJobs) Less(a, b int) bool { return j[a].RetryAfter < j[b].RetryAfter }
+func (j retryJobs) Swap(a, b int)      { j[a], j[b] = j[b], j[a] }
+
+// find returns the index of the job with key, or -1
+func (j retryJobs) find(key string) int {
+	for i, job := range j {
+		if job.key() == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (j *retryJobs) Push(x interface{}) {
+	*j = append(*j, x.(*retryJob))
+}
+
+func (j *retryJobs) Pop() interface{} {
+	old := *j
+	n := len(old)
+	job := old[n-1]
+	*j = old[:n-1]
+	return job
+}
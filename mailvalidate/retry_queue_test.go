@@ -0,0 +1,205 @@
+package mailvalidate
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func greylistedResult(retryAfter int) EmailValidation {
+	return EmailValidation{
+		IsDeliverable:   "unknown",
+		RetryValidation: true,
+		MailServerHealth: MailServerHealth{
+			IsGreylisted: true,
+			FromEmail:    "emma.smith@probe.example",
+			RetryAfter:   retryAfter,
+		},
+	}
+}
+
+func TestRetryQueueOnlyQueuesGreylisted(t *testing.T) {
+	queue, err := NewRetryQueue(RetryQueueConfig{})
+	require.NoError(t, err)
+
+	queued, err := queue.Enqueue(EmailValidationRequest{Email: "john@acme.com"}, EmailValidation{IsDeliverable: "true"})
+	require.NoError(t, err)
+	assert.False(t, queued)
+
+	queued, err = queue.Enqueue(EmailValidationRequest{Email: "john@acme.com"}, greylistedResult(200))
+	require.NoError(t, err)
+	assert.True(t, queued)
+
+	queued, _ = queue.Enqueue(EmailValidationRequest{Email: "jane@acme.com"}, greylistedResult(100))
+	assert.True(t, queued)
+
+	pending := queue.Pending()
+	require.Len(t, pending, 2)
+	assert.Equal(t, "jane@acme.com", pending[0].Email, "pending retries should be ordered by RetryAfter")
+	assert.Equal(t, "emma.smith@probe.example", pending[0].FromEmail)
+}
+
+func TestRetryQueueDedupesEmailAndSender(t *testing.T) {
+	queue, err := NewRetryQueue(RetryQueueConfig{})
+	require.NoError(t, err)
+	req := EmailValidationRequest{Email: "john@acme.com"}
+
+	queue.Enqueue(req, greylistedResult(200))
+	queued, err := queue.Enqueue(EmailValidationRequest{Email: "John@acme.com"}, greylistedResult(300))
+	require.NoError(t, err)
+	assert.True(t, queued)
+	pending := queue.Pending()
+	require.Len(t, pending, 1, "a second greylisting of the same email and sender replaces the first")
+	assert.Equal(t, 300, pending[0].RetryAfter)
+
+	otherSender := greylistedResult(100)
+	otherSender.MailServerHealth.FromEmail = "liam.jones@probe.example"
+	queue.Enqueue(req, otherSender)
+	assert.Len(t, queue.Pending(), 2, "another sender is greylisted separately")
+
+	job, _ := queue.next()
+	require.NotNil(t, job)
+	queued, _ = queue.Enqueue(req, otherSender)
+	assert.False(t, queued, "a retry under way isn't queued again")
+	assert.Len(t, queue.Pending(), 1)
+}
+
+func TestRetryQueueReusesSenderAndStopsAtMaxAttempts(t *testing.T) {
+	identity := SenderIdentity{Name: "probe-1", BindIP: "203.0.113.10", FromDomain: "probe.example"}
+	results := make(chan RetryResult, 10)
+
+	queue, err := NewRetryQueue(RetryQueueConfig{
+		MaxAttempts: 3,
+		OnResult:    func(r RetryResult) { results <- r },
+	})
+	require.NoError(t, err)
+
+	var seen []EmailValidationRequest
+	queue.validate = func(req EmailValidationRequest) EmailValidation {
+		seen = append(seen, req)
+		return greylistedResult(int(time.Now().Unix()) - 1)
+	}
+
+	// The first attempt fell back to the null sender
+	first := greylistedResult(int(time.Now().Unix()) - 1)
+	first.SmtpResponse.SenderMode = SenderModeNull
+
+	req := EmailValidationRequest{
+		Email:          "john@acme.com",
+		FromDomain:     "probe.example",
+		FromEmail:      "emma.smith@probe.example",
+		SenderIdentity: identity,
+	}
+	_, err = queue.Enqueue(req, first)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go queue.Run(ctx)
+
+	retried := <-results
+	assert.Equal(t, 2, retried.Attempts)
+	assert.True(t, retried.Requeued)
+
+	second := <-results
+	assert.Equal(t, 3, second.Attempts)
+	assert.False(t, second.Requeued, "attempt budget should be exhausted")
+
+	require.Len(t, seen, 2)
+	for _, retried := range seen {
+		assert.Equal(t, identity, retried.SenderIdentity)
+		assert.Equal(t, "emma.smith@probe.example", retried.FromEmail)
+		assert.Equal(t, SenderModeNull, retried.SenderMode)
+		assert.Nil(t, retried.RetryQueue)
+	}
+	assert.Empty(t, queue.Pending())
+}
+
+func TestRetryQueuePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retries.json")
+
+	queue, err := NewRetryQueue(RetryQueueConfig{PersistPath: path})
+	require.NoError(t, err)
+	_, err = queue.Enqueue(EmailValidationRequest{Email: "john@acme.com", FromDomain: "probe.example"}, greylistedResult(1700000000))
+	require.NoError(t, err)
+
+	restarted, err := NewRetryQueue(RetryQueueConfig{PersistPath: path})
+	require.NoError(t, err)
+
+	pending := restarted.Pending()
+	require.Len(t, pending, 1)
+	assert.Equal(t, "john@acme.com", pending[0].Email)
+	assert.Equal(t, 1700000000, pending[0].RetryAfter)
+	assert.Equal(t, 1, pending[0].Attempts)
+}
+
+func TestRetryQueueKeepsJobPersistedDuringRetry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retries.json")
+
+	queue, err := NewRetryQueue(RetryQueueConfig{PersistPath: path, OnResult: func(RetryResult) {}})
+	require.NoError(t, err)
+	_, err = queue.Enqueue(EmailValidationRequest{Email: "john@acme.com", FromDomain: "probe.example"}, greylistedResult(1))
+	require.NoError(t, err)
+
+	queue.validate = func(req EmailValidationRequest) EmailValidation {
+		// A restart now must still find the job
+		restarted, err := NewRetryQueue(RetryQueueConfig{PersistPath: path})
+		require.NoError(t, err)
+		assert.Len(t, restarted.Pending(), 1)
+		return EmailValidation{IsDeliverable: "true"}
+	}
+	job, _ := queue.next()
+	require.NotNil(t, job)
+	queue.retry(job)
+
+	restarted, err := NewRetryQueue(RetryQueueConfig{PersistPath: path})
+	require.NoError(t, err)
+	assert.Empty(t, restarted.Pending(), "the job is removed once retried")
+}
+
+func TestRetryQueueResultsChannel(t *testing.T) {
+	queue, err := NewRetryQueue(RetryQueueConfig{})
+	require.NoError(t, err)
+	queue.validate = func(req EmailValidationRequest) EmailValidation {
+		return EmailValidation{IsDeliverable: "true"}
+	}
+
+	_, err = queue.Enqueue(EmailValidationRequest{Email: "john@acme.com"}, greylistedResult(1))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go queue.Run(ctx)
+
+	select {
+	case result := <-queue.Results():
+		assert.Equal(t, "true", result.Result.IsDeliverable)
+		assert.False(t, result.Requeued)
+	case <-time.After(time.Second):
+		t.Fatal("expected a retry result")
+	}
+}
+
+func TestRetryQueueDropsResultsWhenChannelFull(t *testing.T) {
+	queue, err := NewRetryQueue(RetryQueueConfig{})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < retryResultsBuffer+1; i++ {
+			queue.deliver(RetryResult{Email: "john@acme.com"})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("delivering to a full results channel blocked")
+	}
+	assert.Len(t, queue.Results(), retryResultsBuffer)
+}
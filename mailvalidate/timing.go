@@ -0,0 +1,163 @@
+package mailvalidate
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/customeros/mailsherpa/internal/mailserver"
+)
+
+// Fewer samples per group than this can't support a comparison
+const minTimingSamples = 3
+
+// TimingInferenceConfig enables inferring whether a mailbox exists on a
+// catch-all domain from how fast the server accepts it
+type TimingInferenceConfig struct {
+	// Timed RCPT TO rounds for the target and each decoy
+	Rounds int
+	// p-value below which the target counts as treated differently
+	Significance float64
+}
+
+func DefaultTimingInferenceConfig() TimingInferenceConfig {
+	return TimingInferenceConfig{
+		Rounds:       5,
+		Significance: 0.05,
+	}
+}
+
+// TimingSignal compares the server's RCPT TO latency for the target with
+// its latency for decoys that can't exist. A significant difference means
+// the server looked the target up and found something. No difference is
+// not evidence against the mailbox, since many servers don't leak timing.
+type TimingSignal struct {
+	LikelyExists bool
+	// 1 - PValue: how unlikely the difference is to be chance
+	Probability float64
+	PValue      float64
+	// Not enough accepted samples to compare
+	Inconclusive   bool
+	TargetMedianMs float64
+	DecoyMedianMs  float64
+	TargetSamples  []float64
+	DecoySamples   []float64
+}
+
+func timingRounds(req *EmailValidationRequest) int {
+	if req.TimingInference == nil {
+		return 0
+	}
+	if req.TimingInference.Rounds <= 0 {
+		return DefaultTimingInferenceConfig().Rounds
+	}
+	return req.TimingInference.Rounds
+}
+
+// analyzeTiming splits the samples into target and decoy groups and runs
+// a Mann-Whitney U test on them. Only samples with the same reply code as
+// the target count, and over-long decoys are left out because the server
+// may reject or parse them differently.
+func analyzeTiming(targetEmail string, samples []mailserver.TimingSample, decoyKinds map[string]string, significance float64) *TimingSignal {
+	signal := &TimingSignal{}
+	if significance <= 0 {
+		significance = DefaultTimingInferenceConfig().Significance
+	}
+
+	targetCode := ""
+	for _, sample := range samples {
+		if strings.EqualFold(sample.Email, targetEmail) {
+			targetCode = sample.ResponseCode
+			break
+		}
+	}
+
+	for _, sample := range samples {
+		if sample.ResponseCode != targetCode {
+			continue
+		}
+		if strings.EqualFold(sample.Email, targetEmail) {
+			signal.TargetSamples = append(signal.TargetSamples, sample.LatencyMs)
+		} else if kind, ok := decoyKinds[sample.Email]; ok && kind != "long" {
+			signal.DecoySamples = append(signal.DecoySamples, sample.LatencyMs)
+		}
+	}
+
+	if len(signal.TargetSamples) < minTimingSamples || len(signal.DecoySamples) < minTimingSamples {
+		signal.Inconclusive = true
+		return signal
+	}
+
+	signal.TargetMedianMs = median(signal.TargetSamples)
+	signal.DecoyMedianMs = median(signal.DecoySamples)
+	signal.PValue = roundTo(mannWhitneyPValue(signal.TargetSamples, signal.DecoySamples), 4)
+	signal.Probability = roundTo(1-signal.PValue, 4)
+	signal.LikelyExists = signal.PValue < significance
+	return signal
+}
+
+// mannWhitneyPValue returns the two-sided p-value of a Mann-Whitney U test
+// using the normal approximation with tie and continuity corrections
+func mannWhitneyPValue(a, b []float64) float64 {
+	type value struct {
+		v     float64
+		fromA bool
+	}
+	values := make([]value, 0, len(a)+len(b))
+	for _, v := range a {
+		values = append(values, value{v, true})
+	}
+	for _, v := range b {
+		values = append(values, value{v, false})
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].v < values[j].v })
+
+	// Rank with ties sharing their average rank
+	n := float64(len(values))
+	rankSumA, tieTerm := 0.0, 0.0
+	for i := 0; i < len(values); {
+		j := i
+		for j < len(values) && values[j].v == values[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if values[k].fromA {
+				rankSumA += rank
+			}
+		}
+		t := float64(j - i)
+		tieTerm += t*t*t - t
+		i = j
+	}
+
+	n1, n2 := float64(len(a)), float64(len(b))
+	u := rankSumA - n1*(n1+1)/2
+	mean := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((n + 1) - tieTerm/(n*(n-1)))
+	if variance <= 0 {
+		return 1
+	}
+
+	diff := math.Abs(u-mean) - 0.5
+	if diff < 0 {
+		diff = 0
+	}
+	z := diff / math.Sqrt(variance)
+	return math.Erfc(z / math.Sqrt2)
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+func roundTo(v float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(v*scale) / scale
+}
@@ -0,0 +1,73 @@
+package mailvalidate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/customeros/mailsherpa/internal/mailserver"
+)
+
+func TestMannWhitneyPValue(t *testing.T) {
+	separated := mannWhitneyPValue([]float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10})
+	assert.InDelta(t, 0.0122, separated, 0.0005)
+
+	interleaved := mannWhitneyPValue([]float64{1, 3, 5, 7, 9}, []float64{2, 4, 6, 8, 10})
+	assert.Greater(t, interleaved, 0.5)
+
+	assert.Equal(t, 1.0, mannWhitneyPValue([]float64{4, 4, 4}, []float64{4, 4, 4}), "identical samples")
+}
+
+func timingSamples(email string, code string, latencies ...float64) []mailserver.TimingSample {
+	samples := make([]mailserver.TimingSample, len(latencies))
+	for i, latency := range latencies {
+		samples[i] = mailserver.TimingSample{Email: email, Round: i + 1, ResponseCode: code, LatencyMs: latency}
+	}
+	return samples
+}
+
+func TestAnalyzeTiming(t *testing.T) {
+	decoyKinds := map[string]string{
+		"bravehawk@acme.com": "codename",
+		"k3j9x0aq@acme.com":  "random",
+		"xxxxxxxx@acme.com":  "long",
+	}
+
+	t.Run("should flag a target the server looks up more slowly", func(t *testing.T) {
+		var samples []mailserver.TimingSample
+		samples = append(samples, timingSamples("john@acme.com", "250", 48, 51, 47, 52, 50)...)
+		samples = append(samples, timingSamples("bravehawk@acme.com", "250", 21, 19, 22, 20, 18)...)
+		samples = append(samples, timingSamples("k3j9x0aq@acme.com", "250", 20, 23, 19, 21, 22)...)
+		samples = append(samples, timingSamples("xxxxxxxx@acme.com", "250", 90, 95, 91, 92, 93)...)
+
+		signal := analyzeTiming("john@acme.com", samples, decoyKinds, 0.05)
+		require.False(t, signal.Inconclusive)
+		assert.True(t, signal.LikelyExists)
+		assert.Greater(t, signal.Probability, 0.99)
+		assert.Equal(t, 50.0, signal.TargetMedianMs)
+		assert.Equal(t, 20.5, signal.DecoyMedianMs)
+		assert.Len(t, signal.DecoySamples, 10, "over-long decoys are left out")
+	})
+
+	t.Run("should not flag indistinguishable timing", func(t *testing.T) {
+		var samples []mailserver.TimingSample
+		samples = append(samples, timingSamples("john@acme.com", "250", 20, 22, 19, 21, 23)...)
+		samples = append(samples, timingSamples("bravehawk@acme.com", "250", 21, 19, 22, 20, 23)...)
+		samples = append(samples, timingSamples("k3j9x0aq@acme.com", "250", 20, 23, 19, 21, 22)...)
+
+		signal := analyzeTiming("john@acme.com", samples, decoyKinds, 0.05)
+		assert.False(t, signal.LikelyExists)
+		assert.Less(t, signal.Probability, 0.5)
+	})
+
+	t.Run("should be inconclusive without enough accepted samples", func(t *testing.T) {
+		var samples []mailserver.TimingSample
+		samples = append(samples, timingSamples("john@acme.com", "250", 48, 51, 47)...)
+		samples = append(samples, timingSamples("bravehawk@acme.com", "452", 21, 19, 22)...)
+
+		signal := analyzeTiming("john@acme.com", samples, decoyKinds, 0.05)
+		assert.True(t, signal.Inconclusive)
+		assert.False(t, signal.LikelyExists)
+	})
+}
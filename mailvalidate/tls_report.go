@@ -0,0 +1,43 @@
+package mailvalidate
+
+import (
+	"github.com/customeros/mailsherpa/internal/mailserver"
+)
+
+// TLSReport is the STARTTLS posture of every MX host of a domain
+type TLSReport = mailserver.TLSReport
+
+// MxTLS is the certificate chain and TLS parameters of one MX host
+type MxTLS = mailserver.MxTLS
+
+// CertificateInfo summarizes a certificate an MX host presented
+type CertificateInfo = mailserver.CertificateInfo
+
+// InspectTLS connects to each MX host of the request's domain over
+// STARTTLS and reports the certificates they present. The request's
+// Scheduler and CircuitBreaker apply as they do to ValidateEmail
+func InspectTLS(validationRequest EmailValidationRequest) (TLSReport, error) {
+	if err := validateRequest(&validationRequest); err != nil {
+		return TLSReport{}, err
+	}
+	if err := ensureDNSRecords(&validationRequest); err != nil {
+		return TLSReport{}, err
+	}
+	if err := assignSenderIdentity(&validationRequest); err != nil {
+		return TLSReport{}, err
+	}
+
+	heloName := validationRequest.SenderIdentity.HeloName
+	if heloName == "" {
+		heloName = validationRequest.FromDomain
+	}
+	return mailserver.InspectTLS(mailserver.TLSInspectionRequest{
+		HeloName: heloName,
+		BindIP:   validationRequest.SenderIdentity.BindIP,
+		// Held to the same rate limits and circuits as validation
+		Admit: func(host string) (func(mailserver.SMPTValidation), error) {
+			return admitHost(&validationRequest, host, senderIdentityName(&validationRequest))
+		},
+		Dns: *validationRequest.Dns,
+	}), nil
+}
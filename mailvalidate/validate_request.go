@@ -2,31 +2,177 @@ package mailvalidate
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/customeros/mailsherpa/domaincheck"
+	"github.com/customeros/mailsherpa/internal/breaker"
+	"github.com/customeros/mailsherpa/internal/mailserver"
+	"github.com/customeros/mailsherpa/internal/publicip"
+	"github.com/customeros/mailsherpa/internal/scheduler"
+	"github.com/customeros/mailsherpa/internal/sender"
 	"github.com/customeros/mailsherpa/internal/util"
 )
 
+// IPResolver discovers the public IP address of the sending mail server
+type IPResolver = publicip.Resolver
+
+// SenderIdentity is a (bind IP, HELO name, MAIL FROM domain) we probe from
+type SenderIdentity = sender.Identity
+
+// SenderPool rotates sender identities across MX hosts
+type SenderPool = sender.Pool
+
+func NewSenderPool(identities []SenderIdentity) *SenderPool {
+	return sender.NewPool(identities)
+}
+
+// Scheduler enforces per MX host, provider and sender rate limits
+type Scheduler = scheduler.Scheduler
+
+type SchedulerConfig = scheduler.Config
+
+// RateLimit is a token bucket of Rate probes per second, up to Burst at once
+type RateLimit = scheduler.Limit
+
+func NewScheduler(config SchedulerConfig) *Scheduler {
+	return scheduler.New(config)
+}
+
+func DefaultSchedulerConfig() SchedulerConfig {
+	return scheduler.DefaultConfig()
+}
+
+// CircuitBreaker stops probing MX hosts after repeated blacklisting, 421s,
+// timeouts or connection failures
+type CircuitBreaker = breaker.Breaker
+
+type CircuitBreakerConfig = breaker.Config
+
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	return breaker.New(config)
+}
+
+func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
+	return breaker.DefaultConfig()
+}
+
+// SenderMode selects the MAIL FROM reverse-path: a generated address or <>
+type SenderMode = mailserver.SenderMode
+
+const (
+	SenderModeAddress = mailserver.SenderModeAddress
+	SenderModeNull    = mailserver.SenderModeNull
+)
+
+// LatencyTracker adapts SMTP command timeouts to each MX host's observed latency
+type LatencyTracker = mailserver.LatencyTracker
+
+func NewLatencyTracker() *LatencyTracker {
+	return mailserver.NewLatencyTracker()
+}
+
+// TLSAResolver looks up DNSSEC-validated TLSA records for DANE
+type TLSAResolver = mailserver.TLSAResolver
+
+// DNSSECResolver is a TLSAResolver backed by a validating recursive resolver
+type DNSSECResolver = mailserver.DNSSECResolver
+
+// LoadSenderPool reads sender identities from a TOML file
+func LoadSenderPool(path string) (*SenderPool, error) {
+	return sender.LoadPool(path)
+}
+
 type EmailValidationRequest struct {
 	Email            string
 	FromDomain       string
 	FromEmail        string
 	CatchAllTestUser string
 	Dns              *domaincheck.DNS
+	// Public IP of the sending mail server. The local address of the SMTP
+	// connection wins when it is publicly routable, as it follows source-IP
+	// rotation. IPResolver is asked when neither is available
+	ServerIP   string
+	IPResolver IPResolver
+	// Pool of sender identities to probe from. When set, each MX host is
+	// probed from its own sticky identity unless SenderIdentity is already
+	// filled in. SenderIdentity then ends up as the identity of the host
+	// that answered
+	SenderPool     *SenderPool
+	SenderIdentity SenderIdentity
+	// Rate limits probes per MX host, provider and sender. Optional
+	Scheduler *Scheduler
+	// Fails fast on MX hosts that keep blocking us or timing out. Optional
+	CircuitBreaker *CircuitBreaker
+	// Greylisted validations are queued here for automatic retry. Optional
+	RetryQueue *RetryQueue
+	// Learns each MX host's reply latency and sizes timeouts to it. Optional
+	LatencyTracker *LatencyTracker
+	// Record the SMTP conversation in EmailValidation.Transcript
+	Transcript TranscriptOptions
+	// When lower-preference MX hosts are tried. Optional, without it or
+	// with FullFailover unset only unreachable hosts are passed over.
+	// Backups are trusted like the primary unless listed in AcceptAllHosts
+	// or found out through Learned
+	Failover *FailoverOptions
+	// Reverse-path used in MAIL FROM. SenderModes overrides it per email
+	// provider, e.g. {"google workspace": SenderModeNull}. Defaults to
+	// SenderModeAddress
+	SenderMode  SenderMode
+	SenderModes map[string]SenderMode
+	// Don't retry MAIL FROM with the other sender mode after a
+	// sender-policy rejection
+	DisableSenderFallback bool
+	// Ask the server with VRFY/EXPN before RCPT TO. Only servers that
+	// answer definitively skip the RCPT TO transaction. VRFY is only sent
+	// to servers advertising it, unless TryUnadvertisedVrfy is set
+	TryVrfy             bool
+	TryUnadvertisedVrfy bool
+	// Greet with EHLO so the MTA can be fingerprinted from its reply too.
+	// Domain validation always does
+	Fingerprint bool
+	// Decoys probed by domain validation's catch-all test. Defaults to
+	// DefaultCatchAllConfig
+	CatchAll *CatchAllConfig
+	// Time repeated RCPT TO for Email against the catch-all decoys to infer
+	// whether the mailbox exists. Email is then probed by domain validation
+	// too. Optional, off when nil
+	TimingInference *TimingInferenceConfig
+	// Look up the domain's MTA-STS and TLS-RPT policies in domain
+	// validation. Costs a TXT lookup and an HTTPS fetch for uncached
+	// policies. The policies are reported, not enforced on the probe.
+	// Optional
+	CheckMailSecurity bool
+	// Verify MX certificates against DANE TLSA records. MX hosts that
+	// publish them are only probed over authenticated STARTTLS. Optional,
+	// off when nil
+	Dane TLSAResolver
+	// Domains registered more recently than this count towards
+	// DomainValidation.IsDisposable. Looked up over RDAP. Optional, off
+	// when zero
+	NewDomainAge time.Duration
 	// applicable only for email validation. Pass results from domain validation
 	DomainValidationParams *DomainValidationParams
+
+	// Recipients probed after Email in the same session, and how many
+	// timed rounds to repeat them for
+	decoys       []string
+	timingRounds int
+	// SenderIdentity was picked from SenderPool for the primary MX, so the
+	// other MX hosts get their own
+	senderPerHost bool
 }
 
 func validateRequest(request *EmailValidationRequest) error {
 	if request.Email == "" {
 		return errors.New("Email is required")
 	}
-	if request.FromDomain == "" {
+	usesSenderIdentity := request.SenderPool != nil || !request.SenderIdentity.IsZero()
+	if request.FromDomain == "" && !usesSenderIdentity {
 		return errors.New("FromDomain is required")
 	}
-	if request.FromEmail == "" {
+	if request.FromEmail == "" && request.FromDomain != "" {
 		firstName, lastName := util.GenerateNames()
 		request.FromEmail = fmt.Sprintf("%s.%s@%s", firstName, lastName, request.FromDomain)
 	}
@@ -35,3 +181,61 @@ func validateRequest(request *EmailValidationRequest) error {
 	}
 	return nil
 }
+
+// assignSenderIdentity picks a sender identity for the domain's primary MX
+// and points the request's sender fields at it
+func assignSenderIdentity(request *EmailValidationRequest) error {
+	if request.SenderIdentity.IsZero() && request.SenderPool != nil {
+		if request.Dns == nil || len(request.Dns.MX) == 0 {
+			return nil
+		}
+		identity, err := request.SenderPool.Pick(request.Dns.MX[0])
+		if err != nil {
+			return err
+		}
+		request.SenderIdentity = identity
+		request.senderPerHost = true
+	}
+
+	if request.SenderIdentity.IsZero() {
+		return nil
+	}
+
+	if request.FromDomain != request.SenderIdentity.FromDomain || request.FromEmail == "" {
+		request.FromDomain = request.SenderIdentity.FromDomain
+		request.FromEmail = request.SenderIdentity.GenerateFromEmail()
+	}
+	return nil
+}
+
+// hostSender returns the sender of an MX host probed from identity. The
+// request's own identity keeps its FromEmail
+func hostSender(request *EmailValidationRequest, identity SenderIdentity) mailserver.Sender {
+	fromEmail := request.FromEmail
+	if identity != request.SenderIdentity {
+		fromEmail = identity.GenerateFromEmail()
+	}
+	return mailserver.Sender{
+		BindIP:     identity.BindIP,
+		HeloName:   identity.Helo(),
+		FromDomain: identity.FromDomain,
+		FromEmail:  fromEmail,
+	}
+}
+
+func senderIdentityName(request *EmailValidationRequest) string {
+	if request.SenderIdentity.IsZero() {
+		return ""
+	}
+	return request.SenderIdentity.String()
+}
+
+// senderMode resolves the MAIL FROM mode for the domain's email provider
+func senderMode(request *EmailValidationRequest) SenderMode {
+	if len(request.SenderModes) > 0 && request.Dns != nil {
+		if mode, ok := request.SenderModes[providerFromMx(*request.Dns)]; ok {
+			return mode
+		}
+	}
+	return request.SenderMode
+}
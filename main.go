@@ -9,23 +9,49 @@ import (
 	"github.com/customeros/mailsherpa/emailparser"
 )
 
+var (
+	transcript = flag.Bool("transcript", false, "include the SMTP conversation in the results")
+	timing     = flag.Bool("timing", false, "infer mailbox existence on catch-all domains from RCPT timing")
+	tlsReport  = flag.Bool("tls", false, "inspect the STARTTLS certificates of the domain's MX hosts")
+	mtaSts     = flag.Bool("mta-sts", false, "look up the domain's MTA-STS and TLS-RPT policies")
+)
+
 func main() {
-	flag.Parse()
-	args := flag.Args()
+	args := parseArgs()
+	if len(args) < 1 {
+		cli.PrintUsage()
+		return
+	}
 
 	switch args[0] {
 	case "domain":
 		if len(args) != 2 {
-			fmt.Println("Usage: mailsherpa domain <domain>")
+			fmt.Println("Usage: mailsherpa domain <domain> [--tls] [--transcript] [--mta-sts]")
 			return
 		}
-		cli.VerifyDomain(args[1], true)
+		if *tlsReport {
+			cli.InspectTLS(args[1])
+			return
+		}
+		cli.VerifyDomain(args[1], cli.Options{Transcript: *transcript, MailSecurity: *mtaSts}, true)
 	case "syntax":
 		if len(args) != 2 {
 			fmt.Println("Usage: mailsherpa syntax <email>")
 			return
 		}
 		cli.VerifySyntax(args[1], true)
+	case "list":
+		if len(args) != 2 {
+			fmt.Println("Usage: mailsherpa list <addresses> [--transcript] [--timing] [--mta-sts]")
+			return
+		}
+		cli.VerifyAddressList(args[1], cli.Options{Transcript: *transcript, Timing: *timing, MailSecurity: *mtaSts})
+	case "extract":
+		if len(args) != 2 {
+			fmt.Println("Usage: mailsherpa extract <file|-> [--transcript] [--timing] [--mta-sts]")
+			return
+		}
+		cli.ExtractEmails(args[1], cli.Options{Transcript: *transcript, Timing: *timing, MailSecurity: *mtaSts})
 	case "redirect":
 		fmt.Println(domaincheck.PrimaryDomainCheck(args[1]))
 	case "parse":
@@ -37,6 +63,22 @@ func main() {
 			cli.PrintUsage()
 			return
 		}
-		cli.VerifyEmail(args[0])
+		cli.VerifyEmail(args[0], cli.Options{Transcript: *transcript, Timing: *timing, MailSecurity: *mtaSts})
+	}
+}
+
+// parseArgs parses flags wherever they appear and returns the positional arguments
+func parseArgs() []string {
+	flag.Parse()
+
+	var positional []string
+	args := flag.Args()
+	for len(args) > 0 {
+		positional = append(positional, args[0])
+		if err := flag.CommandLine.Parse(args[1:]); err != nil {
+			return positional
+		}
+		args = flag.Args()
 	}
+	return positional
 }